@@ -22,12 +22,318 @@
 
 package osn
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// A player's turn is the game state at the start of the turn followed by the
+// actions (selections, moves, attacks, spawns, etc.) taken during that turn.
 type OsnPlayerTurn struct {
+	State   OsnGameState
 	Actions []OsnPlayerAction
-	State   OsnGameState `json:"state"`
 }
 
+// Polymorphic type for the actions of a replay.  The Name() is the same value
+// as the action's "name" property (and its frame's "frameName") in OSN replays.
 type OsnPlayerAction interface {
 	Name() string
 	AsDict() map[string]interface{}
 }
+
+// Constructs a zero-valued action of the type indicated by its name, or an
+// [UnknownAction] if the name is not one of the recognized action types.
+func NewPlayerAction(name string) OsnPlayerAction {
+	if constructor, ok := action_types[name]; ok {
+		return constructor()
+	}
+	return &UnknownAction{name, map[string]interface{}{"name": name}}
+}
+
+var action_types = map[string]func() OsnPlayerAction{
+	"StartTurnAction":       func() OsnPlayerAction { return &StartTurnAction{} },
+	"EndTurnAction":         func() OsnPlayerAction { return &EndTurnAction{} },
+	"SelectUnitAction":      func() OsnPlayerAction { return &SelectUnitAction{} },
+	"MoveUnitAction":        func() OsnPlayerAction { return &MoveUnitAction{} },
+	"RangeAttackAction":     func() OsnPlayerAction { return &RangeAttackAction{} },
+	"ActiveHealAction":      func() OsnPlayerAction { return &ActiveHealAction{} },
+	"TransformAction":       func() OsnPlayerAction { return &TransformAction{} },
+	"SelectSpawnTileAction": func() OsnPlayerAction { return &SelectSpawnTileAction{} },
+	"SpawnUnitAction":       func() OsnPlayerAction { return &SpawnUnitAction{} },
+	"EatAction":             func() OsnPlayerAction { return &EatAction{} },
+	"SpitAction":            func() OsnPlayerAction { return &SpitAction{} },
+	"ScramblerSpellAction":  func() OsnPlayerAction { return &ScramblerSpellAction{} },
+}
+
+// Marks the start of a player's turn, always the first action after a state.
+type StartTurnAction struct{}
+
+func (StartTurnAction) Name() string { return "StartTurnAction" }
+func (action StartTurnAction) AsDict() map[string]interface{} {
+	return map[string]interface{}{"name": action.Name()}
+}
+
+// Marks the end of a player's turn.  Absent from the final turn of a replay.
+type EndTurnAction struct{}
+
+func (EndTurnAction) Name() string { return "EndTurnAction" }
+func (action EndTurnAction) AsDict() map[string]interface{} {
+	return map[string]interface{}{"name": action.Name()}
+}
+
+// A unit was tapped on (no effect on game state, touch is in screen units).
+type SelectUnitAction struct {
+	PawnID int     `json:"pawnID"`
+	TouchX float64 `json:"touchX"`
+	TouchY float64 `json:"touchY"`
+}
+
+func (SelectUnitAction) Name() string { return "SelectUnitAction" }
+func (action SelectUnitAction) AsDict() map[string]interface{} {
+	return map[string]interface{}{
+		"name":   action.Name(),
+		"pawnID": action.PawnID,
+		"touchX": action.TouchX,
+		"touchY": action.TouchY,
+	}
+}
+
+// The unit (by its pawn ID) moves to the destination (column I, row J).
+// A cancelled move is still recorded but the unit's position is unaffected.
+type MoveUnitAction struct {
+	PawnID    int  `json:"pawnID"`
+	DestI     int  `json:"desti"`
+	DestJ     int  `json:"destj"`
+	Cancelled bool `json:"cancelled"`
+}
+
+func (MoveUnitAction) Name() string { return "MoveUnitAction" }
+func (action MoveUnitAction) AsDict() map[string]interface{} {
+	return map[string]interface{}{
+		"name":      action.Name(),
+		"pawnID":    action.PawnID,
+		"desti":     action.DestI,
+		"destj":     action.DestJ,
+		"cancelled": action.Cancelled,
+	}
+}
+
+// Common shape of actions where a unit (by its pawn ID) targets a hex.
+type TargetedAction struct {
+	PawnID int `json:"pawnID"`
+	DestI  int `json:"desti"`
+	DestJ  int `json:"destj"`
+}
+
+func (target TargetedAction) dict(name string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":   name,
+		"pawnID": target.PawnID,
+		"desti":  target.DestI,
+		"destj":  target.DestJ,
+	}
+}
+
+// Any unit's attack, including adjacent (melee) attacks.
+type RangeAttackAction struct{ TargetedAction }
+
+func (RangeAttackAction) Name() string { return "RangeAttackAction" }
+func (action RangeAttackAction) AsDict() map[string]interface{} {
+	return action.dict(action.Name())
+}
+
+// A medic healing the unit at the target hex.
+type ActiveHealAction struct{ TargetedAction }
+
+func (ActiveHealAction) Name() string { return "ActiveHealAction" }
+func (action ActiveHealAction) AsDict() map[string]interface{} {
+	return action.dict(action.Name())
+}
+
+// A unit switching into (or out of) its alternate form.
+//
+// The name follows the client's naming of other actions but has not yet been
+// observed in the sampled replays.  Its destination is the unit's own position.
+type TransformAction struct{ TargetedAction }
+
+func (TransformAction) Name() string { return "TransformAction" }
+func (action TransformAction) AsDict() map[string]interface{} {
+	return action.dict(action.Name())
+}
+
+// The Adorables' special (Mobi) swallowing an adjacent friendly unit.
+type EatAction struct{ TargetedAction }
+
+func (EatAction) Name() string { return "EatAction" }
+func (action EatAction) AsDict() map[string]interface{} {
+	return action.dict(action.Name())
+}
+
+// The Adorables' special (Mobi) releasing the unit it had eaten.
+type SpitAction struct{ TargetedAction }
+
+func (SpitAction) Name() string { return "SpitAction" }
+func (action SpitAction) AsDict() map[string]interface{} {
+	return action.dict(action.Name())
+}
+
+// The Feedback special (Scrambler) converting an enemy unit to its own team.
+type ScramblerSpellAction struct{ TargetedAction }
+
+func (ScramblerSpellAction) Name() string { return "ScramblerSpellAction" }
+func (action ScramblerSpellAction) AsDict() map[string]interface{} {
+	return action.dict(action.Name())
+}
+
+// A spawn tile was selected, the same coordinates as a [UsedSpawn].
+type SelectSpawnTileAction struct {
+	SpawnX int `json:"ix"`
+	SpawnY int `json:"iy"`
+}
+
+func (SelectSpawnTileAction) Name() string { return "SelectSpawnTileAction" }
+func (action SelectSpawnTileAction) AsDict() map[string]interface{} {
+	return map[string]interface{}{
+		"name": action.Name(),
+		"ix":   action.SpawnX,
+		"iy":   action.SpawnY,
+	}
+}
+
+// A unit of the indicated class is spawned at the most recently selected spawn.
+type SpawnUnitAction struct {
+	Role  UnitClass       `json:"role"`
+	Color PlayerColorEnum `json:"color"`
+}
+
+func (SpawnUnitAction) Name() string { return "SpawnUnitAction" }
+func (action SpawnUnitAction) AsDict() map[string]interface{} {
+	return map[string]interface{}{
+		"name":  action.Name(),
+		"role":  action.Role,
+		"color": action.Color,
+	}
+}
+
+// Retains any action not recognized above, so that it may still be re-encoded.
+type UnknownAction struct {
+	name   string
+	fields map[string]interface{}
+}
+
+func (action UnknownAction) Name() string { return action.name }
+func (action UnknownAction) AsDict() map[string]interface{} {
+	return action.fields
+}
+
+func (action *UnknownAction) UnmarshalJSON(encoded []byte) error {
+	return json.Unmarshal(encoded, &action.fields)
+}
+
+// The frame types found in a replay; frames are either a state or an action.
+type FrameType uint8
+
+const (
+	FRAME_UNKNOWN FrameType = iota
+	FRAME_ACTION
+	FRAME_STATE
+)
+
+// The representation of each replay frame as it appears in OSN's replay data.
+// The frame data is itself a JSON-encoded string of an action or a game state.
+type osnFrame struct {
+	Name string    `json:"frameName"`
+	Type FrameType `json:"frameType"`
+	Data string    `json:"frameData"`
+}
+
+type actionFrame struct {
+	Action json.RawMessage `json:"action"`
+}
+
+type stateFrame struct {
+	State OsnGameState `json:"gameState"`
+}
+
+// Decodes the flat sequence of replay frames, grouping the actions that follow
+// each state frame into an [OsnPlayerTurn].
+func (replay *OsnReplay) UnmarshalJSON(encoded []byte) error {
+	var frames []osnFrame
+	if err := json.Unmarshal(encoded, &frames); err != nil {
+		return err
+	}
+
+	turns := make([]OsnPlayerTurn, 0)
+	for i, frame := range frames {
+		switch frame.Type {
+		case FRAME_STATE:
+			var state stateFrame
+			if err := json.Unmarshal([]byte(frame.Data), &state); err != nil {
+				return fmt.Errorf("replay frame %d: %w", i, err)
+			}
+			turns = append(turns, OsnPlayerTurn{state.State, []OsnPlayerAction{}})
+		case FRAME_ACTION:
+			if len(turns) == 0 {
+				return fmt.Errorf("replay frame %d: action precedes any state", i)
+			}
+			action, err := decode_action(frame)
+			if err != nil {
+				return fmt.Errorf("replay frame %d: %w", i, err)
+			}
+			turn := &turns[len(turns)-1]
+			turn.Actions = append(turn.Actions, action)
+		default:
+			return fmt.Errorf("replay frame %d: unrecognized frame type %d",
+				i, frame.Type)
+		}
+	}
+
+	*replay = turns
+	return nil
+}
+
+func decode_action(frame osnFrame) (OsnPlayerAction, error) {
+	var wrapper actionFrame
+	if err := json.Unmarshal([]byte(frame.Data), &wrapper); err != nil {
+		return nil, err
+	}
+	var named struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(wrapper.Action, &named); err != nil {
+		return nil, err
+	}
+	if named.Name != frame.Name {
+		return nil, fmt.Errorf("action name %s does not match frame name %s",
+			named.Name, frame.Name)
+	}
+
+	action := NewPlayerAction(named.Name)
+	if err := json.Unmarshal(wrapper.Action, action); err != nil {
+		return nil, err
+	}
+	return action, nil
+}
+
+// Encodes the replay turns back into OSN's flat sequence of replay frames.
+func (replay OsnReplay) MarshalJSON() ([]byte, error) {
+	frames := make([]osnFrame, 0)
+	for _, turn := range replay {
+		data, err := json.Marshal(stateFrame{turn.State})
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, osnFrame{"State", FRAME_STATE, string(data)})
+
+		for _, action := range turn.Actions {
+			data, err := json.Marshal(map[string]interface{}{
+				"action": action.AsDict()})
+			if err != nil {
+				return nil, err
+			}
+			frames = append(frames,
+				osnFrame{action.Name(), FRAME_ACTION, string(data)})
+		}
+	}
+	return json.Marshal(frames)
+}
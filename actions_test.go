@@ -0,0 +1,195 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github.com/kevindamm/wits-osn/actions_test.go
+
+package osn_test
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+
+	osn "github.com/kevindamm/wits-osn"
+)
+
+const testReplayPath = "testdata/ahRzfm91dHdpdHRlcnNnYW1lLWhyZHIVCxIIR2FtZVJvb20YgIDQlK_hqAoM.json"
+
+// Reads the doubly-wrapped replay and returns the innermost game state bytes.
+func read_test_replay(t *testing.T) []byte {
+	filedata, err := os.ReadFile(testReplayPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var on_wire osn.WireFormat
+	if err := json.Unmarshal(filedata, &on_wire); err != nil {
+		t.Fatal(err)
+	}
+	var inner struct {
+		GameState json.RawMessage `json:"gameState"`
+	}
+	if err := json.Unmarshal([]byte(on_wire.Wrapper.Wrapper), &inner); err != nil {
+		t.Fatal(err)
+	}
+	return inner.GameState
+}
+
+func TestDecodeReplay(t *testing.T) {
+	var match osn.LegacyMatchWithReplay
+	if err := json.Unmarshal(read_test_replay(t), &match); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(match.Replay) != 15 {
+		t.Errorf("expected 15 turns, got %d", len(match.Replay))
+	}
+	if match.Terminal == nil || len(match.Terminal.Winners) != 1 {
+		t.Fatalf("expected game over data with a single winner")
+	}
+	if match.Terminal.Winners[0].OldLeague != osn.LEAGUE_GIFTED {
+		t.Errorf("winner's league %s, expected Gifted",
+			match.Terminal.Winners[0].OldLeague)
+	}
+
+	first := match.Replay[0]
+	if first.State.TurnCount != 1 || len(first.State.Settings) != 2 {
+		t.Errorf("unexpected initial state %v", first.State)
+	}
+	if len(first.Actions) != 12 {
+		t.Fatalf("expected 12 actions in first turn, got %d", len(first.Actions))
+	}
+	if _, ok := first.Actions[0].(*osn.StartTurnAction); !ok {
+		t.Errorf("first action %s, expected StartTurnAction", first.Actions[0].Name())
+	}
+	heal, ok := first.Actions[2].(*osn.ActiveHealAction)
+	if !ok {
+		t.Fatalf("third action %s, expected ActiveHealAction", first.Actions[2].Name())
+	}
+	if heal.PawnID != 7 || heal.DestI != 10 || heal.DestJ != 4 {
+		t.Errorf("incorrect heal action %v", heal)
+	}
+	spawn, ok := first.Actions[10].(*osn.SpawnUnitAction)
+	if !ok {
+		t.Fatalf("action %s, expected SpawnUnitAction", first.Actions[10].Name())
+	}
+	if spawn.Role != 2 || spawn.Color != osn.PLAYERCOLOR_BLUE {
+		t.Errorf("incorrect spawn action %v", spawn)
+	}
+
+	counts := make(map[string]int)
+	for _, turn := range match.Replay {
+		for _, action := range turn.Actions {
+			if _, unknown := action.(*osn.UnknownAction); unknown {
+				t.Errorf("unrecognized action %s", action.Name())
+			}
+			counts[action.Name()] += 1
+		}
+	}
+	if counts["MoveUnitAction"] != 48 || counts["RangeAttackAction"] != 17 {
+		t.Errorf("unexpected action counts %v", counts)
+	}
+}
+
+func TestReplayRoundTrip(t *testing.T) {
+	var original struct {
+		Replay json.RawMessage `json:"replay"`
+	}
+	if err := json.Unmarshal(read_test_replay(t), &original); err != nil {
+		t.Fatal(err)
+	}
+
+	var replay osn.OsnReplay
+	if err := json.Unmarshal(original.Replay, &replay); err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := json.Marshal(replay)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := frames_as_values(t, original.Replay)
+	actual := frames_as_values(t, encoded)
+	if len(expected) != len(actual) {
+		t.Fatalf("frame count %d, expected %d", len(actual), len(expected))
+	}
+	for i := range expected {
+		if !reflect.DeepEqual(expected[i], actual[i]) {
+			t.Errorf("frame %d differs;\ngot  %v\nwant %v", i, actual[i], expected[i])
+		}
+	}
+}
+
+func TestUnknownActionRoundTrip(t *testing.T) {
+	encoded := []byte(`[
+    {"frameName": "State", "frameType": 2, "frameData": "{\"gameState\": {}}"},
+    {"frameName": "BrambleAction", "frameType": 1,
+     "frameData": "{\"action\": {\"name\": \"BrambleAction\", \"pawnID\": 3}}"}]`)
+
+	var replay osn.OsnReplay
+	if err := json.Unmarshal(encoded, &replay); err != nil {
+		t.Fatal(err)
+	}
+	action := replay[0].Actions[0]
+	if action.Name() != "BrambleAction" || action.AsDict()["pawnID"] != 3.0 {
+		t.Errorf("unknown action not retained: %v", action.AsDict())
+	}
+
+	if _, err := json.Marshal(replay); err != nil {
+		t.Error(err)
+	}
+}
+
+// Decodes each frame's (string-encoded) data so that frames can be compared
+// independent of property order.  Booleans are normalized to 0 or 1 because
+// OSN encodes some flags as integers, but [osn.Boolish] re-encodes them as bool.
+func frames_as_values(t *testing.T, encoded []byte) []map[string]interface{} {
+	var frames []map[string]interface{}
+	if err := json.Unmarshal(encoded, &frames); err != nil {
+		t.Fatal(err)
+	}
+	for _, frame := range frames {
+		var data interface{}
+		if err := json.Unmarshal([]byte(frame["frameData"].(string)), &data); err != nil {
+			t.Fatal(err)
+		}
+		frame["frameData"] = normalize_bools(data)
+	}
+	return frames
+}
+
+func normalize_bools(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case bool:
+		if typed {
+			return 1.0
+		}
+		return 0.0
+	case map[string]interface{}:
+		for key, inner := range typed {
+			typed[key] = normalize_bools(inner)
+		}
+	case []interface{}:
+		for i, inner := range typed {
+			typed[i] = normalize_bools(inner)
+		}
+	}
+	return value
+}
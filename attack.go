@@ -0,0 +1,169 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/attack.go
+
+package osn
+
+import "slices"
+
+// A hex which a unit may attack, and the enemy unit or base found there.
+type AttackTarget struct {
+	Position HexCoord
+	Distance int
+
+	// The unit at the target position, or nil if the target is a base.
+	Unit *UnitStatus
+	// The owner of the base at the target position, if Unit is nil.
+	BaseOwner PlayerIndex
+}
+
+func (target AttackTarget) IsBase() bool {
+	return target.Unit == nil
+}
+
+// The hexes within an attacking unit's range and the targets found among them.
+//
+// The legacy game has no occlusion; blocked tiles and other units between the
+// attacker and its target do not prevent the attack (historical replays have
+// snipers firing over rocks and units alike), and neither do empty tiles.
+// Only the hex distance and the attacker's range determine line of sight.
+type AttackRange struct {
+	Attacker UnitStatus
+	Range    int
+
+	hexes   []HexCoord
+	targets []AttackTarget
+}
+
+// Computes the attack range of `attacker` among the other `units` on the map.
+// Units which deal no damage (medics, for example) have no targets but still
+// report the hexes within their range.
+func NewAttackRange(legacymap LegacyMap, units []UnitStatus, attacker UnitStatus) (AttackRange, error) {
+	stats, err := attacker.Stats()
+	if err != nil {
+		return AttackRange{}, err
+	}
+	attack := AttackRange{
+		Attacker: attacker,
+		Range:    int(stats.AttackRange)}
+
+	origin := attacker.Position()
+	occupants := make(map[HexCoord]int)
+	teams := make(map[PlayerIndex]uint)
+	for i, unit := range units {
+		occupants[unit.Position()] = i
+		teams[PlayerIndex(unit.Owner)] = unit.Team
+	}
+
+	for _, coord := range origin.Range(attack.Range)[1:] {
+		if !legacymap.Contains(coord) || legacymap.TileAt(coord) == MAPTILE_EMPTY {
+			continue
+		}
+		attack.hexes = append(attack.hexes, coord)
+		if stats.Damage == 0 {
+			continue
+		}
+
+		target := AttackTarget{Position: coord, Distance: origin.Distance(coord)}
+		if i, occupied := occupants[coord]; occupied {
+			if units[i].Team == attacker.Team {
+				continue
+			}
+			target.Unit = &units[i]
+		} else if owner, isbase := legacymap.BaseAt(coord); isbase {
+			if !is_enemy_base(owner, attacker, teams) {
+				continue
+			}
+			target.BaseOwner = owner
+		} else {
+			continue
+		}
+		attack.targets = append(attack.targets, target)
+	}
+
+	sort_coords(attack.hexes)
+	slices.SortFunc(attack.targets, func(a, b AttackTarget) int {
+		return compare_coords(a.Position, b.Position)
+	})
+	return attack, nil
+}
+
+// Bases are identified by their owner, which is resolved to a team by way of
+// the units that player owns.  Without any such units, only the attacker's own
+// base is considered friendly.
+func is_enemy_base(owner PlayerIndex, attacker UnitStatus, teams map[PlayerIndex]uint) bool {
+	if owner == PlayerIndex(attacker.Owner) {
+		return false
+	}
+	if team, known := teams[owner]; known {
+		return team != attacker.Team
+	}
+	return true
+}
+
+// All hexes on the map within range of the attacker, in column-major order.
+func (attack AttackRange) Hexes() []HexCoord {
+	return attack.hexes
+}
+
+// The enemy units and bases within range of the attacker, in column-major order.
+func (attack AttackRange) Targets() []AttackTarget {
+	return attack.targets
+}
+
+// True if there is an enemy unit or base at the indicated position within range.
+func (attack AttackRange) CanTarget(coord HexCoord) bool {
+	for _, target := range attack.targets {
+		if target.Position == coord {
+			return true
+		}
+	}
+	return false
+}
+
+// True if the indicated position is exactly at the attacker's maximum range.
+func (attack AttackRange) IsMaxRange(coord HexCoord) bool {
+	return attack.Range > 0 &&
+		attack.Attacker.Position().Distance(coord) == attack.Range
+}
+
+// The legal attack actions for this unit, one for each target.
+func (attack AttackRange) Actions() []OsnPlayerAction {
+	actions := make([]OsnPlayerAction, 0, len(attack.targets))
+	for _, target := range attack.targets {
+		actions = append(actions, &RangeAttackAction{TargetedAction{
+			PawnID: int(attack.Attacker.Identifier),
+			DestI:  target.Position.Column,
+			DestJ:  target.Position.Row}})
+	}
+	return actions
+}
+
+func compare_coords(a, b HexCoord) int {
+	if a.Column != b.Column {
+		return a.Column - b.Column
+	}
+	return a.Row - b.Row
+}
+
+func sort_coords(coords []HexCoord) {
+	slices.SortFunc(coords, compare_coords)
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/attack_test.go
+
+package osn_test
+
+import (
+	"testing"
+
+	osn "github.com/kevindamm/wits-osn"
+)
+
+func TestAttackTargets(t *testing.T) {
+	legacymap := walled_map()
+	legacymap.Init = append(legacymap.Init,
+		osn.MapTileInit{I: 4, J: 1, Type: osn.MAPTILE_BASE, Owner: 2})
+
+	sniper := unit_at(1, 1, 1)
+	sniper.Class = osn.CLASS_SNIPER
+	sniper.Owner = 1
+	ally := unit_at(1, 2, 1)
+	enemy := unit_at(3, 3, 2)
+	enemy.Owner = 2
+	distant := unit_at(3, 4, 2)
+	distant.Owner = 2
+	units := []osn.UnitStatus{sniper, ally, enemy, distant}
+
+	attack, err := osn.NewAttackRange(legacymap, units, sniper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attack.Range != 3 {
+		t.Errorf("sniper range %d, expected 3", attack.Range)
+	}
+	for _, coord := range attack.Hexes() {
+		if sniper.Position().Distance(coord) > 3 || coord == sniper.Position() {
+			t.Errorf("hex %v outside of range", coord)
+		}
+		if !legacymap.Contains(coord) {
+			t.Errorf("hex %v outside of map", coord)
+		}
+	}
+
+	// The wall between the sniper and the enemy does not occlude the attack.
+	if !attack.CanTarget(enemy.Position()) {
+		t.Errorf("cannot target enemy at %v", enemy.Position())
+	}
+	if !attack.IsMaxRange(enemy.Position()) {
+		t.Errorf("enemy at %v not at max range", enemy.Position())
+	}
+	if attack.CanTarget(ally.Position()) {
+		t.Error("can target an ally")
+	}
+	if attack.CanTarget(distant.Position()) {
+		t.Error("can target an enemy out of range")
+	}
+
+	// The base covers (4, 1) and its neighbors (3, 0), (3, 1), (4, 0) and (4, 2),
+	// all of which are within range.  Its other neighbors are off of the map.
+	bases := 0
+	for _, target := range attack.Targets() {
+		if target.IsBase() {
+			bases++
+			if target.BaseOwner != 2 {
+				t.Errorf("base at %v owned by %d", target.Position, target.BaseOwner)
+			}
+		}
+	}
+	if bases != 5 {
+		t.Errorf("expected 5 base hexes in range, found %d", bases)
+	}
+	if len(attack.Actions()) != len(attack.Targets()) {
+		t.Errorf("%d actions for %d targets", len(attack.Actions()), len(attack.Targets()))
+	}
+
+	medic := unit_at(0, 4, 2)
+	medic.Class = osn.CLASS_MEDIC
+	heal, err := osn.NewAttackRange(legacymap, append(units, medic), medic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(heal.Targets()) != 0 || len(heal.Hexes()) == 0 {
+		t.Errorf("medic has %d targets in %d hexes", len(heal.Targets()), len(heal.Hexes()))
+	}
+}
+
+// Every attack in the sample replay should be against a legal target, and
+// some of them (the sniper shots over rocks) are at maximum range.
+func TestReplayAttacksAreLegal(t *testing.T) {
+	attacks, maxrange := 0, 0
+	walk_sample_replay(t, func(turn int, legacymap osn.LegacyMap, units []osn.UnitStatus, action osn.OsnPlayerAction) {
+		rangeattack, ok := action.(*osn.RangeAttackAction)
+		if !ok {
+			return
+		}
+		var attacker *osn.UnitStatus
+		for i := range units {
+			if units[i].Identifier == uint(rangeattack.PawnID) {
+				attacker = &units[i]
+			}
+		}
+		if attacker == nil {
+			t.Errorf("turn %d: attack by unknown unit %d", turn, rangeattack.PawnID)
+			return
+		}
+		attack, err := osn.NewAttackRange(legacymap, units, *attacker)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dest := osn.HexCoord{Column: rangeattack.DestI, Row: rangeattack.DestJ}
+		if !attack.CanTarget(dest) {
+			t.Errorf("turn %d: unit %d (%s) cannot target %v from %v",
+				turn, attacker.Identifier, attacker.Class, dest, attacker.Position())
+		}
+		if attack.Range > 1 && attack.IsMaxRange(dest) {
+			maxrange++
+		}
+		attacks++
+	})
+	if attacks == 0 {
+		t.Error("no attacks checked in the sample replay")
+	}
+	if maxrange == 0 {
+		t.Error("expected some max-range attacks in the sample replay")
+	}
+}
@@ -37,15 +37,15 @@ type Boolish bool
 
 func (b *Boolish) UnmarshalJSON(encoded []byte) error {
 	var boolVal bool
-	if err := json.Unmarshal(encoded, &boolVal); err != nil {
+	if err := json.Unmarshal(encoded, &boolVal); err == nil {
 		*b = Boolish(boolVal)
 	} else {
 		var intVal int
-		if err := json.Unmarshal(encoded, &intVal); err != nil {
+		if err := json.Unmarshal(encoded, &intVal); err == nil {
 			*b = Boolish(intVal != 0)
 		} else {
 			var strVal string
-			if err := json.Unmarshal(encoded, &strVal); err != nil {
+			if err := json.Unmarshal(encoded, &strVal); err == nil {
 				*b = Boolish(!(strVal == "" || strVal == "0"))
 			} else {
 				return fmt.Errorf("failed to convert [%s] to Boolish type", encoded)
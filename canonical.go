@@ -0,0 +1,248 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/canonical.go
+
+package osn
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Rewrites the match so that its first player starts from the position of the
+// map's first player (the base with the least owner), using one of the map's
+// symmetries.  Where more than one symmetry would do, the one which gives the
+// least sequence of unit positions and action targets is used, so that mirrored
+// games have the same canonical form.  Returns the rewritten match and the
+// symmetry that was applied, which is the identity if no rewrite was possible.
+func Canonicalize(match LegacyMatchWithReplay) (LegacyMatchWithReplay, MapSymmetry, error) {
+	identity := MapSymmetry{}
+	if len(match.Replay) == 0 {
+		return match, identity, ErrEmptyReplay
+	}
+	initial := match.Replay[0].State
+	if int(initial.CurrentPlayer) >= len(initial.Settings) {
+		return match, identity, fmt.Errorf(
+			"current player %d has no settings", initial.CurrentPlayer)
+	}
+	legacymap, err := MapByName(initial.MapName)
+	if err != nil {
+		return match, identity, err
+	}
+	symmetries := legacymap.Symmetries()
+	if len(symmetries) == 0 {
+		return match, identity, fmt.Errorf("map %s has no layout", legacymap.Name)
+	}
+
+	first := PlayerIndex(initial.Settings[initial.CurrentPlayer].PlayerID)
+	canonical := first
+	for owner := range symmetries[0].Owners {
+		canonical = min(canonical, owner)
+	}
+
+	best, best_symmetry := match, symmetries[0]
+	var best_key []HexCoord
+	for _, symmetry := range symmetries {
+		if symmetry.Owners[first] != canonical {
+			continue
+		}
+		rewritten := symmetry.ApplyMatch(match)
+		key := canonical_key(rewritten)
+		if best_key == nil || slices.CompareFunc(key, best_key, compare_coords) < 0 {
+			best, best_symmetry, best_key = rewritten, symmetry, key
+		}
+	}
+	return best, best_symmetry, nil
+}
+
+// The positions of the initial units (by identifier) then every action target.
+func canonical_key(match LegacyMatchWithReplay) []HexCoord {
+	units := slices.Clone(match.Replay[0].State.Units)
+	slices.SortFunc(units, func(a, b UnitStatus) int {
+		return int(a.Identifier) - int(b.Identifier)
+	})
+	key := make([]HexCoord, 0, len(units))
+	for _, unit := range units {
+		key = append(key, unit.Position())
+	}
+	for _, turn := range match.Replay {
+		for _, action := range turn.Actions {
+			if target, ok := action_target(action); ok {
+				key = append(key, target)
+			}
+		}
+	}
+	return key
+}
+
+// The board position an action refers to, if it has one.
+func action_target(action OsnPlayerAction) (HexCoord, bool) {
+	switch action := action.(type) {
+	case *MoveUnitAction:
+		return HexCoord{action.DestI, action.DestJ}, true
+	case *RangeAttackAction:
+		return HexCoord{action.DestI, action.DestJ}, true
+	case *ActiveHealAction:
+		return HexCoord{action.DestI, action.DestJ}, true
+	case *TransformAction:
+		return HexCoord{action.DestI, action.DestJ}, true
+	case *EatAction:
+		return HexCoord{action.DestI, action.DestJ}, true
+	case *SpitAction:
+		return HexCoord{action.DestI, action.DestJ}, true
+	case *ScramblerSpellAction:
+		return HexCoord{action.DestI, action.DestJ}, true
+	case *SelectSpawnTileAction:
+		return HexCoord{action.SpawnX, action.SpawnY}, true
+	}
+	return HexCoord{}, false
+}
+
+// Applies the symmetry to every recorded state and action of the match.  The
+// players keep their turn order but swap places on the board (and their owner
+// and team identities) as the symmetry's Owners indicates.  The screen position
+// of a SelectUnitAction is not rewritten.
+func (symmetry MapSymmetry) ApplyMatch(match LegacyMatchWithReplay) LegacyMatchWithReplay {
+	if len(match.Replay) == 0 {
+		return match
+	}
+	relabel := symmetry.relabeling(match.Replay[0].State.Settings)
+
+	match.OsnGameState = relabel.state(match.OsnGameState)
+	replay := make(OsnReplay, len(match.Replay))
+	for i, turn := range match.Replay {
+		replay[i].State = relabel.state(turn.State)
+		replay[i].Actions = make([]OsnPlayerAction, len(turn.Actions))
+		for j, action := range turn.Actions {
+			replay[i].Actions[j] = relabel.action(action)
+		}
+	}
+	match.Replay = replay
+	return match
+}
+
+// The board transform along with the owner and team identities it exchanges.
+type relabeling struct {
+	HexTransform
+	owners map[uint]uint
+	teams  map[uint]uint
+}
+
+func (symmetry MapSymmetry) relabeling(settings []OsnRoleSettings) relabeling {
+	relabel := relabeling{symmetry.HexTransform,
+		make(map[uint]uint), make(map[uint]uint)}
+	team_of := make(map[uint]uint)
+	for _, player := range settings {
+		team_of[uint(player.PlayerID)] = player.Team
+	}
+	for from, to := range symmetry.Owners {
+		relabel.owners[uint(from)] = uint(to)
+		if team, ok := team_of[uint(from)]; ok {
+			if other, ok := team_of[uint(to)]; ok {
+				relabel.teams[team] = other
+			}
+		}
+	}
+	return relabel
+}
+
+func (relabel relabeling) owner(owner uint) uint {
+	if to, ok := relabel.owners[owner]; ok {
+		return to
+	}
+	return owner
+}
+
+func (relabel relabeling) team(team uint) uint {
+	if to, ok := relabel.teams[team]; ok {
+		return to
+	}
+	return team
+}
+
+func (relabel relabeling) state(state OsnGameState) OsnGameState {
+	state = state.clone()
+	for i, tile := range state.CapturedTiles {
+		coord := relabel.Apply(HexCoord{int(tile.I), int(tile.J)})
+		state.CapturedTiles[i].I, state.CapturedTiles[i].J = uint(coord.Column), uint(coord.Row)
+		if team := tile.Type.Team(); team != 0 {
+			state.CapturedTiles[i].Type = TileCapturedBy(relabel.team(team))
+		}
+	}
+	for i, spawn := range state.UsedSpawns {
+		coord := relabel.Apply(HexCoord{spawn.SpawnX, spawn.SpawnY})
+		state.UsedSpawns[i] = UsedSpawn{coord.Column, coord.Row}
+	}
+	for i := range state.Settings {
+		settings := &state.Settings[i]
+		settings.PlayerID = int64(relabel.owner(uint(settings.PlayerID)))
+		settings.Team = relabel.team(settings.Team)
+	}
+	for i := range state.Units {
+		unit := &state.Units[i]
+		coord := relabel.Apply(unit.Position())
+		unit.PositionI, unit.PositionJ = uint(coord.Column), uint(coord.Row)
+		unit.Owner = relabel.owner(unit.Owner)
+		unit.Team = relabel.team(unit.Team)
+	}
+	sort_units(state.Units)
+
+	// Base health is kept by team, as is the outcome.
+	if relabel.team(1) == 2 {
+		state.Base0_HP, state.Base1_HP = state.Base1_HP, state.Base0_HP
+	}
+	if state.Outcome > 0 {
+		state.Outcome = GameStatus(relabel.team(uint(state.Outcome)))
+	}
+	return state
+}
+
+func (relabel relabeling) action(action OsnPlayerAction) OsnPlayerAction {
+	switch action := action.(type) {
+	case *MoveUnitAction:
+		moved := *action
+		coord := relabel.Apply(HexCoord{action.DestI, action.DestJ})
+		moved.DestI, moved.DestJ = coord.Column, coord.Row
+		return &moved
+	case *RangeAttackAction:
+		return &RangeAttackAction{relabel.targeted(action.TargetedAction)}
+	case *ActiveHealAction:
+		return &ActiveHealAction{relabel.targeted(action.TargetedAction)}
+	case *TransformAction:
+		return &TransformAction{relabel.targeted(action.TargetedAction)}
+	case *EatAction:
+		return &EatAction{relabel.targeted(action.TargetedAction)}
+	case *SpitAction:
+		return &SpitAction{relabel.targeted(action.TargetedAction)}
+	case *ScramblerSpellAction:
+		return &ScramblerSpellAction{relabel.targeted(action.TargetedAction)}
+	case *SelectSpawnTileAction:
+		coord := relabel.Apply(HexCoord{action.SpawnX, action.SpawnY})
+		return &SelectSpawnTileAction{SpawnX: coord.Column, SpawnY: coord.Row}
+	}
+	return action
+}
+
+func (relabel relabeling) targeted(action TargetedAction) TargetedAction {
+	coord := relabel.Apply(HexCoord{action.DestI, action.DestJ})
+	action.DestI, action.DestJ = coord.Column, coord.Row
+	return action
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/canonical_test.go
+
+package osn_test
+
+import (
+	"reflect"
+	"testing"
+
+	osn "github.com/kevindamm/wits-osn"
+)
+
+// The sample's first player already starts from the first base.
+func TestCanonicalSample(t *testing.T) {
+	match := read_sample_match(t)
+	canonical, symmetry, err := osn.Canonicalize(match)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !symmetry.IsIdentity() {
+		t.Errorf("expected the identity, got %+v", symmetry)
+	}
+	if !reflect.DeepEqual(canonical.Replay, match.Replay) {
+		t.Error("canonical replay differs from the original")
+	}
+}
+
+// Mirroring the sample gives a replay where the second base's owner moves
+// first, which is still a valid replay and canonicalizes back to the sample.
+func TestCanonicalMirroredSample(t *testing.T) {
+	match := read_sample_match(t)
+	legacymap, err := osn.MapByName(match.MapName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	swap := legacymap.Symmetries()[1]
+	mirrored := swap.ApplyMatch(match)
+
+	initial := mirrored.Replay[0].State
+	if initial.Settings[0].PlayerID != 2 || initial.Settings[0].Team != 2 {
+		t.Errorf("expected the first player to be relabeled, got %+v",
+			initial.Settings[0])
+	}
+	if mirrored.Outcome != 1 || mirrored.Base1_HP != match.Base0_HP {
+		t.Errorf("expected the outcome and base health to follow the teams")
+	}
+	if err := osn.ValidateReplay(mirrored); err != nil {
+		t.Errorf("mirrored replay is invalid: %s", err)
+	}
+
+	canonical, symmetry, err := osn.Canonicalize(mirrored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if symmetry.HexTransform != swap.HexTransform {
+		t.Errorf("expected %+v, got %+v", swap, symmetry)
+	}
+	for i := range match.Replay {
+		if !reflect.DeepEqual(canonical.Replay[i], match.Replay[i]) {
+			t.Errorf("turn %d differs after canonicalization", i)
+		}
+	}
+	if !reflect.DeepEqual(canonical.OsnGameState, match.OsnGameState) {
+		t.Error("final state differs after canonicalization")
+	}
+}
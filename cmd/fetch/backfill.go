@@ -24,122 +24,478 @@ package main
 
 import (
 	"bufio"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	osn "github.com/kevindamm/wits-osn"
 	"github.com/kevindamm/wits-osn/db"
+	"github.com/kevindamm/wits-osn/pipeline"
 )
 
-// Backfill the contents of a TSV file into the Sqlite database instance.
-func BackfillFromIndex(witsdb db.OsnDB, tsv_path string) error {
+// Where [BackfillFromIndex] keeps its progress and the rows it couldn't import.
+type IndexBackfill struct {
+	BatchSize  int                  // rows committed in each transaction
+	Checkpoint *pipeline.Checkpoint // the last line committed, may be nil
+	RejectPath string               // TSV of the rows that were not imported
+}
+
+// Imports the matches (and their players) listed in a TSV backup of the legacy
+// index, as LEGACY matches.  Rows are committed in batches, after which the
+// line reached is checkpointed so that a later run resumes from there.
+//
+// Rows which can't be parsed or upserted are appended to the reject file, with
+// their line number and the reason, and the backfill continues without them.
+// An error is returned only if the file (or its header) can't be read, or if
+// a batch could not be committed.
+func BackfillFromIndex(witsdb db.OsnDB, tsv_path string, options IndexBackfill) (BackfillSummary, error) {
+	var summary BackfillSummary
 	reader, err := os.Open(tsv_path)
 	if err != nil {
-		return err
+		return summary, err
 	}
 	defer reader.Close()
 
 	scanner := bufio.NewScanner(reader)
-	scanner.Split(bufio.ScanLines)
-	scanner.Scan()
-	values := strings.Split(scanner.Text(), "\t")
-
-	columns := make([]string, 0)
-	for _, col := range values {
-		trimmed := strings.Trim(col, " ")
-		columns = append(columns, trimmed)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	if !scanner.Scan() {
+		return summary, fmt.Errorf("missing header in %s: %v", tsv_path, scanner.Err())
+	}
+	header := scanner.Text()
+	indices, err := verify_columns(strings.Split(header, "\t"))
+	if err != nil {
+		return summary, err
+	}
+	rejects := reject_file{path: options.RejectPath, header: header}
+	defer rejects.Close()
+
+	stage := "index:" + filepath.Base(tsv_path)
+	resume := options.Checkpoint.Mark(stage)
+	batch_size := max(options.BatchSize, 1)
+	var batch db.Batch
+	defer func() {
+		if batch != nil {
+			batch.Rollback()
+		}
+	}()
+	commit := func(line int64) error {
+		if batch == nil {
+			return nil
+		}
+		if err := batch.Commit(); err != nil {
+			return fmt.Errorf("committing through line %d: %w", line, err)
+		}
+		batch = nil
+		if err := rejects.Flush(); err != nil {
+			return err
+		}
+		if err := options.Checkpoint.Update(stage, line); err != nil {
+			return err
+		}
+		log.Printf("%s: committed through line %d (%s)", tsv_path, line, summary)
+		return options.Checkpoint.Save()
 	}
-	indices := verify_columns(columns)
-	log.Print(strings.Join(columns, ", "))
 
-	linecount := 0
+	line, batched := int64(1), 0
 	for scanner.Scan() {
-		line := scanner.Text()
-		log.Println(line)
-
-		values = strings.Split(line, "\t")
-		linecount += 1
-		if len(values) != EXPECTED_TSV_COLUMN_COUNT {
-			return fmt.Errorf("incorrect column count %d for record, line %d\n%s", len(values), linecount, line)
-		}
-
-		metadata := osn.LegacyReplayMetadata{
-			GameID:      values[indices["game_id"]],
-			NumPlayers:  num_players(values[indices["game_type"]]),
-			LeagueMatch: league_match(values[indices["game_type"]]),
-			Created:     values[indices["created"]],
-			Season:      values[indices["season"]],
-			OsnVersion:  values[indices["engine"]],
-			MapID:       values[indices["map_id"]],
-			MapName:     values[indices["map_name"]],
-			TurnCount:   values[indices["turn_count"]],
-		}
-
-		metadata.MapID = values[indices["map_id"]]
-		map_id := assert_uint8(values[indices["map_id"]])
-		osnmap, err := witsdb.MapByID(uint8(map_id))
+		line += 1
+		if line <= resume {
+			summary.Skipped += 1
+			continue
+		}
+		if batch == nil {
+			if batch, err = witsdb.BeginBatch(); err != nil {
+				return summary, err
+			}
+		}
+
+		match, err := parse_index_row(strings.Split(scanner.Text(), "\t"), indices)
+		if err == nil {
+			err = batch.UpsertMatch(&match, "backfill")
+		}
 		if err != nil {
-			return err
+			summary.Failed += 1
+			if err := rejects.Write(line, err, scanner.Text()); err != nil {
+				return summary, err
+			}
+		} else {
+			summary.Imported += 1
+		}
+
+		batched += 1
+		if batched >= batch_size {
+			if err := commit(line); err != nil {
+				return summary, err
+			}
+			batched = 0
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return summary, fmt.Errorf("%s line %d: %w", tsv_path, line+1, err)
+	}
+	return summary, commit(line)
+}
+
+// The match, with its players, described by a row of the legacy index.  Only
+// the game ID and game type are required, other missing values are left zero.
+func parse_index_row(values []string, indices map[string]int) (osn.LegacyMatch, error) {
+	match := osn.LegacyMatch{FetchStatus: osn.STATUS_LEGACY}
+	if len(values) != EXPECTED_TSV_COLUMN_COUNT {
+		return match, fmt.Errorf("incorrect column count %d", len(values))
+	}
+	column := func(name string) string {
+		value := strings.TrimSpace(values[indices[name]])
+		if value == `\N` {
+			return ""
+		}
+		return value
+	}
+
+	match.MatchHash = osn.GameID(column("game_id"))
+	if match.MatchHash == osn.UNKNOWN_MATCH_ID {
+		return match, fmt.Errorf("missing game_id")
+	}
+	game_type, err := strconv.ParseUint(column("game_type"), 10, 8)
+	if err != nil {
+		return match, fmt.Errorf("game_type: %w", err)
+	}
+	count := num_players(uint8(game_type))
+	if count == 0 {
+		return match, fmt.Errorf("game_type %d has no players", game_type)
+	}
+	match.Competitive = league_match(uint8(game_type))
+
+	for name, value := range map[string]*int{
+		"season":     &match.Season,
+		"map_id":     &match.MapID,
+		"turn_count": &match.TurnCount,
+		"engine":     &match.Version,
+	} {
+		if *value, err = parse_optional_int(column(name)); err != nil {
+			return match, fmt.Errorf("%s: %w", name, err)
 		}
-		metadata.MapName = osnmap.Name
+	}
+	if !known_map(match.MapID) {
+		return match, fmt.Errorf("unknown map_id %d", match.MapID)
+	}
+	if created := column("created"); created != "" {
+		match.CreatedTime, err = time.Parse(osn.TimeLayout, created)
+		if err != nil {
+			return match, fmt.Errorf("created: %w", err)
+		}
+	}
 
-		playerIDs := split_list(values[indices["player_ids"]])
-		playerNames := split_list(values[indices["player_names"]])
-		playerLeagues := split_list(values[indices["player_leagues"]])
-		playerRaces := split_list(values[indices["player_races"]])
+	ids := split_list(column("player_ids"))
+	names := split_list(column("player_names"))
+	if len(ids) != count || len(names) != count {
+		return match, fmt.Errorf("expected %d players, found %d IDs and %d names",
+			count, len(ids), len(names))
+	}
+	match.Players = make([]osn.PlayerRole, count)
+	for i := range count {
+		role := &match.Players[i]
+		role.RowID, err = strconv.ParseInt(ids[i], 10, 64)
+		if err != nil {
+			return match, fmt.Errorf("player_ids: %w", err)
+		}
+		role.Name = names[i]
+		role.TurnOrder = osn.PlayerColorEnum(i + 1)
+	}
+	return match, nil
+}
+
+// Parses an integer, where the empty string is zero.
+func parse_optional_int(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// Whether the map is in the catalog (which the maps table is populated from).
+func known_map(map_id int) bool {
+	if map_id == 0 {
+		return true
+	}
+	for _, legacymap := range osn.Maps() {
+		if int(legacymap.MapID) == map_id {
+			return true
+		}
+	}
+	return false
+}
+
+// Rows that could not be imported, as TSV of their line number and the reason
+// followed by the row itself.  The file is appended to (so that rejects from
+// earlier runs are kept) and isn't created until there is a row to write.
+//
+// Rows are held until Flush, which is called once their batch is committed.
+// If the batch isn't committed, its lines are read again when the backfill
+// resumes, and writing its rejects then would duplicate them in the file.
+type reject_file struct {
+	path    string
+	header  string   // of the index, which the row's columns are from
+	pending []string // rows rejected since the last commit
+	file    *os.File
+}
+
+func (rejects *reject_file) Write(line int64, reason error, row string) error {
+	if rejects.path == "" {
+		log.Printf("line %d: %s", line, reason)
+		return nil
+	}
+	message := strings.Join(strings.Fields(reason.Error()), " ")
+	rejects.pending = append(rejects.pending,
+		fmt.Sprintf("%d\t%s\t%s\n", line, message, row))
+	return nil
+}
+
+func (rejects *reject_file) Flush() error {
+	if len(rejects.pending) == 0 {
+		return nil
+	}
+	if rejects.file == nil {
+		file, err := os.OpenFile(rejects.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		rejects.file = file
+		if info, err := file.Stat(); err != nil {
+			return err
+		} else if info.Size() == 0 {
+			rejects.pending = append(
+				[]string{fmt.Sprintf("line\treason\t%s\n", rejects.header)},
+				rejects.pending...)
+		}
+	}
+	writer := bufio.NewWriter(rejects.file)
+	for _, row := range rejects.pending {
+		if _, err := writer.WriteString(row); err != nil {
+			return err
+		}
+	}
+	rejects.pending = nil
+	return writer.Flush()
+}
 
-		metadata.Player1_Name = playerNames[0]
-		metadata.Player1_ID = playerIDs[0]
-		metadata.Player1_League = playerLeagues[0]
-		metadata.Player1_Race = playerRaces[0]
+// Closes the file, any rows which were not flushed are dropped with their batch.
+func (rejects *reject_file) Close() error {
+	rejects.pending = nil
+	if rejects.file == nil {
+		return nil
+	}
+	return rejects.file.Close()
+}
 
-		metadata.Player2_Name = playerNames[1]
-		metadata.Player2_ID = playerIDs[1]
-		metadata.Player2_League = playerLeagues[1]
-		metadata.Player2_Race = playerRaces[1]
+// Counts of the replay files read by [BackfillFromReplays], or of the rows read
+// by [BackfillFromIndex] (where the skipped rows were committed by an earlier
+// run and the failed rows were rejected).
+type BackfillSummary struct {
+	Imported int // files whose match was added, or advanced to the file's status
+	Skipped  int // files whose match already has the file's status (or later)
+	Failed   int // files which could not be read or decoded
+}
 
-		if metadata.NumPlayers == "4" {
-			metadata.Player3_Name = playerNames[2]
-			metadata.Player3_ID = playerIDs[2]
-			metadata.Player3_League = playerLeagues[2]
-			metadata.Player3_Race = playerRaces[2]
+func (summary BackfillSummary) String() string {
+	return fmt.Sprintf("%d imported, %d skipped, %d failed",
+		summary.Imported, summary.Skipped, summary.Failed)
+}
 
-			metadata.Player4_Name = playerNames[3]
-			metadata.Player4_ID = playerIDs[3]
-			metadata.Player4_League = playerLeagues[3]
-			metadata.Player4_Race = playerRaces[3]
+// Imports the JSON replay files (optionally gzipped) found anywhere under the
+// replays_path directory.  Each file's match is upserted along with its players
+// and roles, and the replay is copied into the workspace for the pipeline.
+//
+// Files in the wire format (as fetched) are FETCHED, replays which have already
+// been unwrapped are UNWRAPPED, and files with only a match's metadata (written
+// by earlier versions of this command) are LISTED.  Files are skipped if their
+// match already has that status or a later one.
+func BackfillFromReplays(witsdb db.OsnDB, workspace pipeline.Workspace, replays_path string) (BackfillSummary, error) {
+	var summary BackfillSummary
+	err := filepath.WalkDir(replays_path, func(filename string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if filename == replays_path {
+				return err
+			}
+			log.Printf("%s: %s", filename, err)
+			summary.Failed += 1
+			return nil
+		}
+		if entry.IsDir() || !is_replay_file(entry.Name()) {
+			return nil
 		}
+		imported, err := backfill_replay(witsdb, workspace, filename)
+		if err != nil {
+			log.Printf("%s: %s", filename, err)
+			summary.Failed += 1
+		} else if imported {
+			summary.Imported += 1
+		} else {
+			summary.Skipped += 1
+		}
+		return nil
+	})
+	return summary, err
+}
 
-		log.Println(metadata)
+func is_replay_file(name string) bool {
+	return strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".json.gz")
+}
+
+// Imports the replay file, returning false if its match was already imported.
+func backfill_replay(witsdb db.OsnDB, workspace pipeline.Workspace, filename string) (bool, error) {
+	filedata, err := read_replay_file(filename)
+	if err != nil {
+		return false, err
+	}
+	replay, status, err := decode_backfill(filedata)
+	if err != nil {
+		return false, err
+	}
+	match := replay_metadata(replay)
+	if match.MatchHash == osn.UNKNOWN_MATCH_ID {
+		return false, fmt.Errorf("replay has no match ID")
+	}
 
-		players := metadata.Players()
-		for _, player := range players {
-			record := db.PlayerRecord{Player: player}
-			witsdb.Players().Insert(&record)
+	if existing, err := witsdb.Matches().GetByName(string(match.MatchHash)); err == nil {
+		if !backfill_advances(existing.FetchStatus, status) {
+			return false, nil
 		}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
 
-		match := metadata.ToLegacyMatch()
-		record := db.LegacyMatchRecord{LegacyMatch: match}
-		witsdb.Matches().Insert(&record)
+	switch status {
+	case osn.STATUS_FETCHED:
+		err = workspace.WriteFetched(match.MatchHash, filedata)
+	case osn.STATUS_UNWRAPPED:
+		replay.LegacyMatch = match
+		err = workspace.WriteReplay(status, replay)
+	}
+	if err != nil {
+		return false, err
+	}
 
-		if true {
-			// TODO only processing one record until the schema transform is complete
-			break
+	// A new match is inserted as LISTED (an existing one keeps its status), and
+	// then moved one stage at a time, so its history shows each of the stages.
+	match.FetchStatus = osn.STATUS_LISTED
+	if err := witsdb.UpsertMatch(&match, "backfill"); err != nil {
+		return false, err
+	}
+	steps := []osn.FetchStatus{status}
+	if status == osn.STATUS_UNWRAPPED {
+		// An unwrapped replay was fetched before it was unwrapped.
+		steps = []osn.FetchStatus{osn.STATUS_FETCHED, osn.STATUS_UNWRAPPED}
+	}
+	for _, step := range steps {
+		if match.FetchStatus == step {
+			continue
+		}
+		err = witsdb.UpdateMatchStatus(match.MatchHash, step, "backfill", nil)
+		if err != nil {
+			return false, err
 		}
+		match.FetchStatus = step
 	}
+	return true, nil
+}
 
-	return nil
+func read_replay_file(filename string) ([]byte, error) {
+	if !strings.HasSuffix(filename, ".gz") {
+		return os.ReadFile(filename)
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
 }
 
-func BackfillFromReplays(witsdb db.OsnDB, replays_path string) error {
-	// TODO
+// Decodes the replay, in its wire format or unwrapped, and the status that its
+// match has when the file is imported.  A replay in the wire format which can't
+// be unwrapped is still imported (its match ID is in the wrapper), the pipeline
+// will find it INVALID when unwrapping it.
+func decode_backfill(filedata []byte) (osn.LegacyMatchWithReplay, osn.FetchStatus, error) {
+	var on_wire osn.WireFormat
+	if err := json.Unmarshal(filedata, &on_wire); err == nil &&
+		on_wire.Wrapper.Wrapper != "" {
+		if !on_wire.Wrapper.Found {
+			return osn.LegacyMatchWithReplay{}, osn.STATUS_UNKNOWN,
+				fmt.Errorf("room not found for replay %s", on_wire.Wrapper.RoomID)
+		}
+		replay, err := osn.UnwrapReplay(filedata)
+		if err != nil {
+			log.Printf("replay %s cannot be unwrapped: %s", on_wire.Wrapper.RoomID, err)
+			replay = osn.LegacyMatchWithReplay{}
+			replay.MatchHash = osn.GameID(on_wire.Wrapper.RoomID)
+		}
+		return replay, osn.STATUS_FETCHED, nil
+	}
 
-	return nil
+	var replay osn.LegacyMatchWithReplay
+	if err := json.Unmarshal(filedata, &replay); err != nil {
+		return replay, osn.STATUS_UNKNOWN, err
+	}
+	if len(replay.Replay) == 0 {
+		return replay, osn.STATUS_LISTED, nil
+	}
+	return replay, osn.STATUS_UNWRAPPED, nil
+}
 
+// The match's metadata, with any that is missing derived from its replay, and
+// its players (in turn order) from the replay's settings.
+func replay_metadata(replay osn.LegacyMatchWithReplay) osn.LegacyMatch {
+	match := replay.LegacyMatch
+	settings := replay.Settings
+	if len(settings) == 0 && len(replay.Replay) > 0 {
+		settings = replay.Replay[0].State.Settings
+	}
+	if match.MapID == 0 && replay.MapName != "" {
+		if legacymap, err := osn.MapByName(replay.MapName); err == nil {
+			match.MapID = int(legacymap.MapID)
+		}
+	}
+	if match.TurnCount == 0 {
+		match.TurnCount = len(replay.Replay)
+	}
+	if replay.Terminal != nil && bool(replay.Terminal.Competitive) {
+		match.Competitive = true
+	}
+	if len(match.Players) == 0 {
+		for _, setting := range settings {
+			match.Players = append(match.Players, osn.PlayerRole{
+				Player:    osn.Player{GCID: setting.GCID, Name: setting.PlayerName},
+				UnitRace:  setting.UnitRace,
+				TurnOrder: osn.PlayerColorEnum(setting.Color)})
+		}
+	}
+	return match
+}
+
+// Matches are not moved back to an earlier status by the backfill, but INVALID
+// and LEGACY matches are replaced by the status of the replay found for them.
+func backfill_advances(existing, status osn.FetchStatus) bool {
+	switch existing {
+	case osn.STATUS_INVALID, osn.STATUS_LEGACY:
+		return status != osn.STATUS_LISTED
+	}
+	return existing < status
 }
 
 const EXPECTED_TSV_COLUMN_COUNT = 15
@@ -162,54 +518,40 @@ var EXPECTED_COLUMNS = map[string]bool{
 	"first_playerid": true,
 }
 
-func verify_columns(columns []string) map[string]int {
-	if len(columns) != 15 {
-		log.Fatalf("incorrect column count %d for TSV index", len(columns))
+// The index of each column in the header, which must have exactly the
+// expected columns (in any order).
+func verify_columns(columns []string) (map[string]int, error) {
+	if len(columns) != EXPECTED_TSV_COLUMN_COUNT {
+		return nil, fmt.Errorf("incorrect column count %d for TSV index", len(columns))
 	}
 
 	indices := make(map[string]int)
-	for i, columnName := range columns {
-		if expected := EXPECTED_COLUMNS[columnName]; expected {
-			indices[columnName] = i
-		} else {
-			log.Fatalf("unrecognized column name %s in legacy index\n", columnName)
+	for i, column := range columns {
+		column = strings.TrimSpace(column)
+		if !EXPECTED_COLUMNS[column] {
+			return nil, fmt.Errorf("unrecognized column name %s in legacy index", column)
 		}
+		indices[column] = i
 	}
 	if len(indices) != EXPECTED_TSV_COLUMN_COUNT {
-		log.Fatalf("incorrect unique column count %d", len(indices))
-	}
-
-	return indices
-}
-
-// Convert string into an uint8 or fail with LOG(FATAL) << error.
-func assert_uint8(intstr string) int8 {
-	value64, err := strconv.ParseInt(intstr, 10, 8)
-	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("incorrect unique column count %d", len(indices))
 	}
-	if value64 < 0 || value64 > 255 {
-		log.Fatal("expectued unsigned 8-bit integer, got " + intstr)
-	}
-	return int8(value64)
+	return indices, nil
 }
 
-func num_players(gametype string) string {
-	if gametype == "4" || gametype == "5" {
-		return "4"
-	} else if gametype != "0" {
-		return "2"
-	} else {
-		return "0"
+func num_players(game_type uint8) int {
+	switch game_type {
+	case 0:
+		return 0
+	case 4, 5:
+		return 4
 	}
+	return 2
 }
 
-func league_match(gametype string) string {
-	gametypeint := assert_uint8(gametype)
-	if gametypeint%2 > 0 {
-		return "1"
-	}
-	return "0"
+// Odd-numbered game types are league matches.
+func league_match(game_type uint8) bool {
+	return game_type%2 > 0
 }
 
 var reListCheck = regexp.MustCompile(`{(.*)(,.*)+}`)
@@ -233,7 +575,7 @@ func split_list(liststr string) []string {
 	for reListItem.Match(items) {
 		match := reListItem.FindIndex(items)
 		item := string(items[:match[1]-1])
-		list = append(list, item)
+		list = append(list, strings.Trim(item, `"`))
 		// regexp match already includes the `,` or `}`
 		items = items[match[1]:]
 	}
@@ -20,4 +20,360 @@
 //
 // github:kevindamm/wits-osn/cmd/fetch/backfill_test.go
 
-package main_test
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	osn "github.com/kevindamm/wits-osn"
+	"github.com/kevindamm/wits-osn/db"
+	"github.com/kevindamm/wits-osn/pipeline"
+)
+
+// Writes the files (by their path relative to dir), creating subdirectories.
+func write_files(t *testing.T, dir string, files map[string][]byte) {
+	t.Helper()
+	for name, filedata := range files {
+		filename := path.Join(dir, name)
+		if err := os.MkdirAll(path.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, filedata, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBackfillFromReplays(t *testing.T) {
+	sample, err := os.ReadFile(path.Join("..", "..", "testdata", string(replayID)+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write(sample)
+	writer.Close()
+
+	unwrapped, err := osn.UnwrapReplay(sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unwrapped.MatchHash = "unwrapped"
+	unwrapped_json, _ := json.Marshal(unwrapped)
+	unwrapped.MatchHash = "fresh"
+	fresh_json, _ := json.Marshal(unwrapped)
+	metadata_json, _ := json.Marshal(osn.LegacyMatch{MatchHash: "metadata", MapID: 4, Season: 1})
+
+	replays := t.TempDir()
+	write_files(t, replays, map[string][]byte{
+		"2013/05/sample.json":         sample,
+		"2013/05/copy/sample.json.gz": compressed.Bytes(), // the same match
+		"unwrapped.json":              unwrapped_json,
+		"fresh.json":                  fresh_json,
+		"older/metadata.json":         metadata_json,
+		"older/truncated.json":        sample[:100],
+		"notes.txt":                   []byte("not a replay"),
+	})
+
+	tempdir := t.TempDir()
+	witsdb := db.OpenOsnDB(path.Join(tempdir, "osn.db"))
+	defer witsdb.Close()
+	witsdb.MustCreateAndPopulateTables()
+	workspace := pipeline.Workspace(tempdir)
+
+	// A match from the legacy index, its replay has already been unwrapped.
+	legacy := osn.LegacyMatch{MatchHash: "unwrapped", MapID: 16, FetchStatus: osn.STATUS_LEGACY}
+	if err := witsdb.Matches().Insert(db.MakeMatchRecord(legacy)); err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := BackfillFromReplays(witsdb, workspace, replays)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary != (BackfillSummary{Imported: 4, Skipped: 1, Failed: 1}) {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+
+	for matchID, status := range map[osn.GameID]osn.FetchStatus{
+		replayID:    osn.STATUS_FETCHED,
+		"unwrapped": osn.STATUS_UNWRAPPED,
+		"fresh":     osn.STATUS_UNWRAPPED,
+		"metadata":  osn.STATUS_LISTED,
+	} {
+		record, _ := witsdb.Matches().GetByName(string(matchID))
+		if record.FetchStatus != status {
+			t.Errorf("match %s is %s, expected %s", matchID, record.FetchStatus, status)
+		}
+	}
+	history, err := witsdb.MatchStatusHistory("unwrapped")
+	if err != nil || len(history) != 3 || history[0].NewStatus != osn.STATUS_LEGACY ||
+		history[1].NewStatus != osn.STATUS_FETCHED || history[2].NewStatus != osn.STATUS_UNWRAPPED {
+		t.Errorf("expected the legacy match to be fetched then unwrapped, got %+v (%v)", history, err)
+	}
+	// New matches are listed, then advanced to the status of their replay.
+	for matchID, expected := range map[osn.GameID][]osn.FetchStatus{
+		replayID:   {osn.STATUS_LISTED, osn.STATUS_FETCHED},
+		"fresh":    {osn.STATUS_LISTED, osn.STATUS_FETCHED, osn.STATUS_UNWRAPPED},
+		"metadata": {osn.STATUS_LISTED},
+	} {
+		history, err := witsdb.MatchStatusHistory(matchID)
+		if err != nil || len(history) != len(expected) {
+			t.Errorf("unexpected history for %s: %+v (%v)", matchID, history, err)
+			continue
+		}
+		previous := osn.STATUS_UNKNOWN
+		for i, change := range history {
+			if change.OldStatus != previous || change.NewStatus != expected[i] ||
+				change.Source != "backfill" {
+				t.Errorf("unexpected change %d for %s: %+v", i, matchID, change)
+			}
+			previous = change.NewStatus
+		}
+	}
+	record, _ := witsdb.Matches().GetByName(string(replayID))
+	if record.MapID != 16 || record.TurnCount != 15 || !record.Competitive {
+		t.Errorf("unexpected metadata from the replay: %+v", record.LegacyMatch)
+	}
+	players, err := witsdb.MatchPlayers(replayID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(players) != 2 ||
+		players[0].Name != "DarthMickeyJJ" || players[0].GCID != "G:161628063" ||
+		players[1].Name != "KevinDamm" || players[1].GCID != "102573135124346212370" {
+		t.Errorf("unexpected players %+v", players)
+	}
+
+	// The replays are in the workspace for the pipeline to process.
+	archived, err := workspace.ReadArtifact(osn.STATUS_FETCHED, replayID)
+	if err != nil || !bytes.Equal(archived, sample) {
+		t.Errorf("expected the fetched replay to be archived (%v)", err)
+	}
+	replay, err := workspace.ReadReplay(osn.STATUS_UNWRAPPED, "unwrapped")
+	if err != nil || len(replay.Replay) != 15 {
+		t.Errorf("expected the unwrapped replay in the workspace (%v)", err)
+	}
+
+	// Running it again imports nothing new, the players are unchanged.
+	summary, err = BackfillFromReplays(witsdb, workspace, replays)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary != (BackfillSummary{Skipped: 5, Failed: 1}) {
+		t.Errorf("unexpected summary on a second run: %s", summary)
+	}
+	again, _ := witsdb.MatchPlayers(replayID)
+	if len(again) != 2 || again[0].RowID != players[0].RowID || again[1].RowID != players[1].RowID {
+		t.Errorf("players changed on a second run: %+v", again)
+	}
+
+	if _, err := BackfillFromReplays(witsdb, workspace, path.Join(replays, "missing")); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+}
+
+var index_columns = []string{"game_id", "game_type", "season", "created",
+	"player_names", "player_ids", "player_leagues", "player_races", "map_id",
+	"map_name", "turn_count", "replay_fetched", "player_winner", "engine",
+	"first_playerid"}
+
+// A row of the legacy index, with default values for the columns not given.
+func index_row(values map[string]string) string {
+	row := make([]string, len(index_columns))
+	for i, column := range index_columns {
+		value, ok := values[column]
+		if !ok {
+			value = map[string]string{
+				"game_type": "3", "season": "2", "created": "2013-05-01 12:00:00",
+				"player_names": "{Alvendor,Lenoxe}", "player_ids": "{31,32}",
+				"player_leagues": "{1,2}", "player_races": "{1,2}", "map_id": "16",
+				"map_name": "Sweet Tooth", "turn_count": "15", "replay_fetched": "t",
+				"player_winner": "31", "engine": "1063", "first_playerid": "31",
+			}[column]
+		}
+		row[i] = value
+	}
+	return strings.Join(row, "\t")
+}
+
+func TestBackfillFromIndex(t *testing.T) {
+	tempdir := t.TempDir()
+	rows := []string{
+		strings.Join(index_columns, "\t"),
+		index_row(map[string]string{"game_id": "first"}),
+		index_row(map[string]string{"game_id": "four", "game_type": "5",
+			"player_names": `{Alvendor,Lenoxe,"Syvan, Jr",DarthMickeyJJ}`,
+			"player_ids":   "{31,32,33,34}"}),
+		index_row(map[string]string{"game_id": "badturns", "turn_count": "x"}),
+		"short\t3",
+		index_row(map[string]string{"game_id": "nomap", "map_id": "99"}),
+		index_row(map[string]string{"game_id": "unranked", "game_type": "2",
+			"map_id": "7", "turn_count": `\N`}),
+		index_row(map[string]string{"game_id": "renamed",
+			"player_names": "{Alvendor,Lenoxe}", "player_ids": "{31,35}"}),
+	}
+	tsv_path := path.Join(tempdir, "index.tsv")
+	reject_path := path.Join(tempdir, "index.rejects.tsv")
+	write_files(t, tempdir, map[string][]byte{
+		"index.tsv": []byte(strings.Join(rows, "\n") + "\n")})
+
+	witsdb := db.OpenOsnDB(path.Join(tempdir, "osn.db"))
+	defer witsdb.Close()
+	witsdb.MustCreateAndPopulateTables()
+	checkpoint_path := path.Join(tempdir, "backfill.json")
+	checkpoint, err := pipeline.OpenCheckpoint(checkpoint_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := IndexBackfill{BatchSize: 2, Checkpoint: checkpoint, RejectPath: reject_path}
+
+	summary, err := BackfillFromIndex(witsdb, tsv_path, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary != (BackfillSummary{Imported: 3, Failed: 4}) {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+
+	four, _ := witsdb.Matches().GetByName("four")
+	if four.FetchStatus != osn.STATUS_LEGACY || !four.Competitive ||
+		four.MapID != 16 || four.TurnCount != 15 || four.Version != 1063 ||
+		four.CreatedTime.Year() != 2013 {
+		t.Errorf("unexpected match %+v", four.LegacyMatch)
+	}
+	players, _ := witsdb.MatchPlayers("four")
+	if len(players) != 4 || players[2].Name != "Syvan, Jr" || players[3].RowID != 34 {
+		t.Errorf("unexpected players %+v", players)
+	}
+	unranked, _ := witsdb.Matches().GetByName("unranked")
+	if unranked.Competitive || unranked.MapID != 7 || unranked.TurnCount != 0 {
+		t.Errorf("unexpected match %+v", unranked.LegacyMatch)
+	}
+
+	rejected, err := os.ReadFile(reject_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(rejected)), "\n")
+	if len(lines) != 5 || lines[0] != "line\treason\t"+rows[0] {
+		t.Fatalf("unexpected rejects:\n%s", rejected)
+	}
+	for i, expected := range []struct {
+		line   string
+		reason string
+	}{
+		{"4", "turn_count: "},
+		{"5", "incorrect column count 2"},
+		{"6", "unknown map_id 99"},
+		{"8", "player \"Lenoxe\": "},
+	} {
+		fields := strings.SplitN(lines[i+1], "\t", 3)
+		if fields[0] != expected.line || !strings.HasPrefix(fields[1], expected.reason) {
+			t.Errorf("unexpected reject %q, expected line %s: %s",
+				lines[i+1], expected.line, expected.reason)
+		}
+	}
+
+	// The whole file was committed, so running it again skips every row.
+	if mark := checkpoint.Mark("index:index.tsv"); mark != 8 {
+		t.Errorf("expected the checkpoint at line 8, found %d", mark)
+	}
+	checkpoint, _ = pipeline.OpenCheckpoint(checkpoint_path)
+	options.Checkpoint = checkpoint
+	summary, err = BackfillFromIndex(witsdb, tsv_path, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary != (BackfillSummary{Skipped: 7}) {
+		t.Errorf("unexpected summary on a second run: %s", summary)
+	}
+	if again, _ := os.ReadFile(reject_path); !bytes.Equal(again, rejected) {
+		t.Errorf("rejects changed on a second run:\n%s", again)
+	}
+
+	// A run interrupted after its first batch resumes from the line after it.
+	resumed := db.OpenOsnDB(path.Join(tempdir, "resumed.db"))
+	defer resumed.Close()
+	resumed.MustCreateAndPopulateTables()
+	checkpoint, _ = pipeline.OpenCheckpoint(path.Join(tempdir, "resumed.json"))
+	checkpoint.Update("index:index.tsv", 3)
+	summary, err = BackfillFromIndex(resumed, tsv_path, IndexBackfill{
+		BatchSize: 100, Checkpoint: checkpoint})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary != (BackfillSummary{Imported: 1, Skipped: 2, Failed: 4}) {
+		t.Errorf("unexpected summary when resuming: %s", summary)
+	}
+	if _, err := resumed.Matches().GetByName("first"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected rows before the checkpoint to be skipped (%v)", err)
+	}
+	if mark := checkpoint.Mark("index:index.tsv"); mark != 8 {
+		t.Errorf("expected the checkpoint at line 8, found %d", mark)
+	}
+}
+
+func TestBackfillIndexInterrupted(t *testing.T) {
+	tempdir := t.TempDir()
+	header := strings.Join(index_columns, "\t")
+	rows := []string{
+		header,
+		index_row(map[string]string{"game_id": "nomap", "map_id": "99"}),
+		index_row(map[string]string{"game_id": "first"}),
+	}
+	// A line longer than the scanner allows interrupts the run mid-batch.
+	overlong := strings.Repeat("x", 2*1024*1024)
+	tsv_path := path.Join(tempdir, "index.tsv")
+	reject_path := path.Join(tempdir, "index.rejects.tsv")
+	write_files(t, tempdir, map[string][]byte{
+		"index.tsv": []byte(strings.Join(append(rows, overlong), "\n") + "\n")})
+
+	witsdb := db.OpenOsnDB(path.Join(tempdir, "osn.db"))
+	defer witsdb.Close()
+	witsdb.MustCreateAndPopulateTables()
+	checkpoint, err := pipeline.OpenCheckpoint(path.Join(tempdir, "backfill.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := IndexBackfill{BatchSize: 100, Checkpoint: checkpoint, RejectPath: reject_path}
+
+	if _, err := BackfillFromIndex(witsdb, tsv_path, options); err == nil {
+		t.Fatal("expected an error for the overlong line")
+	}
+	if _, err := os.Stat(reject_path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected no rejects written for an uncommitted batch (%v)", err)
+	}
+	if _, err := witsdb.Matches().GetByName("first"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the uncommitted batch to be rolled back (%v)", err)
+	}
+
+	// Resuming reads the same rows again, each reject is written once.
+	write_files(t, tempdir, map[string][]byte{
+		"index.tsv": []byte(strings.Join(rows, "\n") + "\n")})
+	summary, err := BackfillFromIndex(witsdb, tsv_path, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary != (BackfillSummary{Imported: 1, Failed: 1}) {
+		t.Errorf("unexpected summary when resuming: %s", summary)
+	}
+	rejected, err := os.ReadFile(reject_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(rejected)), "\n")
+	if len(lines) != 2 || lines[0] != "line\treason\t"+header ||
+		!strings.HasPrefix(lines[1], "2\tunknown map_id 99\t") {
+		t.Errorf("unexpected rejects:\n%s", rejected)
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/cmd/fetch/cache.go
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"time"
+)
+
+// Returned (wrapped in a *url.Error) for requests not in the cache when it is
+// offline.  This is not a failure of the replay, it may be fetched later.
+var ErrNotCached = errors.New("response is not cached (offline)")
+
+// An [http.RoundTripper] which keeps successful responses on disk, so that the
+// index pages of a crawl can be parsed again without requesting them again.
+// Each response is keyed by its request's method, URL and body (the form values
+// of an index page request).
+//
+// Cached responses are revalidated with a conditional request, using their
+// ETag or Last-Modified date.  Requests for resources that the caller archives
+// itself (such as replays) are passed on without being cached, rather than
+// keeping a second copy of them.  When offline, responses are served only from
+// the cache, and archived resources are not available.
+type cache_transport struct {
+	dir      string
+	next     http.RoundTripper
+	offline  bool
+	archived func(*http.Request) bool
+}
+
+// A response as it is written to the cache.
+type cache_entry struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Form   string `json:"form,omitempty"` // the encoded request body, if any
+
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+
+	Fetched   time.Time `json:"fetched"`   // when the response was received
+	Validated time.Time `json:"validated"` // when the server last confirmed it
+}
+
+func new_cache_transport(dir string, next http.RoundTripper, offline bool) *cache_transport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &cache_transport{
+		dir:      dir,
+		next:     next,
+		offline:  offline,
+		archived: func(*http.Request) bool { return false }}
+}
+
+func (cache *cache_transport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if cache.archived(request) {
+		if cache.offline {
+			close_body(request)
+			return nil, ErrNotCached
+		}
+		return cache.next.RoundTrip(request)
+	}
+
+	// The body is read again (by GetBody) for the outgoing request, the request's
+	// own body is closed here as the RoundTripper contract requires.
+	form, err := read_body(request)
+	close_body(request)
+	if err != nil {
+		return nil, err
+	}
+	filename := cache.path(request.Method, request.URL.String(), form)
+	entry, found := cache.load(filename)
+	if found && cache.offline {
+		return entry.response(request), nil
+	}
+	if cache.offline {
+		return nil, ErrNotCached
+	}
+
+	outgoing := request.Clone(request.Context())
+	if form != nil {
+		outgoing.Body = io.NopCloser(bytes.NewReader(form))
+	}
+	if found {
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			outgoing.Header.Set("If-None-Match", etag)
+		}
+		if modified := entry.Header.Get("Last-Modified"); modified != "" {
+			outgoing.Header.Set("If-Modified-Since", modified)
+		}
+	}
+	response, err := cache.next.RoundTrip(outgoing)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if found && response.StatusCode == http.StatusNotModified {
+		response.Body.Close()
+		entry.Validated = now
+		cache.store(filename, entry)
+		return entry.response(request), nil
+	}
+	if response.StatusCode != http.StatusOK {
+		return response, nil
+	}
+
+	body, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	cache.store(filename, cache_entry{
+		Method:    request.Method,
+		URL:       request.URL.String(),
+		Form:      string(form),
+		Status:    response.StatusCode,
+		Header:    response.Header,
+		Body:      body,
+		Fetched:   now,
+		Validated: now})
+	response.Body = io.NopCloser(bytes.NewReader(body))
+	return response, nil
+}
+
+// Reads the request body (without consuming it) or returns nil if it is empty.
+func read_body(request *http.Request) ([]byte, error) {
+	if request.Body == nil || request.Body == http.NoBody {
+		return nil, nil
+	}
+	if request.GetBody == nil {
+		return nil, errors.New("cannot cache a request whose body cannot be re-read")
+	}
+	body, err := request.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+func close_body(request *http.Request) {
+	if request.Body != nil {
+		request.Body.Close()
+	}
+}
+
+// Entries are spread across subdirectories by the first byte of their key.
+func (cache *cache_transport) path(method, url string, form []byte) string {
+	hash := sha256.New()
+	io.WriteString(hash, method+" "+url+"\n")
+	hash.Write(form)
+	key := hex.EncodeToString(hash.Sum(nil))
+	return path.Join(cache.dir, key[:2], key+".json")
+}
+
+func (cache *cache_transport) load(filename string) (cache_entry, bool) {
+	var entry cache_entry
+	filedata, err := os.ReadFile(filename)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Print("reading cache: ", err)
+		}
+		return entry, false
+	}
+	if err := json.Unmarshal(filedata, &entry); err != nil {
+		log.Printf("corrupt cache entry %s: %s", filename, err)
+		return entry, false
+	}
+	return entry, true
+}
+
+// Failing to write the cache does not fail the request, it is only logged.
+func (cache *cache_transport) store(filename string, entry cache_entry) {
+	if err := write_entry(filename, entry); err != nil {
+		log.Print("writing cache: ", err)
+	}
+}
+
+// The entry is renamed into place so that readers never see a partial write.
+func write_entry(filename string, entry cache_entry) error {
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(filename), 0755); err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(path.Dir(filename), ".entry-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name()) // no-op after it is renamed
+	if _, err := temp.Write(encoded); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), filename)
+}
+
+func (entry cache_entry) response(request *http.Request) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", entry.Status, http.StatusText(entry.Status)),
+		StatusCode:    entry.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        entry.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(entry.Body)),
+		ContentLength: int64(len(entry.Body)),
+		Request:       request}
+}
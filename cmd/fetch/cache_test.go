@@ -0,0 +1,163 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/cmd/fetch/cache_test.go
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Responds with the request's form values, tagged so that it can be revalidated.
+type tagged_server struct {
+	mutex       sync.Mutex
+	requests    int
+	conditional int
+}
+
+func (server *tagged_server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.requests += 1
+	r.ParseForm()
+	etag := `"` + r.Form.Encode() + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		server.conditional += 1
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	io.WriteString(w, r.URL.Path+"?"+r.Form.Encode())
+}
+
+func request_body(t *testing.T, client *http.Client, method, url string, form url.Values) (string, error) {
+	t.Helper()
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	request, err := http.NewRequestWithContext(context.Background(), method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if form != nil {
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status %s", response.Status)
+	}
+	data, err := io.ReadAll(response.Body)
+	return string(data), err
+}
+
+func TestCacheTransport(t *testing.T) {
+	origin := &tagged_server{}
+	server := httptest.NewServer(origin)
+	defer server.Close()
+	dir := t.TempDir()
+	cache := new_cache_transport(dir, nil, false)
+	cache.archived = is_replay_request
+	client := &http.Client{Transport: cache}
+
+	// Index pages are keyed by their form values, and are always revalidated.
+	first := url.Values{"page": {"1"}}
+	second := url.Values{"page": {"2"}}
+	for i, form := range []url.Values{first, second, first} {
+		body, err := request_body(t, client, "POST", server.URL+"/replays/getReplays/", form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body != "/replays/getReplays/?"+form.Encode() {
+			t.Errorf("request %d: unexpected body %q", i, body)
+		}
+	}
+	if origin.requests != 3 || origin.conditional != 1 {
+		t.Errorf("expected 3 requests, 1 revalidated; had %d (%d)",
+			origin.requests, origin.conditional)
+	}
+
+	// Replays are archived by the fetcher, they are not kept in the cache too.
+	for range 2 {
+		body, err := request_body(t, client, "GET", server.URL+"/api/getReplay/abc", nil)
+		if err != nil || body != "/api/getReplay/abc?" {
+			t.Errorf("unexpected replay %q (%v)", body, err)
+		}
+	}
+	if origin.requests != 5 {
+		t.Errorf("expected the replay to be requested each time, had %d requests", origin.requests)
+	}
+	entries, _ := filepath.Glob(path.Join(dir, "*", "*.json"))
+	if len(entries) != 2 {
+		t.Errorf("expected only the two index pages in the cache, found %v", entries)
+	}
+
+	// Offline, all cached responses are served and nothing else is.
+	server.Close()
+	offline := &http.Client{Transport: new_cache_transport(dir, nil, true)}
+	body, err := request_body(t, offline, "POST", server.URL+"/replays/getReplays/", second)
+	if err != nil || body != "/replays/getReplays/?page=2" {
+		t.Errorf("unexpected offline index %q (%v)", body, err)
+	}
+	_, err = request_body(t, offline, "GET", server.URL+"/api/getReplay/xyz", nil)
+	if !errors.Is(err, ErrNotCached) {
+		t.Errorf("expected ErrNotCached for an uncached replay, got %v", err)
+	}
+
+	// The request's body is closed, even though it is served from the cache.
+	reader := &closing_reader{Reader: strings.NewReader(second.Encode())}
+	request, _ := http.NewRequest("POST", server.URL+"/replays/getReplays/", reader)
+	request.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(second.Encode())), nil
+	}
+	response, err := offline.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if !reader.closed {
+		t.Error("expected the request body to be closed")
+	}
+}
+
+type closing_reader struct {
+	io.Reader
+	closed bool
+}
+
+func (reader *closing_reader) Close() error {
+	reader.closed = true
+	return nil
+}
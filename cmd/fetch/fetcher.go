@@ -23,69 +23,193 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	osn "github.com/kevindamm/wits-osn"
 	"github.com/kevindamm/wits-osn/db"
+	"github.com/kevindamm/wits-osn/osnapi"
+	"github.com/kevindamm/wits-osn/pipeline"
 )
 
 type Fetcher interface {
-	FetchNewReplayIDs(db.OsnDB) (<-chan osn.GameID, <-chan error)
-	FetchReplay(osn.GameID, string) error
+	FetchNewReplayIDs(context.Context, db.OsnDB, *pipeline.Checkpoint) (<-chan osn.GameID, <-chan error)
+	FetchReplay(context.Context, osn.GameID, pipeline.Workspace) error
 }
 
-func NewFetcher(waitSeconds uint) Fetcher {
+// The site that replays are fetched from, unless another base URL is given.
+const DefaultBaseURL = "http://osn.codepenguin.com"
+
+// Constructs a fetcher for the OSN site at base_url (without a trailing slash),
+// such as [DefaultBaseURL] or a server of the local archive (see [osnapi]).
+//
+// Requests are limited to one every waitSeconds on average, across all of the
+// goroutines sharing this fetcher, with up to burst requests made back-to-back.
+func NewFetcher(base_url string, waitSeconds uint, burst uint) Fetcher {
 	// <3 be kind to your hosts <3 <3 <3
 	if waitSeconds < 3 {
 		waitSeconds = 3
 	}
-	return &fetcher{time.Now(), waitSeconds, fetch_index, fetch_replay}
+	fetcher := &fetcher{
+		base_url: strings.TrimSuffix(base_url, "/"),
+		limiter:  new_token_bucket(time.Duration(waitSeconds)*time.Second, int(burst)),
+		client:   &http.Client{Timeout: request_timeout},
+		backoff:  default_backoff}
+	fetcher.fetch_index = fetcher.post_index_form
+	fetcher.fetch_replay = fetcher.get_replay
+	return fetcher
+}
+
+// Constructs a fetcher (see [NewFetcher]) whose index pages are kept on disk in
+// cache_dir.  Replays are not cached, those already in the workspace are not
+// fetched again.  When offline, only cached pages are read and none of them are
+// rate-limited or retried; replays not in the workspace fail with [ErrNotCached].
+func NewCachedFetcher(base_url, cache_dir string, offline bool, waitSeconds uint, burst uint) Fetcher {
+	fetcher := NewFetcher(base_url, waitSeconds, burst).(*fetcher)
+	cache := new_cache_transport(cache_dir, fetcher.client.Transport, offline)
+	cache.archived = is_replay_request
+	fetcher.client.Transport = cache
+	fetcher.reuse_archive = true
+	if offline {
+		fetcher.limiter = new_token_bucket(0, 1)
+		fetcher.backoff.attempts = 1
+	}
+	return fetcher
+}
+
+// Replays do not change once fetched (unlike the pages of the index), and are
+// archived in the workspace.
+func is_replay_request(request *http.Request) bool {
+	return strings.Contains(request.URL.Path, osnapi.ReplayPath)
 }
 
 type fetcher struct {
+	base_url string
+
 	// Rate-limiting of fetches across resource types.
-	fetchedAt   time.Time
-	waitSeconds uint
+	limiter *token_bucket
+	client  *http.Client
+	backoff backoff
+
+	// Whether replays already in the workspace are used instead of fetching them.
+	reuse_archive bool
 
 	// Default to making a network request, may be mocked out by tests.
-	fetch_index  func(pagenum int) ([]byte, error)
-	fetch_replay func(pageurl string) ([]byte, error)
+	fetch_index  func(ctx context.Context, pagenum int) ([]byte, error)
+	fetch_replay func(ctx context.Context, pageurl string) ([]byte, error)
 }
 
-func (fetcher *fetcher) FetchNewReplayIDs(db db.OsnDB) (<-chan osn.GameID, <-chan error) {
-	errchan := make(chan error)
+// Upper bound on the time of any single request, including reading its body.
+const request_timeout = 2 * time.Minute
+
+// Crawls the listing of recent replays, newest first, writing each match that
+// is not yet in the database with STATUS_LISTED.  The IDs of matches that have
+// not been fetched yet are sent on the channel, each at most once.
+//
+// The crawl stops at the last page, or at the first full page of matches which
+// were all known before the crawl began.  Matches that reappear on a later page
+// (shifted by new games arriving during the crawl) are ignored.
+//
+// The number of pages read is kept in the checkpoint (which may be nil) until
+// the crawl reaches the last page.  When a crawl was interrupted, the next one
+// does not stop at the known matches, it continues as many pages beyond them
+// as the interrupted crawl had read, so that the older pages are crawled too.
+// Pages may be read again (where the known matches begin part-way through a
+// page) but none are skipped.
+//
+// The crawl also stops when ctx is done, sending its error.  The error channel
+// is buffered so that the crawl can end without the error being received.
+func (fetcher *fetcher) FetchNewReplayIDs(ctx context.Context, db db.OsnDB, checkpoint *pipeline.Checkpoint) (<-chan osn.GameID, <-chan error) {
+	errchan := make(chan error, 1)
 	idchan := make(chan osn.GameID)
 
 	go func() {
 		defer close(idchan)
 		defer close(errchan)
 
-		i := 0
-		replays := []osn.LegacyMatch{}
-		for !all_fetched(replays, db) {
-			matches, err := fetcher.fetch_and_parse_index(i)
+		progress := func(page int) error {
+			if err := checkpoint.Update(crawl_task, int64(page)); err != nil {
+				return err
+			}
+			return checkpoint.Save()
+		}
+		interrupted := int(checkpoint.Mark(crawl_task))
+		resuming := false
+
+		seen := make(map[osn.GameID]bool)
+		for i := 0; ; i++ {
+			matches, err := fetcher.fetch_and_parse_index(ctx, i)
+			if errors.Is(err, ErrNotCached) {
+				// Offline, the crawl ends with the last of the cached pages.
+				log.Printf("index page %d is not cached, the crawl is done", i)
+				return
+			}
 			if err != nil {
 				errchan <- err
-				break
+				return
 			}
+			listed, known := 0, 0
 			for _, match := range matches {
-				// TODO write to database if it doesn't already exist
+				if seen[match.MatchHash] {
+					continue
+				}
+				seen[match.MatchHash] = true
 
-				// TODO don't emit to channel if it had already been fetched
-				// (may be from page shift or from already-retrieved history)
-				idchan <- osn.GameID(match.MatchHash)
+				status, is_new, err := list_match(db, match)
+				if err != nil {
+					errchan <- err
+					return
+				}
+				if is_new {
+					listed += 1
+				} else {
+					known += 1
+				}
+				if needs_fetch(status) {
+					select {
+					case idchan <- match.MatchHash:
+					case <-ctx.Done():
+						errchan <- ctx.Err()
+						return
+					}
+				}
 			}
-
-			i += 1
+			if len(matches) < index_page_size {
+				// The last page, every page of the index has been read.
+				if err := progress(0); err != nil {
+					errchan <- err
+				}
+				return
+			}
+			next := i + 1
+			if !resuming && all_known(listed, known) {
+				if interrupted == 0 {
+					// The older pages were all read by an earlier crawl.
+					if err := progress(0); err != nil {
+						errchan <- err
+					}
+					return
+				}
+				// The known matches begin on this page or the one before it.
+				next = max(next, i-1+interrupted)
+				log.Printf("continuing an interrupted crawl from index page %d", next)
+				resuming = true
+			}
+			if err := progress(next); err != nil {
+				errchan <- err
+				return
+			}
+			i = next - 1
 		}
 	}()
 
@@ -93,22 +217,57 @@ func (fetcher *fetcher) FetchNewReplayIDs(db db.OsnDB) (<-chan osn.GameID, <-cha
 }
 
 const UserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36"
-const ReplayFormURL = "http://osn.codepenguin.com/replays/getReplays/"
 
-func all_fetched(matches []osn.LegacyMatch, db db.OsnDB) bool {
-	// TODO
-	return true
+// The number of replays requested for each page of the index.
+const index_page_size = 20
+
+// The progress of the crawl in its checkpoint: the number of pages read, or
+// zero once the crawl has read the last page.
+const crawl_task = "crawl"
+
+// Whether the crawl has reached the matches it knew of, having read a full page
+// of which some were newly listed and some were already known (the rest were
+// seen on an earlier page).
+func all_known(listed, known int) bool {
+	return listed == 0 && known > 0
+}
+
+// Writes the match with STATUS_LISTED if it is not already in the database.
+// Returns the match's status, and whether it was newly listed.
+func list_match(witsdb db.OsnDB, match osn.LegacyMatch) (osn.FetchStatus, bool, error) {
+	record, err := witsdb.Matches().GetByName(string(match.MatchHash))
+	if err == nil {
+		return record.FetchStatus, false, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return osn.STATUS_UNKNOWN, false, err
+	}
+	match.FetchStatus = osn.STATUS_LISTED
+	if err := witsdb.UpsertMatch(&match, "fetch"); err != nil {
+		return osn.STATUS_UNKNOWN, false,
+			fmt.Errorf("listing match %s: %w", match.MatchHash, err)
+	}
+	return osn.STATUS_LISTED, true, nil
+}
+
+// Matches at FETCHED or any later status (including INVALID) are not fetched
+// again.  LEGACY matches were indexed from a backup but never fetched.
+func needs_fetch(status osn.FetchStatus) bool {
+	switch status {
+	case osn.STATUS_UNKNOWN, osn.STATUS_LISTED, osn.STATUS_LEGACY:
+		return true
+	}
+	return false
 }
 
 // Retrieves the data from an OSN index page and provides a channel for new
 // (unique) match replay entries, or a nil channel and non-nil error.
-func fetch_index(pagenum int) ([]byte, error) {
+func (fetcher *fetcher) post_index_form(ctx context.Context, pagenum int) ([]byte, error) {
 	// Maintain the same ordering and representation as the browser interface.
 	values := url.Values{}
 	if pagenum > 0 {
 		values.Add("page", strconv.Itoa(pagenum))
 	}
-	values.Add("limit", "20")
+	values.Add("limit", strconv.Itoa(index_page_size))
 	values.Add("order", "created")
 	values.Add("order_asc", "false")
 	values.Add("list", "recent")
@@ -116,65 +275,105 @@ func fetch_index(pagenum int) ([]byte, error) {
 		values.Add("ret_total", "false")
 	}
 
-	request, err := http.NewRequest("POST", ReplayFormURL, strings.NewReader(values.Encode()))
+	request, err := http.NewRequestWithContext(ctx, "POST",
+		fetcher.base_url+osnapi.ListingPath, strings.NewReader(values.Encode()))
 	if err != nil {
 		return []byte{}, err
 	}
 	request.Header.Set("User-Agent", UserAgent)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	response, err := http.DefaultClient.Do(request)
+	response, err := fetcher.client.Do(request)
 	if err != nil {
 		return []byte{}, err
 	}
 	defer response.Body.Close()
+	if err := check_status(response); err != nil {
+		return []byte{}, err
+	}
 	return io.ReadAll(response.Body)
 }
 
-func (fetcher *fetcher) fetch_and_parse_index(pagenum int) ([]osn.LegacyMatch, error) {
-	data, err := fetcher.fetch_index(pagenum)
+// Fetches and parses a page of the index, retrying transient failures.
+func (fetcher *fetcher) fetch_and_parse_index(ctx context.Context, pagenum int) ([]osn.LegacyMatch, error) {
+	var data []byte
+	err := fetcher.backoff.retry(ctx, func() error {
+		if err := fetcher.limiter.Wait(ctx); err != nil {
+			return err
+		}
+		var err error
+		data, err = fetcher.fetch_index(ctx, pagenum)
+		return err
+	})
 	if err != nil {
-		return []osn.LegacyMatch{}, err
+		return []osn.LegacyMatch{}, fmt.Errorf("index page %d: %w", pagenum, err)
 	}
 
-	var index struct {
-		Total   string            `json:"total,omitempty"`
-		Replays []osn.LegacyMatch `json:"replays"`
-		// Ignore server timestamp, it isn't of any importance (and it drifts).
-		//When    string            `json:"ts"`
-	}
+	// Ignore server timestamp, it isn't of any importance (and it drifts).
+	var index osnapi.ReplayListing
 	err = json.Unmarshal(data, &index)
 	if err != nil {
-		return []osn.LegacyMatch{}, err
+		return []osn.LegacyMatch{}, fmt.Errorf("index page %d: %w", pagenum, err)
 	}
 
-	return index.Replays, nil
+	matches := make([]osn.LegacyMatch, len(index.Replays))
+	for i, metadata := range index.Replays {
+		matches[i] = metadata.ToLegacyMatch()
+	}
+	return matches, nil
 }
 
-// Retrieves the match with indicated ID and saves it to a local file.
-// Returns the game ID for the replay, or "" and a non-nil error.
-func (fetcher *fetcher) FetchReplay(game_id osn.GameID, filename string) error {
-	url := fmt.Sprintf("http://osn.codepenguin.com/api/getReplay/%s", game_id)
-
-	log.Print("Fetching ", url, " -> ", filename)
-	wire_data, err := fetcher.fetch_replay(url)
-	if err != nil {
-		return err
+// Retrieves the match with indicated ID and archives it in the workspace, as it
+// was received, to be unwrapped by the pipeline (see [pipeline.FileStages]).
+// Transient failures are retried with backoff, and the returned error is
+// permanent (see [is_permanent]) if the replay is missing or is not a replay.
+//
+// Waiting for the rate limiter is interrupted when ctx is done, but a download
+// that has already begun is allowed to finish so that its result is not lost.
+func (fetcher *fetcher) FetchReplay(ctx context.Context, game_id osn.GameID, workspace pipeline.Workspace) error {
+	url := fetcher.base_url + osnapi.ReplayPath + string(game_id)
+	if fetcher.reuse_archive && workspace.HasFetched(game_id) {
+		return nil
 	}
 
-	_, encoded, err := osn.ParseRawReplay(wire_data)
+	var wire_data []byte
+	err := fetcher.backoff.retry(ctx, func() error {
+		if err := fetcher.limiter.Wait(ctx); err != nil {
+			return err
+		}
+		log.Print("Fetching ", url)
+		var err error
+		wire_data, err = fetcher.fetch_replay(context.WithoutCancel(ctx), url)
+		return err
+	})
 	if err != nil {
 		return err
 	}
 
-	os.WriteFile(filename, encoded, 0644)
-	return nil
+	var on_wire osn.WireFormat
+	if err := json.Unmarshal(wire_data, &on_wire); err != nil {
+		return permanent(fmt.Errorf("unparseable replay %s: %w", game_id, err))
+	}
+	if !on_wire.Wrapper.Found {
+		return permanent(fmt.Errorf("room not found for replay %s", game_id))
+	}
+	return workspace.WriteFetched(game_id, wire_data)
 }
 
-func fetch_replay(url string) ([]byte, error) {
-	response, err := http.Get(url)
+func (fetcher *fetcher) get_replay(ctx context.Context, url string) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", UserAgent)
+
+	response, err := fetcher.client.Do(request)
 	if err != nil {
 		return nil, err
 	}
 	defer response.Body.Close()
+	if err := check_status(response); err != nil {
+		return nil, err
+	}
 	return io.ReadAll(response.Body)
 }
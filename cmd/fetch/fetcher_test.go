@@ -20,6 +20,500 @@
 //
 // github:kevindamm/wits-osn/fetcher_test.go
 
-package main_test
+package main
 
-//	replayID := "ahRzfm91dHdpdHRlcnNnYW1lLWhyZHIVCxIIR2FtZVJvb20YgIDQlK_hqAoM"
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	osn "github.com/kevindamm/wits-osn"
+	"github.com/kevindamm/wits-osn/db"
+	"github.com/kevindamm/wits-osn/osnapi"
+	"github.com/kevindamm/wits-osn/pipeline"
+)
+
+const replayID = osn.GameID("ahRzfm91dHdpdHRlcnNnYW1lLWhyZHIVCxIIR2FtZVJvb20YgIDQlK_hqAoM")
+
+// Serves an archive with the sample replay, as OSN would (see [osnapi]).
+func offline_osn(t *testing.T) *httptest.Server {
+	tempdir := t.TempDir()
+	workspace := pipeline.Workspace(tempdir)
+	filedata, err := os.ReadFile(path.Join("..", "..", "testdata", string(replayID)+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := workspace.WriteFetched(replayID, filedata); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := db.OpenOsnDB(path.Join(tempdir, "archive.db"))
+	t.Cleanup(archive.Close)
+	archive.MustCreateAndPopulateTables()
+	match := osn.LegacyMatch{
+		MatchIndex:  1,
+		MatchHash:   replayID,
+		Season:      3,
+		CreatedTime: time.Date(2013, 5, 1, 12, 0, 0, 0, time.UTC),
+		MapID:       16,
+		TurnCount:   15,
+		Version:     1063,
+		FetchStatus: osn.STATUS_FETCHED}
+	if err := archive.Matches().Insert(db.MakeMatchRecord(match)); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(osnapi.NewServer(archive, workspace))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// A fetcher whose rate limit does not slow down the tests.
+func fast_fetcher(base_url string) *fetcher {
+	fetcher := NewFetcher(base_url, 0, 1).(*fetcher)
+	fetcher.limiter = new_token_bucket(time.Millisecond, 1)
+	fetcher.backoff = backoff{attempts: 3, initial: time.Millisecond, limit: time.Millisecond}
+	return fetcher
+}
+
+func TestFetchOffline(t *testing.T) {
+	server := offline_osn(t)
+	fetcher := fast_fetcher(server.URL + "/")
+
+	matches, err := fetcher.fetch_and_parse_index(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0].MatchHash != replayID ||
+		matches[0].MapID != 16 || matches[0].Season != 3 {
+		t.Errorf("unexpected listing %+v", matches)
+	}
+
+	workspace := pipeline.Workspace(t.TempDir())
+	if err := fetcher.FetchReplay(context.Background(), replayID, workspace); err != nil {
+		t.Fatal(err)
+	}
+	// The replay is archived exactly as it was served.
+	archived, err := workspace.ReadArtifact(osn.STATUS_FETCHED, replayID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sample, _ := os.ReadFile(path.Join("..", "..", "testdata", string(replayID)+".json"))
+	if !bytes.Equal(archived, sample) {
+		t.Errorf("archived replay (%d bytes) differs from the sample (%d bytes)",
+			len(archived), len(sample))
+	}
+	if compressed, err := os.Stat(workspace.Path(osn.STATUS_FETCHED, replayID)); err != nil ||
+		compressed.Size() >= int64(len(sample)) {
+		t.Errorf("expected the archive to be compressed, %v (%v)", compressed, err)
+	}
+	fetched, err := workspace.ReadReplay(osn.STATUS_FETCHED, replayID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched.MatchHash != replayID || len(fetched.Replay) != 15 {
+		t.Errorf("fetched match %s with %d turns", fetched.MatchHash, len(fetched.Replay))
+	}
+
+	if err := fetcher.FetchReplay(context.Background(), "missing", workspace); err == nil {
+		t.Error("expected an error fetching a replay the server does not have")
+	}
+}
+
+// A listing of matches, newest first, which can have new matches added to it
+// (as OSN would between requests for its pages).
+type fake_index struct {
+	matches  []osn.LegacyReplayMetadata // newest first
+	requests []int                      // the pages that were requested
+	arriving map[int]int                // new matches arriving before a page
+}
+
+func (index *fake_index) add_matches(count int) {
+	for range count {
+		i := len(index.matches) + 1
+		metadata := osn.LegacyReplayMetadata{
+			Index:        fmt.Sprint(i),
+			GameID:       fmt.Sprintf("game%03d", i),
+			NumPlayers:   "2",
+			LeagueMatch:  "1",
+			Created:      time.Date(2013, 5, 1, 0, i, 0, 0, time.UTC).Format(osn.TimeLayout),
+			Season:       "3",
+			OsnVersion:   "1063",
+			MapID:        "16",
+			TurnCount:    "15",
+			Player1_ID:   "11",
+			Player1_Name: "DarthMickeyJJ",
+			Player2_ID:   "12",
+			Player2_Name: "KevinDamm",
+		}
+		index.matches = append([]osn.LegacyReplayMetadata{metadata}, index.matches...)
+	}
+}
+
+func (index *fake_index) fetch_index(ctx context.Context, pagenum int) ([]byte, error) {
+	index.requests = append(index.requests, pagenum)
+	index.add_matches(index.arriving[pagenum])
+	first := min(pagenum*index_page_size, len(index.matches))
+	last := min(first+index_page_size, len(index.matches))
+	return json.Marshal(osnapi.ReplayListing{Replays: index.matches[first:last]})
+}
+
+// Runs the crawl to completion, returning the IDs that were sent.
+func crawl(t *testing.T, fetcher *fetcher, witsdb db.OsnDB, checkpoint *pipeline.Checkpoint) []osn.GameID {
+	t.Helper()
+	ids, errs := fetcher.FetchNewReplayIDs(context.Background(), witsdb, checkpoint)
+	crawled := []osn.GameID{}
+	for ids != nil || errs != nil {
+		select {
+		case id, ok := <-ids:
+			if !ok {
+				ids = nil
+				continue
+			}
+			crawled = append(crawled, id)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			t.Fatal(err)
+		}
+	}
+	return crawled
+}
+
+func TestIncrementalCrawl(t *testing.T) {
+	witsdb := db.OpenOsnDB(path.Join(t.TempDir(), "osn.db"))
+	defer witsdb.Close()
+	witsdb.MustCreateAndPopulateTables()
+
+	index := &fake_index{}
+	index.add_matches(45)
+	fetcher := fast_fetcher(DefaultBaseURL)
+	fetcher.fetch_index = index.fetch_index
+
+	// The first crawl reads every page, listing every match.
+	crawled := crawl(t, fetcher, witsdb, nil)
+	if len(crawled) != 45 || crawled[0] != "game045" || crawled[44] != "game001" {
+		t.Fatalf("expected all 45 matches, newest first, got %v", crawled)
+	}
+	if fmt.Sprint(index.requests) != "[0 1 2]" {
+		t.Errorf("expected to read all three pages, read %v", index.requests)
+	}
+	count, err := witsdb.CountMatches(db.MatchFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed := osn.STATUS_LISTED
+	if listed_count, _ := witsdb.CountMatches(db.MatchFilter{Status: &listed}); count != 45 || listed_count != 45 {
+		t.Errorf("expected 45 listed matches, have %d (%d listed)", count, listed_count)
+	}
+	record, _ := witsdb.Matches().GetByName("game007")
+	if record.MatchIndex != 7 || record.MapID != 16 {
+		t.Errorf("unexpected record for game007: %+v", record)
+	}
+	history, err := witsdb.MatchStatusHistory("game007")
+	if err != nil || len(history) != 1 ||
+		history[0].NewStatus != osn.STATUS_LISTED || history[0].Source != "fetch" {
+		t.Errorf("expected game007 to have been listed by the fetch, got %+v (%v)", history, err)
+	}
+	players, _ := witsdb.MatchPlayers("game007")
+	if len(players) != 2 || players[1].Name != "KevinDamm" {
+		t.Errorf("unexpected players of game007: %+v", players)
+	}
+
+	// Most of them are fetched, then five new matches arrive, and another three
+	// while the crawl is reading the second page (shifting it by three).
+	for _, id := range crawled[5:] {
+		if err := witsdb.UpdateMatchStatus(id, osn.STATUS_FETCHED, "test", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := witsdb.UpdateMatchStatus("game020", osn.STATUS_INVALID, "test", nil); err != nil {
+		t.Fatal(err)
+	}
+	index.add_matches(5)
+	index.arriving = map[int]int{1: 3}
+	index.requests = nil
+
+	crawled = crawl(t, fetcher, witsdb, nil)
+	expected := []osn.GameID{
+		"game050", "game049", "game048", "game047", "game046", // new
+		"game045", "game044", "game043", "game042", "game041", // listed, not fetched
+	}
+	if fmt.Sprint(crawled) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, crawled)
+	}
+	// The second page had the three shifted matches and 17 known ones.
+	if fmt.Sprint(index.requests) != "[0 1]" {
+		t.Errorf("expected to stop after a page of known matches, read %v", index.requests)
+	}
+	count, _ = witsdb.CountMatches(db.MatchFilter{})
+	if count != 50 {
+		t.Errorf("expected 50 matches (the late arrivals are not read yet), have %d", count)
+	}
+}
+
+func TestInterruptedCrawl(t *testing.T) {
+	tempdir := t.TempDir()
+	witsdb := db.OpenOsnDB(path.Join(tempdir, "osn.db"))
+	defer witsdb.Close()
+	witsdb.MustCreateAndPopulateTables()
+	checkpoint_path := path.Join(tempdir, "crawl.json")
+	checkpoint, err := pipeline.OpenCheckpoint(checkpoint_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index := &fake_index{}
+	index.add_matches(100)
+	fetcher := fast_fetcher(DefaultBaseURL)
+	fetcher.fetch_index = func(ctx context.Context, pagenum int) ([]byte, error) {
+		if pagenum == 2 {
+			return nil, permanent(errors.New("connection lost"))
+		}
+		return index.fetch_index(ctx, pagenum)
+	}
+
+	// The first crawl is interrupted after reading two of its five pages.
+	ids, errs := fetcher.FetchNewReplayIDs(context.Background(), witsdb, checkpoint)
+	for range ids {
+		// The IDs to fetch are not of interest here.
+	}
+	if err := <-errs; err == nil {
+		t.Fatal("expected the crawl to be interrupted")
+	}
+	if mark := checkpoint.Mark("crawl"); mark != 2 {
+		t.Errorf("expected the checkpoint at page 2, found %d", mark)
+	}
+
+	// Ten new matches arrive.  The next crawl lists them, reaches the matches
+	// that the first crawl listed, and continues with the pages it didn't read.
+	index.add_matches(10)
+	index.requests = nil
+	fetcher.fetch_index = index.fetch_index
+	checkpoint, _ = pipeline.OpenCheckpoint(checkpoint_path)
+	crawled := crawl(t, fetcher, witsdb, checkpoint)
+	if fmt.Sprint(index.requests) != "[0 1 2 3 4 5]" {
+		t.Errorf("expected to continue to the last page, read %v", index.requests)
+	}
+	if len(crawled) != 110 {
+		t.Errorf("expected every match to be fetched, got %d", len(crawled))
+	}
+	count, _ := witsdb.CountMatches(db.MatchFilter{})
+	if count != 110 {
+		t.Errorf("expected all 110 matches to be listed, have %d", count)
+	}
+	if mark := checkpoint.Mark("crawl"); mark != 0 {
+		t.Errorf("expected the checkpoint to be cleared, found %d", mark)
+	}
+
+	// Once every page has been read, a crawl stops at the known matches.
+	index.requests = nil
+	checkpoint, _ = pipeline.OpenCheckpoint(checkpoint_path)
+	crawl(t, fetcher, witsdb, checkpoint)
+	if fmt.Sprint(index.requests) != "[0]" {
+		t.Errorf("expected to stop at the first page of known matches, read %v", index.requests)
+	}
+}
+
+func TestFetchAll(t *testing.T) {
+	server := offline_osn(t)
+	witsdb := db.OpenOsnDB(path.Join(t.TempDir(), "osn.db"))
+	defer witsdb.Close()
+	witsdb.MustCreateAndPopulateTables()
+
+	fetcher := fast_fetcher(server.URL)
+	ctx := context.Background()
+	ids, errs := fetcher.FetchNewReplayIDs(ctx, witsdb, nil)
+	workspace := pipeline.Workspace(t.TempDir())
+	if count := fetch_all(ctx, fetcher, witsdb, ids, workspace, 3, 3); count != 1 {
+		t.Errorf("expected one replay fetched, fetched %d", count)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	record, _ := witsdb.Matches().GetByName(string(replayID))
+	if record.FetchStatus != osn.STATUS_FETCHED {
+		t.Errorf("expected the match to be FETCHED, it is %s", record.FetchStatus)
+	}
+	if _, err := os.Stat(workspace.Path(osn.STATUS_FETCHED, replayID)); err != nil {
+		t.Error(err)
+	}
+
+	// Nothing is fetched once the context is done.
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	pending := make(chan osn.GameID, 1)
+	pending <- replayID
+	if count := fetch_all(canceled, fetcher, witsdb, pending, workspace, 2, 3); count != 0 {
+		t.Errorf("expected nothing fetched after cancellation, fetched %d", count)
+	}
+}
+
+// Serves the sample replay for any ID, failing in the way that the ID names.
+func failing_osn(t *testing.T) (*httptest.Server, map[string]int) {
+	sample, err := os.ReadFile(path.Join("..", "..", "testdata", string(replayID)+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mutex sync.Mutex
+	requests := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			id := strings.TrimPrefix(r.URL.Path, osnapi.ReplayPath)
+			mutex.Lock()
+			requests[id] += 1
+			count := requests[id]
+			mutex.Unlock()
+
+			switch {
+			case id == "flaky" && count <= 2, id == "down":
+				http.Error(w, "try again later", http.StatusServiceUnavailable)
+			case id == "gone":
+				http.NotFound(w, r)
+			case id == "noroom":
+				w.Write([]byte(`{"viewResponse": {"foundRoom": false, "gameState": ""}}`))
+			case id == "broken":
+				w.Write([]byte(`<html>Internal error</html>`))
+			default:
+				w.Write(sample)
+			}
+		}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestFetchFailures(t *testing.T) {
+	server, requests := failing_osn(t)
+	witsdb := db.OpenOsnDB(path.Join(t.TempDir(), "osn.db"))
+	defer witsdb.Close()
+	witsdb.MustCreateAndPopulateTables()
+
+	ids := []osn.GameID{"flaky", "down", "gone", "noroom", "broken"}
+	for _, id := range ids {
+		match := osn.LegacyMatch{MatchHash: id, MapID: 16, FetchStatus: osn.STATUS_LISTED}
+		if err := witsdb.Matches().Insert(db.MakeMatchRecord(match)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fetcher := fast_fetcher(server.URL)
+	workspace := pipeline.Workspace(t.TempDir())
+	fetch := func(ids ...osn.GameID) int {
+		idchan := make(chan osn.GameID, len(ids))
+		for _, id := range ids {
+			idchan <- id
+		}
+		close(idchan)
+		return fetch_all(context.Background(), fetcher, witsdb, idchan, workspace, 2, 2)
+	}
+	status := func(id osn.GameID) osn.FetchStatus {
+		record, _ := witsdb.Matches().GetByName(string(id))
+		return record.FetchStatus
+	}
+
+	if count := fetch(ids...); count != 1 {
+		t.Errorf("expected only the flaky replay to be fetched, fetched %d", count)
+	}
+	expected := map[osn.GameID]osn.FetchStatus{
+		"flaky":  osn.STATUS_FETCHED, // after two retries
+		"down":   osn.STATUS_LISTED,  // a transient failure, under the budget
+		"gone":   osn.STATUS_INVALID,
+		"noroom": osn.STATUS_INVALID,
+		"broken": osn.STATUS_INVALID,
+	}
+	for id, want := range expected {
+		if got := status(id); got != want {
+			t.Errorf("%s is %s, expected %s", id, got, want)
+		}
+	}
+	if requests["flaky"] != 3 || requests["down"] != 3 || requests["gone"] != 1 {
+		t.Errorf("unexpected requests (permanent failures are not retried): %v", requests)
+	}
+	// Every outcome, including each transient failure, is in the history (after
+	// the status that the match was listed with).
+	for id, reason := range map[osn.GameID]string{
+		"flaky":  "",
+		"down":   "503",
+		"gone":   "404",
+		"noroom": "room not found",
+		"broken": "unparseable replay",
+	} {
+		history, err := witsdb.MatchStatusHistory(id)
+		if err != nil || len(history) != 2 || history[1].NewStatus != expected[id] ||
+			!strings.Contains(history[1].Message, reason) ||
+			(reason == "") != (history[1].Message == "") {
+			t.Errorf("unexpected history for %s: %+v (%v)", id, history, err)
+		}
+	}
+
+	// Exceeding the attempt budget marks the replay as INVALID.
+	fetch("down")
+	if got := status("down"); got != osn.STATUS_INVALID {
+		t.Errorf("down is %s after two failed fetches, expected INVALID", got)
+	}
+	history, _ := witsdb.MatchStatusHistory("down")
+	if len(history) != 3 || !strings.Contains(history[2].Message, "503") {
+		t.Errorf("unexpected history for down: %+v", history)
+	}
+}
+
+func TestFetchFromCache(t *testing.T) {
+	server := offline_osn(t)
+	cache_dir := t.TempDir()
+	online := NewCachedFetcher(server.URL, cache_dir, false, 0, 1).(*fetcher)
+	online.limiter = new_token_bucket(time.Millisecond, 1)
+	ctx := context.Background()
+	if _, err := online.fetch_and_parse_index(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	workspace := pipeline.Workspace(t.TempDir())
+	if err := online.FetchReplay(ctx, replayID, workspace); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	offline := NewCachedFetcher(server.URL, cache_dir, true, 0, 1).(*fetcher)
+	matches, err := offline.fetch_and_parse_index(ctx, 0)
+	if err != nil || len(matches) != 1 || matches[0].MatchHash != replayID {
+		t.Errorf("unexpected offline index %v (%v)", matches, err)
+	}
+	// Replays are not cached, the one in the workspace is used.
+	if err := offline.FetchReplay(ctx, replayID, workspace); err != nil {
+		t.Error(err)
+	}
+	err = offline.FetchReplay(ctx, replayID, pipeline.Workspace(t.TempDir()))
+	if !errors.Is(err, ErrNotCached) {
+		t.Errorf("expected ErrNotCached for a replay not in the workspace, got %v", err)
+	}
+	err = offline.FetchReplay(ctx, "uncached", workspace)
+	if !errors.Is(err, ErrNotCached) || is_permanent(err) {
+		t.Errorf("expected a non-permanent ErrNotCached, got %v", err)
+	}
+
+	// Running out of cached index pages is the end of an offline crawl.
+	witsdb := db.OpenOsnDB(path.Join(t.TempDir(), "osn.db"))
+	defer witsdb.Close()
+	witsdb.MustCreateAndPopulateTables()
+	for _, dir := range []string{cache_dir, t.TempDir()} {
+		crawler := NewCachedFetcher(server.URL, dir, true, 0, 1)
+		ids, errs := crawler.FetchNewReplayIDs(ctx, witsdb, nil)
+		for range ids {
+			// Only the end of the crawl is of interest here.
+		}
+		if err := <-errs; err != nil {
+			t.Errorf("expected the offline crawl to end without error, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/cmd/fetch/limiter.go
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A token bucket shared by every request of a fetcher (index pages and replays
+// alike).  Tokens are added at one per interval, up to the burst size, and each
+// request takes one token, waiting for it if the bucket is empty.
+type token_bucket struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	burst    float64
+
+	tokens  float64 // may be negative, when tokens are reserved by waiters
+	updated time.Time
+}
+
+func new_token_bucket(interval time.Duration, burst int) *token_bucket {
+	burst = max(burst, 1)
+	return &token_bucket{
+		interval: interval,
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		updated:  time.Now()}
+}
+
+// Takes a token, waiting until it is available.  If the context is done before
+// then, the token is returned to the bucket along with the context's error.
+func (bucket *token_bucket) Wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	delay := bucket.reserve()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		bucket.mutex.Lock()
+		bucket.tokens += 1
+		bucket.mutex.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Takes a token from the bucket, returning how long until it is available.
+func (bucket *token_bucket) reserve() time.Duration {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+
+	now := time.Now()
+	if bucket.interval > 0 {
+		added := float64(now.Sub(bucket.updated)) / float64(bucket.interval)
+		bucket.tokens = min(bucket.tokens+added, bucket.burst)
+	} else {
+		bucket.tokens = bucket.burst
+	}
+	bucket.updated = now
+
+	bucket.tokens -= 1
+	if bucket.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-bucket.tokens * float64(bucket.interval))
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/cmd/fetch/limiter_test.go
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	interval := 20 * time.Millisecond
+	bucket := new_token_bucket(interval, 2)
+	ctx := context.Background()
+
+	start := time.Now()
+	for range 4 {
+		if err := bucket.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first two are immediate, the next two wait an interval each.
+	if elapsed := time.Since(start); elapsed < 2*interval-time.Millisecond {
+		t.Errorf("expected to wait at least %s, waited %s", 2*interval, elapsed)
+	}
+}
+
+func TestTokenBucketCanceled(t *testing.T) {
+	bucket := new_token_bucket(time.Hour, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := bucket.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if err := bucket.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the wait to end with the context, got %v", err)
+	}
+	if bucket.tokens < -0.5 {
+		t.Errorf("the canceled wait did not return its token, have %f", bucket.tokens)
+	}
+}
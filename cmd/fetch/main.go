@@ -23,14 +23,22 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
-	"path"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	osn "github.com/kevindamm/wits-osn"
 	"github.com/kevindamm/wits-osn/db"
+	"github.com/kevindamm/wits-osn/pipeline"
 )
 
 func main() {
@@ -40,10 +48,32 @@ func main() {
 		"create the table schemata before running, including enum values")
 	backfill_tsv := flag.String("backfill-tsv", "",
 		"path to a TSV file containing a legacy backup of replay file metadata")
+	backfill_batch := flag.Int("backfill-batch", 1000,
+		"number of TSV rows committed in each transaction")
+	backfill_checkpoint := flag.String("backfill-checkpoint", ".data/backfill.json",
+		"path where the TSV line reached is kept, for resuming the backfill")
+	backfill_rejects := flag.String("backfill-rejects", "",
+		"path of a TSV of the rows that could not be imported (default: beside the -backfill-tsv)")
 	backfill_replays := flag.String("backfill-replays", "",
 		"path to the parent directory where JSON files of wits replays are found")
-	out_path := flag.String("out", ".data/replays/",
-		"path where JSON formatted replay data is written to")
+	data_path := flag.String("data", ".data/",
+		"path of the workspace where fetched replays are archived (in `replays/`)")
+	base_url := flag.String("base-url", DefaultBaseURL,
+		"URL of the OSN site (or of an osnapi server) that replays are fetched from")
+	workers := flag.Uint("workers", 4,
+		"number of replays that may be fetched concurrently")
+	wait_seconds := flag.Uint("wait", 5,
+		"average seconds between requests to the site (at least 3)")
+	burst := flag.Uint("burst", 2,
+		"number of requests that may be made without waiting, after an idle period")
+	cache_dir := flag.String("cache", ".data/cache/",
+		"path where index pages are cached, or empty to not cache them")
+	offline := flag.Bool("offline", false,
+		"read index pages only from the cache and replays only from -data, without any requests")
+	crawl_checkpoint := flag.String("crawl-checkpoint", ".data/crawl.json",
+		"path where the index pages read are kept, for continuing an interrupted crawl")
+	max_attempts := flag.Int("max-attempts", 3,
+		"failed fetches of a replay (across runs) before it is marked INVALID")
 
 	flag.Parse()
 
@@ -57,54 +87,145 @@ func main() {
 
 	if len(*backfill_tsv) > 0 {
 		log.Println("back-filling from legacy DB...")
-		assert_nilerr(BackfillFromIndex(witsdb, *backfill_tsv))
+		checkpoint, err := pipeline.OpenCheckpoint(*backfill_checkpoint)
+		assert_nilerr(err)
+		if *backfill_rejects == "" {
+			*backfill_rejects = strings.TrimSuffix(*backfill_tsv,
+				filepath.Ext(*backfill_tsv)) + ".rejects.tsv"
+		}
+		summary, err := BackfillFromIndex(witsdb, *backfill_tsv, IndexBackfill{
+			BatchSize:  *backfill_batch,
+			Checkpoint: checkpoint,
+			RejectPath: *backfill_rejects,
+		})
+		assert_nilerr(err)
+		fmt.Printf("back-filled index: %s\n", summary)
 	}
 	if len(*backfill_replays) > 0 {
 		log.Println("back-filling from legacy replays...")
-		assert_nilerr(BackfillFromReplays(witsdb, *backfill_replays))
+		summary, err := BackfillFromReplays(witsdb,
+			pipeline.Workspace(*data_path), *backfill_replays)
+		assert_nilerr(err)
+		fmt.Printf("back-filled replays: %s\n", summary)
 	}
 
-	assert_nilerr(os.MkdirAll(*out_path, 0755))
+	// The first interrupt stops the crawl and any fetches that haven't started,
+	// letting those in flight finish.  A second interrupt exits immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+		log.Println("interrupted, waiting for in-flight fetches (^C again to quit)")
+	}()
 
 	// Fetch listing of recent (unaccounted-for) replays
-	fetcher := NewFetcher(5)
-	replay_index, errs := fetcher.FetchNewReplayIDs(witsdb)
-	count := 0
+	var fetcher Fetcher
+	if len(*cache_dir) > 0 {
+		fetcher = NewCachedFetcher(*base_url, *cache_dir, *offline, *wait_seconds, *burst)
+	} else if *offline {
+		log.Fatal("-offline requires a -cache directory")
+	} else {
+		fetcher = NewFetcher(*base_url, *wait_seconds, *burst)
+	}
+	crawl, err := pipeline.OpenCheckpoint(*crawl_checkpoint)
+	assert_nilerr(err)
+	replay_index, errs := fetcher.FetchNewReplayIDs(ctx, witsdb, crawl)
 
 	// Fetch replays that haven't been fetched already
-	for {
-		select {
-		case replayID, ok := <-replay_index:
-			if ok {
-				replay_path := path.Join(*out_path,
-					fmt.Sprintf("%s.json", replayID.ShortID()))
-				fmt.Printf("fetching %s -> %s", replayID.ShortID(), replay_path)
-				err := fetcher.FetchReplay(replayID, replay_path)
-				if err == nil {
-					count += 1
-					witsdb.UpdateMatchStatus(replayID, osn.STATUS_FETCHED)
-				} else {
+	count := fetch_all(ctx, fetcher, witsdb, replay_index,
+		pipeline.Workspace(*data_path), *workers, *max_attempts)
+	if err := <-errs; err != nil {
+		fmt.Println("ERROR while fetching index pages")
+		fmt.Println(err)
+	}
+
+	fmt.Println("fetch of recent replays completed")
+	fmt.Printf("%d new replays fetched\n", count)
+}
+
+// Fetches each of the replays received from ids into the workspace, using a
+// pool of workers which share the rate limit of fetcher.  Each fetched match is
+// updated to FETCHED.
+// Returns the number of replays fetched, after all of the workers are done.
+//
+// Failures are recorded in the match's status history (see [record_failure]).
+// When ctx is done, workers stop taking new IDs but finish the fetch (and the
+// status update) that they are already performing.
+func fetch_all(ctx context.Context, fetcher Fetcher, witsdb db.OsnDB,
+	ids <-chan osn.GameID, workspace pipeline.Workspace, workers uint, max_attempts int) int {
+	var count atomic.Int32
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var replayID osn.GameID
+				select {
+				case <-ctx.Done():
+					return
+				case id, ok := <-ids:
+					if !ok {
+						return
+					}
+					replayID = id
+				}
+
+				err := fetcher.FetchReplay(ctx, replayID, workspace)
+				if err != nil {
+					if ctx.Err() != nil && !is_permanent(err) {
+						continue
+					}
+					fmt.Println("ERROR: ", err)
+					var path_err *fs.PathError
+					if errors.As(err, &path_err) || errors.Is(err, ErrNotCached) {
+						continue // A local failure, not a failure of the replay.
+					}
+					err = record_failure(witsdb, replayID, err, max_attempts)
+					if err != nil {
+						fmt.Println("ERROR: ", err)
+					}
+					continue
+				}
+				count.Add(1)
+				err = witsdb.UpdateMatchStatus(replayID, osn.STATUS_FETCHED, "fetch", nil)
+				if err != nil {
 					fmt.Println("ERROR: ", err)
 				}
-			} else {
-				replay_index = nil
-			}
-		case err, ok := <-errs:
-			if ok {
-				fmt.Println("ERROR while fetching index pages")
-				fmt.Println(err)
-				return
-			} else {
-				errs = nil
 			}
-		}
-		if replay_index == nil && errs == nil {
-			break
-		}
+		}()
 	}
+	wg.Wait()
+	return int(count.Load())
+}
 
-	fmt.Println("fetch of recent replays completed")
-	fmt.Printf("%d new replays fetched", count)
+// Records the failed fetch in the match's status history, keeping its status,
+// unless the failure is permanent or the match has failed max_attempts times,
+// in which case it is marked INVALID with the reason.
+func record_failure(witsdb db.OsnDB, matchID osn.GameID, reason error, max_attempts int) error {
+	if is_permanent(reason) {
+		return witsdb.UpdateMatchStatus(matchID, osn.STATUS_INVALID, "fetch", reason)
+	}
+	record, err := witsdb.Matches().GetByName(string(matchID))
+	if err != nil {
+		return err
+	}
+	history, err := witsdb.MatchStatusHistory(matchID)
+	if err != nil {
+		return err
+	}
+	failures := 1
+	for _, change := range history {
+		if change.Source == "fetch" && change.Message != "" {
+			failures += 1
+		}
+	}
+	if failures >= max_attempts {
+		return witsdb.UpdateMatchStatus(matchID, osn.STATUS_INVALID, "fetch",
+			fmt.Errorf("%d failed fetches, the last: %w", failures, reason))
+	}
+	return witsdb.UpdateMatchStatus(matchID, record.FetchStatus, "fetch", reason)
 }
 
 func assert_nilerr(err error) {
@@ -0,0 +1,125 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/cmd/fetch/retry.go
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// A failure that is not expected to succeed if the request is repeated, such
+// as a replay that the site does not have or cannot be parsed.  Other errors
+// (timeouts, refused connections, server errors) are considered transient.
+type permanent_error struct {
+	err error
+}
+
+func permanent(err error) error {
+	return permanent_error{err}
+}
+
+func (failure permanent_error) Error() string { return failure.err.Error() }
+func (failure permanent_error) Unwrap() error { return failure.err }
+
+func is_permanent(err error) bool {
+	var failure permanent_error
+	return errors.As(err, &failure)
+}
+
+// An HTTP response that was not successful.
+type status_error struct {
+	url  string
+	code int
+}
+
+func (failure status_error) Error() string {
+	return fmt.Sprintf("%s: %d %s", failure.url, failure.code, http.StatusText(failure.code))
+}
+
+// Returns nil for a successful response, a permanent error for client errors
+// (such as 404) and a transient error for server errors and rate limiting.
+func check_status(response *http.Response) error {
+	code := response.StatusCode
+	if code >= 200 && code < 300 {
+		return nil
+	}
+	failure := status_error{response.Request.URL.String(), code}
+	if code >= 500 ||
+		code == http.StatusTooManyRequests ||
+		code == http.StatusRequestTimeout {
+		return failure
+	}
+	return permanent(failure)
+}
+
+// Exponential backoff between repeated attempts, with jitter so that workers
+// which failed together do not all retry together.
+type backoff struct {
+	attempts int           // the maximum number of attempts, including the first
+	initial  time.Duration // delay after the first failure
+	limit    time.Duration // the largest delay between attempts
+}
+
+var default_backoff = backoff{
+	attempts: 4,
+	initial:  10 * time.Second,
+	limit:    5 * time.Minute}
+
+// The delay after the indicated number of (zero-based) failed attempts; it is
+// chosen uniformly from the upper half of the exponentially growing interval.
+func (policy backoff) delay(failures int) time.Duration {
+	delay := policy.initial << min(failures, 32)
+	if delay <= 0 || delay > policy.limit {
+		delay = policy.limit
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// Calls attempt until it succeeds, fails permanently, or the attempts are used
+// up, waiting between each.  Returns early (with its error) if ctx is done.
+func (policy backoff) retry(ctx context.Context, attempt func() error) error {
+	var err error
+	for i := range max(policy.attempts, 1) {
+		if i > 0 {
+			timer := time.NewTimer(policy.delay(i - 1))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		}
+		err = attempt()
+		if err == nil || is_permanent(err) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return fmt.Errorf("failed %d attempts: %w", max(policy.attempts, 1), err)
+}
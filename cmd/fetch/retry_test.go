@@ -0,0 +1,109 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/cmd/fetch/retry_test.go
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCheckStatus(t *testing.T) {
+	request_url, _ := url.Parse("http://osn.example/api/getReplay/abc")
+	for _, tt := range []struct {
+		code      int
+		failed    bool
+		permanent bool
+	}{
+		{200, false, false},
+		{204, false, false},
+		{404, true, true},
+		{403, true, true},
+		{429, true, false},
+		{500, true, false},
+		{503, true, false},
+	} {
+		response := &http.Response{StatusCode: tt.code, Request: &http.Request{URL: request_url}}
+		err := check_status(response)
+		if (err != nil) != tt.failed || is_permanent(err) != tt.permanent {
+			t.Errorf("status %d: got %v (permanent %t)", tt.code, err, is_permanent(err))
+		}
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	policy := backoff{attempts: 5, initial: time.Second, limit: 10 * time.Second}
+	for failures, upper := range []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second,
+		10 * time.Second, 10 * time.Second} {
+		for range 20 {
+			delay := policy.delay(failures)
+			if delay < upper/2 || delay > upper {
+				t.Errorf("delay %s after %d failures, expected within [%s, %s]",
+					delay, failures+1, upper/2, upper)
+			}
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	policy := backoff{attempts: 3, initial: time.Millisecond, limit: time.Millisecond}
+	ctx := context.Background()
+	transient := errors.New("timeout")
+
+	attempts := 0
+	err := policy.retry(ctx, func() error {
+		attempts += 1
+		if attempts < 3 {
+			return transient
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Errorf("expected success on the third attempt, got %v after %d", err, attempts)
+	}
+
+	attempts = 0
+	err = policy.retry(ctx, func() error { attempts += 1; return transient })
+	if !errors.Is(err, transient) || is_permanent(err) || attempts != 3 {
+		t.Errorf("expected the transient error after 3 attempts, got %v after %d", err, attempts)
+	}
+
+	attempts = 0
+	err = policy.retry(ctx, func() error { attempts += 1; return permanent(transient) })
+	if !is_permanent(err) || attempts != 1 {
+		t.Errorf("expected a permanent error without retrying, got %v after %d", err, attempts)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	policy.initial, policy.limit = time.Hour, time.Hour
+	attempts = 0
+	err = policy.retry(canceled, func() error { attempts += 1; return transient })
+	if !errors.Is(err, context.Canceled) || attempts != 1 {
+		t.Errorf("expected the retry to stop when canceled, got %v after %d", err, attempts)
+	}
+}
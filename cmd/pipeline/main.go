@@ -0,0 +1,124 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/cmd/pipeline/main.go
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	osn "github.com/kevindamm/wits-osn"
+	"github.com/kevindamm/wits-osn/db"
+	"github.com/kevindamm/wits-osn/pipeline"
+)
+
+// Advances matches through the stages of replay processing.  Matches at the
+// `from` status are run through each stage in turn until they reach `through`.
+func main() {
+	db_path := flag.String("db-path", ".data/osn.db",
+		"path of the sqlite3 database where match status is kept")
+	data_path := flag.String("data", ".data/",
+		"path where replays are read from and stage artifacts are written to")
+	from := flag.String("from", "FETCHED",
+		"status of the matches to start from")
+	through := flag.String("through", "VALIDATED",
+		"status at which to stop advancing matches")
+	workers := flag.Int("workers", 4,
+		"number of matches processed concurrently in each stage")
+	reprocess := flag.Bool("reprocess", false,
+		"return processed (or INVALID) matches with a fetched replay to FETCHED first")
+	history := flag.String("history", "",
+		"print the status history of the indicated match ID, then exit")
+
+	flag.Parse()
+
+	if *history != "" {
+		witsdb := db.OpenOsnDB(*db_path)
+		defer witsdb.Close()
+		print_history(witsdb, osn.GameID(*history))
+		return
+	}
+
+	first, err := osn.ParseFetchStatus(*from)
+	assert_nilerr(err)
+	last, err := osn.ParseFetchStatus(*through)
+	assert_nilerr(err)
+
+	witsdb := db.OpenOsnDB(*db_path)
+	defer witsdb.Close()
+
+	workspace := pipeline.Workspace(*data_path)
+	stages, err := pipeline.NewFilePipeline(workspace)
+	assert_nilerr(err)
+
+	if *reprocess {
+		count, err := pipeline.Reprocess(witsdb, workspace)
+		assert_nilerr(err)
+		fmt.Printf("reprocessing %d matches\n", count)
+	}
+
+	// Interrupting lets the matches in progress finish before exiting.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	for status := first; status != last; {
+		stage, err := stages.StageAfter(status)
+		assert_nilerr(err)
+
+		summary, err := pipeline.RunStage(ctx, witsdb, stage, *workers)
+		assert_nilerr(err)
+		fmt.Printf("%s: %s\n", stage.Name(), summary)
+		if ctx.Err() != nil {
+			fmt.Println("interrupted")
+			return
+		}
+		status = stage.To()
+	}
+}
+
+// Prints each change to the match's status, oldest first.
+func print_history(witsdb db.OsnDB, matchID osn.GameID) {
+	changes, err := witsdb.MatchStatusHistory(matchID)
+	assert_nilerr(err)
+	if len(changes) == 0 {
+		fmt.Printf("no status changes recorded for %s\n", matchID)
+	}
+	for _, change := range changes {
+		fmt.Printf("%s  %-9s -> %-9s  %s", change.Changed.Format(time.DateTime),
+			change.OldStatus, change.NewStatus, change.Source)
+		if change.Message != "" {
+			fmt.Printf(": %s", change.Message)
+		}
+		fmt.Println()
+	}
+}
+
+func assert_nilerr(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/cmd/render/main.go
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	osn "github.com/kevindamm/wits-osn"
+	"github.com/kevindamm/wits-osn/render"
+)
+
+const usage = `usage: render [flags] (replay.json | -map NAME)
+
+Draws a map, or the state of a replay at the start of one of its turns, as SVG.
+With -gif, the whole replay is drawn as an animated GIF instead.
+
+`
+
+func main() {
+	map_name := flag.String("map", "",
+		"name (or shortname) of a map to draw without any game state")
+	turn := flag.Int("turn", -1,
+		"draw the state at the start of this turn, or the final state if negative")
+	out_path := flag.String("o", "",
+		"path of the file to write, or standard output if empty")
+	animate := flag.Bool("gif", false,
+		"draw every turn of the replay as an animated GIF")
+	every_action := flag.Bool("actions", false,
+		"with -gif, also draw a frame after each action")
+	turn_delay := flag.Duration("delay", render.DefaultGIFOptions.TurnDelay,
+		"with -gif, how long the start of each turn is shown")
+	action_delay := flag.Duration("action-delay", render.DefaultGIFOptions.ActionDelay,
+		"with -gif and -actions, how long each action is shown")
+	final_delay := flag.Duration("hold", render.DefaultGIFOptions.FinalDelay,
+		"with -gif, how long the final state is shown")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *animate {
+		if flag.NArg() != 1 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		match, err := load_match(flag.Arg(0))
+		assert_nilerr(err)
+		output, done := open_output(*out_path)
+		defer done()
+		assert_nilerr(render.WriteGIF(output, match, render.GIFOptions{
+			EveryAction: *every_action,
+			TurnDelay:   *turn_delay,
+			ActionDelay: *action_delay,
+			FinalDelay:  *final_delay}))
+		return
+	}
+
+	var legacymap osn.LegacyMap
+	var state *osn.OsnGameState
+	var err error
+	switch {
+	case *map_name != "":
+		legacymap, err = find_map(*map_name)
+	case flag.NArg() == 1:
+		legacymap, state, err = load_state(flag.Arg(0), *turn)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	assert_nilerr(err)
+
+	output, done := open_output(*out_path)
+	defer done()
+	assert_nilerr(render.WriteSVG(output, legacymap, state))
+}
+
+// Creates the output file, or uses standard output if the path is empty.  The
+// returned function closes the file.
+func open_output(path string) (io.Writer, func()) {
+	if path == "" {
+		return os.Stdout, func() {}
+	}
+	file, err := os.Create(path)
+	assert_nilerr(err)
+	return file, func() { assert_nilerr(file.Close()) }
+}
+
+func load_match(filename string) (osn.LegacyMatchWithReplay, error) {
+	filedata, err := os.ReadFile(filename)
+	if err != nil {
+		return osn.LegacyMatchWithReplay{}, err
+	}
+	return osn.DecodeReplay(filedata)
+}
+
+func find_map(name string) (osn.LegacyMap, error) {
+	if legacymap, err := osn.MapByShortname(name); err == nil {
+		return legacymap, nil
+	}
+	return osn.MapByName(name)
+}
+
+// Reads the replay file and selects the state at the start of the indicated
+// turn, along with the map that it is played on.
+func load_state(filename string, turn int) (osn.LegacyMap, *osn.OsnGameState, error) {
+	match, err := load_match(filename)
+	if err != nil {
+		return osn.LegacyMap{}, nil, err
+	}
+	if turn >= len(match.Replay) {
+		return osn.LegacyMap{}, nil, fmt.Errorf("turn %d out of range, replay has %d turns",
+			turn, len(match.Replay))
+	}
+	state := &match.OsnGameState
+	if turn >= 0 {
+		state = &match.Replay[turn].State
+	}
+	legacymap, err := osn.MapByName(state.MapName)
+	return legacymap, state, err
+}
+
+func assert_nilerr(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/cmd/serve/main.go
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/kevindamm/wits-osn/db"
+	"github.com/kevindamm/wits-osn/pipeline"
+)
+
+// Serves the archive's matches, players, maps and replays as JSON, described
+// by the OpenAPI document at /api/v1/openapi.json.  The OSN endpoints for
+// listing and getting replays are served as well, for `fetch -base-url`.
+func main() {
+	db_path := flag.String("db-path", ".data/osn.db",
+		"path of the sqlite3 database where matches are found")
+	data_path := flag.String("data", ".data/",
+		"path where fetched replays and the pipeline's artifacts are found")
+	addr := flag.String("addr", "localhost:8080",
+		"address to listen on")
+
+	flag.Parse()
+
+	witsdb := db.OpenOsnDB(*db_path)
+	defer witsdb.Close()
+
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           NewServer(witsdb, pipeline.Workspace(*data_path)),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// Interrupting lets the requests in progress finish before exiting.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+
+	log.Printf("serving %s on http://%s/api/v1/", *db_path, *addr)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	<-closed
+}
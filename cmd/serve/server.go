@@ -0,0 +1,435 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/cmd/serve/server.go
+
+package main
+
+import (
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	osn "github.com/kevindamm/wits-osn"
+	"github.com/kevindamm/wits-osn/db"
+	"github.com/kevindamm/wits-osn/osnapi"
+	"github.com/kevindamm/wits-osn/pipeline"
+)
+
+// The OpenAPI description of the endpoints below, served at /api/v1/openapi.json
+//
+//go:embed openapi.json
+var openapi []byte
+
+// Pages of matches and players have at most this many results.
+const (
+	default_limit = 50
+	max_limit     = 500
+)
+
+// Serves the match archive as JSON, from the database and the replay files
+// found in the workspace.  The endpoints of OSN that the fetcher uses are also
+// served, see [osnapi.Server].
+type Server struct {
+	witsdb    db.OsnDB
+	workspace pipeline.Workspace
+	mux       *http.ServeMux
+}
+
+func NewServer(witsdb db.OsnDB, workspace pipeline.Workspace) *Server {
+	server := &Server{witsdb, workspace, http.NewServeMux()}
+	server.mux.HandleFunc("GET /api/v1/openapi.json", server.get_openapi)
+	server.mux.HandleFunc("GET /api/v1/matches", server.list_matches)
+	server.mux.HandleFunc("GET /api/v1/matches/{id}", server.get_match)
+	server.mux.HandleFunc("GET /api/v1/matches/{id}/replay", server.get_replay)
+	server.mux.HandleFunc("GET /api/v1/matches/{id}/replay/raw", server.get_raw_replay)
+	server.mux.HandleFunc("GET /api/v1/players", server.list_players)
+	server.mux.HandleFunc("GET /api/v1/players/{id}", server.get_player)
+	server.mux.HandleFunc("GET /api/v1/players/{id}/matches", server.list_player_matches)
+	server.mux.HandleFunc("GET /api/v1/maps", server.list_maps)
+	server.mux.HandleFunc("GET /api/v1/maps/{id}", server.get_map)
+
+	// Tools written for OSN (including our fetcher) can use the archive too.
+	osnapi.NewServer(witsdb, workspace).Register(server.mux)
+	return server
+}
+
+func (server *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	server.mux.ServeHTTP(writer, request)
+}
+
+// A match's metadata with its fetch status.  The gameid is its short ID.
+type MatchResponse struct {
+	osn.LegacyMatch
+	Status string `json:"status"`
+}
+
+func match_response(match osn.LegacyMatch) MatchResponse {
+	match.OsnIndex = int(match.MatchIndex)
+	return MatchResponse{match, match.FetchStatus.String()}
+}
+
+type MatchesResponse struct {
+	Matches []MatchResponse `json:"matches"`
+	Limit   int             `json:"limit"`
+	Offset  int             `json:"offset"`
+}
+
+type PlayersResponse struct {
+	Players []PlayerResponse `json:"players"`
+	Limit   int              `json:"limit"`
+	Offset  int              `json:"offset"`
+}
+
+type PlayerResponse struct {
+	ID int64 `json:"id"`
+	osn.Player
+}
+
+type MapSummary struct {
+	MapID     uint8  `json:"id"`
+	Name      string `json:"name"`
+	Shortname string `json:"shortname"`
+	RoleCount int    `json:"role_count"`
+}
+
+type MapResponse struct {
+	MapSummary
+	osn.LegacyMapDetails
+}
+
+// The error that a request could not be satisfied with, and the HTTP status.
+type status_error struct {
+	status int
+	err    error
+}
+
+func (err status_error) Error() string { return err.err.Error() }
+func (err status_error) Unwrap() error { return err.err }
+
+func bad_request(format string, args ...any) error {
+	return status_error{http.StatusBadRequest, fmt.Errorf(format, args...)}
+}
+
+func not_found(format string, args ...any) error {
+	return status_error{http.StatusNotFound, fmt.Errorf(format, args...)}
+}
+
+func write_json(writer http.ResponseWriter, value any) {
+	writer.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(writer).Encode(value); err != nil {
+		log.Printf("error writing response: %s", err)
+	}
+}
+
+// Writes the error as JSON, with the status of a [status_error] (or 500).
+func write_error(writer http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var serr status_error
+	if errors.As(err, &serr) {
+		status = serr.status
+	} else {
+		log.Print(err)
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
+
+func (server *Server) get_openapi(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(openapi)
+}
+
+func (server *Server) list_matches(writer http.ResponseWriter, request *http.Request) {
+	filter, err := parse_filter(request.URL.Query())
+	if err != nil {
+		write_error(writer, err)
+		return
+	}
+	server.write_matches(writer, filter)
+}
+
+func (server *Server) list_player_matches(writer http.ResponseWriter, request *http.Request) {
+	player, err := server.find_player(request.PathValue("id"))
+	if err != nil {
+		write_error(writer, err)
+		return
+	}
+	filter, err := parse_filter(request.URL.Query())
+	if err != nil {
+		write_error(writer, err)
+		return
+	}
+	filter.PlayerID = player.RowID
+	server.write_matches(writer, filter)
+}
+
+func (server *Server) write_matches(writer http.ResponseWriter, filter db.MatchFilter) {
+	matches, err := server.witsdb.SearchMatches(filter)
+	if err != nil {
+		write_error(writer, err)
+		return
+	}
+	response := MatchesResponse{
+		Matches: make([]MatchResponse, len(matches)),
+		Limit:   filter.Limit,
+		Offset:  filter.Offset}
+	for i, match := range matches {
+		response.Matches[i] = match_response(match)
+	}
+	write_json(writer, response)
+}
+
+func (server *Server) get_match(writer http.ResponseWriter, request *http.Request) {
+	match, err := server.find_match(request.PathValue("id"))
+	if err != nil {
+		write_error(writer, err)
+		return
+	}
+	match.Players, err = server.witsdb.MatchPlayers(match.MatchHash)
+	if err != nil {
+		write_error(writer, err)
+		return
+	}
+	write_json(writer, match_response(match))
+}
+
+// The replay as converted by the pipeline (see [pipeline.FileStages]).
+func (server *Server) get_replay(writer http.ResponseWriter, request *http.Request) {
+	server.serve_artifact(writer, request, osn.STATUS_CONVERTED)
+}
+
+// The replay in its wire format, as it was fetched.
+func (server *Server) get_raw_replay(writer http.ResponseWriter, request *http.Request) {
+	server.serve_artifact(writer, request, osn.STATUS_FETCHED)
+}
+
+func (server *Server) serve_artifact(writer http.ResponseWriter, request *http.Request, status osn.FetchStatus) {
+	match, err := server.find_match(request.PathValue("id"))
+	if err != nil {
+		write_error(writer, err)
+		return
+	}
+	filedata, err := server.workspace.ReadArtifact(status, match.MatchHash)
+	if errors.Is(err, fs.ErrNotExist) {
+		write_error(writer, not_found("no %s replay for match %s",
+			strings.ToLower(status.String()), match.MatchHash.ShortID()))
+		return
+	} else if err != nil {
+		write_error(writer, err)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(filedata)
+}
+
+// Finds the match by its ID or its short ID.
+func (server *Server) find_match(id string) (osn.LegacyMatch, error) {
+	for _, matchID := range []osn.GameID{osn.GameID(id), osn.ExpandShortID(id)} {
+		record, err := server.witsdb.Matches().GetByName(string(matchID))
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return osn.LegacyMatch{}, err
+		}
+		return record.LegacyMatch, nil
+	}
+	return osn.LegacyMatch{}, not_found("no match %s", id)
+}
+
+func (server *Server) list_players(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	page, err := parse_page(query)
+	if err != nil {
+		write_error(writer, err)
+		return
+	}
+	players, err := server.witsdb.SearchPlayers(query.Get("name"), page)
+	if err != nil {
+		write_error(writer, err)
+		return
+	}
+	response := PlayersResponse{
+		Players: make([]PlayerResponse, len(players)),
+		Limit:   page.Limit,
+		Offset:  page.Offset}
+	for i, player := range players {
+		response.Players[i] = PlayerResponse{player.RowID, player}
+	}
+	write_json(writer, response)
+}
+
+func (server *Server) get_player(writer http.ResponseWriter, request *http.Request) {
+	player, err := server.find_player(request.PathValue("id"))
+	if err != nil {
+		write_error(writer, err)
+		return
+	}
+	write_json(writer, PlayerResponse{player.RowID, player})
+}
+
+// Finds the player by their (numeric) ID, or by their name.
+func (server *Server) find_player(id string) (osn.Player, error) {
+	var record *db.PlayerRecord
+	var err error
+	if rowid, parse_err := strconv.ParseInt(id, 10, 64); parse_err == nil {
+		record, err = server.witsdb.Players().Get(rowid)
+	} else {
+		record, err = server.witsdb.Players().GetByName(id)
+	}
+	if err != nil || record.RowID == 0 {
+		return osn.Player{}, not_found("no player %s", id)
+	}
+	return record.Player, nil
+}
+
+func (server *Server) list_maps(writer http.ResponseWriter, request *http.Request) {
+	records, err := server.witsdb.Maps().SelectAll()
+	if err != nil {
+		write_error(writer, err)
+		return
+	}
+	maps := make([]MapSummary, 0)
+	for record := range records {
+		if record.MapID == 0 {
+			continue
+		}
+		maps = append(maps, map_summary(osn.LegacyMap(*record)))
+	}
+	write_json(writer, struct {
+		Maps []MapSummary `json:"maps"`
+	}{maps})
+}
+
+func (server *Server) get_map(writer http.ResponseWriter, request *http.Request) {
+	id := request.PathValue("id")
+	var legacymap osn.LegacyMap
+	var err error
+	if map_id, parse_err := strconv.ParseUint(id, 10, 8); parse_err == nil {
+		legacymap, err = server.witsdb.MapByID(uint8(map_id))
+	} else {
+		legacymap, err = server.witsdb.MapByName(id)
+	}
+	if err != nil || legacymap.MapID == 0 {
+		write_error(writer, not_found("no map %s", id))
+		return
+	}
+	write_json(writer, MapResponse{map_summary(legacymap), legacymap.LegacyMapDetails})
+}
+
+func map_summary(legacymap osn.LegacyMap) MapSummary {
+	return MapSummary{
+		MapID:     legacymap.MapID,
+		Name:      legacymap.Name,
+		Shortname: legacymap.Shortname,
+		RoleCount: legacymap.RoleCount}
+}
+
+func parse_page(query url.Values) (db.Page, error) {
+	page := db.Page{Limit: default_limit}
+	var err error
+	if limit := query.Get("limit"); limit != "" {
+		page.Limit, err = strconv.Atoi(limit)
+		if err != nil || page.Limit <= 0 || page.Limit > max_limit {
+			return page, bad_request("limit must be between 1 and %d", max_limit)
+		}
+	}
+	if offset := query.Get("offset"); offset != "" {
+		page.Offset, err = strconv.Atoi(offset)
+		if err != nil || page.Offset < 0 {
+			return page, bad_request("offset must be a non-negative integer")
+		}
+	}
+	return page, nil
+}
+
+// Parses the query parameters of a match listing, see [db.MatchFilter].
+func parse_filter(query url.Values) (db.MatchFilter, error) {
+	var filter db.MatchFilter
+	var err error
+	filter.Page, err = parse_page(query)
+	if err != nil {
+		return filter, err
+	}
+	if value := query.Get("map"); value != "" {
+		if filter.MapID, err = strconv.Atoi(value); err != nil {
+			legacymap, err := osn.MapByShortname(value)
+			if err != nil {
+				return filter, bad_request("unknown map %s", value)
+			}
+			filter.MapID = int(legacymap.MapID)
+		}
+	}
+	if value := query.Get("season"); value != "" {
+		if filter.Season, err = strconv.Atoi(value); err != nil {
+			return filter, bad_request("season must be an integer")
+		}
+	}
+	if value := query.Get("player"); value != "" {
+		if filter.PlayerID, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return filter, bad_request("player must be a player's (numeric) ID")
+		}
+	}
+	if value := query.Get("competitive"); value != "" {
+		competitive, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, bad_request("competitive must be true or false")
+		}
+		filter.Competitive = &competitive
+	}
+	if value := query.Get("status"); value != "" {
+		status, err := osn.ParseFetchStatus(value)
+		if err != nil {
+			return filter, bad_request("%s", err)
+		}
+		filter.Status = &status
+	}
+	for param, field := range map[string]*time.Time{
+		"since": &filter.Since,
+		"until": &filter.Until,
+	} {
+		if value := query.Get(param); value != "" {
+			if *field, err = parse_time(value); err != nil {
+				return filter, bad_request("%s must be a date or an RFC 3339 time", param)
+			}
+		}
+	}
+	return filter, nil
+}
+
+// Accepts either a date (2012-08-05) or a full timestamp.
+func parse_time(value string) (time.Time, error) {
+	if date, err := time.Parse(time.DateOnly, value); err == nil {
+		return date, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,280 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/cmd/serve/server_test.go
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	osn "github.com/kevindamm/wits-osn"
+	"github.com/kevindamm/wits-osn/db"
+	"github.com/kevindamm/wits-osn/pipeline"
+)
+
+const sampleID = osn.GameID("ahRzfm91dHdpdHRlcnNnYW1lLWhyZHIVCxIIR2FtZVJvb20YgIDQlK_hqAoM")
+
+// A database with the sample match (fetched and converted) and another match
+// which has only been listed.
+func sample_server(t *testing.T) *httptest.Server {
+	tempdir := t.TempDir()
+	workspace := pipeline.Workspace(tempdir)
+	filedata, err := os.ReadFile(path.Join("..", "..", "testdata", string(sampleID)+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := workspace.WriteFetched(sampleID, filedata); err != nil {
+		t.Fatal(err)
+	}
+	replay, err := osn.DecodeReplay(filedata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := workspace.WriteReplay(osn.STATUS_CONVERTED, replay); err != nil {
+		t.Fatal(err)
+	}
+
+	witsdb := db.OpenOsnDB(path.Join(tempdir, "osn.db"))
+	t.Cleanup(witsdb.Close)
+	witsdb.MustCreateAndPopulateTables()
+	for _, player := range []osn.Player{
+		{RowID: 11, GCID: "G:11", Name: "DarthMickeyJJ"},
+		{RowID: 12, GCID: "G:12", Name: "KevinDamm"},
+	} {
+		if err := witsdb.Players().Insert(&db.PlayerRecord{Player: player}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	created := time.Date(2013, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i, match := range []osn.LegacyMatch{
+		{MatchHash: sampleID, MapID: 16, Season: 3, Competitive: true,
+			FetchStatus: osn.STATUS_CONVERTED},
+		{MatchHash: osn.ExpandShortID("listed"), MapID: 7, Season: 3,
+			FetchStatus: osn.STATUS_LISTED},
+	} {
+		match.MatchIndex = int64(i + 1)
+		match.CreatedTime = created.Add(time.Duration(i) * time.Hour)
+		if err := witsdb.Matches().Insert(db.MakeMatchRecord(match)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, role := range []db.PlayerRoleRecord{
+		{MatchID: 1, PlayerID: 11, TurnOrder: 1},
+		{MatchID: 1, PlayerID: 12, TurnOrder: 2},
+		{MatchID: 2, PlayerID: 12, TurnOrder: 1},
+	} {
+		if err := witsdb.Roles().Insert(&role); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := httptest.NewServer(NewServer(witsdb, workspace))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Requests the path, checking the status and decoding the response into value.
+func get_json(t *testing.T, server *httptest.Server, path string, status int, value any) {
+	t.Helper()
+	response, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != status {
+		t.Fatalf("GET %s: status %d, expected %d", path, response.StatusCode, status)
+	}
+	if content := response.Header.Get("Content-Type"); content != "application/json" {
+		t.Errorf("GET %s: content type %s", path, content)
+	}
+	if err := json.NewDecoder(response.Body).Decode(value); err != nil {
+		t.Fatalf("GET %s: %s", path, err)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	server := sample_server(t)
+
+	var page struct {
+		Matches []struct {
+			GameID  string `json:"gameid"`
+			Status  string `json:"status"`
+			MapID   int    `json:"mapid"`
+			Players []any  `json:"players"`
+		} `json:"matches"`
+		Limit  int `json:"limit"`
+		Offset int `json:"offset"`
+	}
+	get_json(t, server, "/api/v1/matches", http.StatusOK, &page)
+	if len(page.Matches) != 2 || page.Matches[0].GameID != "listed" ||
+		page.Matches[1].GameID != sampleID.ShortID() || page.Limit != default_limit {
+		t.Errorf("unexpected listing %+v", page)
+	}
+	for query, expected := range map[string]string{
+		"?map=sweet-tooth":            sampleID.ShortID(),
+		"?map=7":                      "listed",
+		"?status=converted":           sampleID.ShortID(),
+		"?competitive=false":          "listed",
+		"?until=2013-05-01T12:30:00Z": sampleID.ShortID(),
+		"?limit=1&offset=1":           sampleID.ShortID(),
+	} {
+		get_json(t, server, "/api/v1/matches"+query, http.StatusOK, &page)
+		if len(page.Matches) != 1 || page.Matches[0].GameID != expected {
+			t.Errorf("%s: expected only %s, got %+v", query, expected, page.Matches)
+		}
+	}
+	for _, query := range []string{"?limit=0", "?limit=1000", "?status=lost", "?map=nowhere", "?since=yesterday"} {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		get_json(t, server, "/api/v1/matches"+query, http.StatusBadRequest, &failure)
+		if failure.Error == "" {
+			t.Errorf("%s: expected an error message", query)
+		}
+	}
+
+	var match struct {
+		GameID  string `json:"gameid"`
+		Index   int    `json:"id"`
+		Status  string `json:"status"`
+		Players []struct {
+			Player osn.Player `json:"player"`
+			Color  int        `json:"color"`
+		} `json:"players"`
+	}
+	for _, id := range []string{string(sampleID), sampleID.ShortID()} {
+		get_json(t, server, "/api/v1/matches/"+id, http.StatusOK, &match)
+		if match.GameID != sampleID.ShortID() || match.Index != 1 || match.Status != "CONVERTED" ||
+			len(match.Players) != 2 || match.Players[1].Player.Name != "KevinDamm" {
+			t.Errorf("unexpected match %+v", match)
+		}
+	}
+	var failure struct{}
+	get_json(t, server, "/api/v1/matches/missing", http.StatusNotFound, &failure)
+}
+
+func TestReplays(t *testing.T) {
+	server := sample_server(t)
+
+	var raw osn.WireFormat
+	get_json(t, server, "/api/v1/matches/"+sampleID.ShortID()+"/replay/raw", http.StatusOK, &raw)
+	if raw.Wrapper.RoomID != string(sampleID) {
+		t.Errorf("unexpected raw replay for room %s", raw.Wrapper.RoomID)
+	}
+	var converted osn.LegacyMatchWithReplay
+	get_json(t, server, "/api/v1/matches/"+sampleID.ShortID()+"/replay", http.StatusOK, &converted)
+	if len(converted.Replay) != 15 || converted.MapName != "Sweet Tooth" {
+		t.Errorf("unexpected converted replay: %d turns on %s", len(converted.Replay), converted.MapName)
+	}
+	var failure struct{}
+	get_json(t, server, "/api/v1/matches/listed/replay", http.StatusNotFound, &failure)
+}
+
+func TestPlayers(t *testing.T) {
+	server := sample_server(t)
+
+	var players struct {
+		Players []struct {
+			ID   int64  `json:"id"`
+			Name string `json:"name"`
+		} `json:"players"`
+	}
+	get_json(t, server, "/api/v1/players?name=Kev", http.StatusOK, &players)
+	if len(players.Players) != 1 || players.Players[0].ID != 12 {
+		t.Errorf("unexpected players %+v", players)
+	}
+
+	var player struct {
+		ID   int64  `json:"id"`
+		Name string `json:"name"`
+	}
+	for _, id := range []string{"12", "KevinDamm"} {
+		get_json(t, server, "/api/v1/players/"+id, http.StatusOK, &player)
+		if player.ID != 12 || player.Name != "KevinDamm" {
+			t.Errorf("unexpected player %+v", player)
+		}
+	}
+	var failure struct{}
+	get_json(t, server, "/api/v1/players/0", http.StatusNotFound, &failure)
+
+	var page struct {
+		Matches []struct {
+			GameID string `json:"gameid"`
+		} `json:"matches"`
+	}
+	get_json(t, server, "/api/v1/players/11/matches", http.StatusOK, &page)
+	if len(page.Matches) != 1 || page.Matches[0].GameID != sampleID.ShortID() {
+		t.Errorf("unexpected matches for player 11: %+v", page)
+	}
+	get_json(t, server, "/api/v1/players/KevinDamm/matches?limit=5", http.StatusOK, &page)
+	if len(page.Matches) != 2 {
+		t.Errorf("expected 2 matches for KevinDamm, got %+v", page)
+	}
+}
+
+func TestMapsAndDescription(t *testing.T) {
+	server := sample_server(t)
+
+	var maps struct {
+		Maps []MapSummary `json:"maps"`
+	}
+	get_json(t, server, "/api/v1/maps", http.StatusOK, &maps)
+	if len(maps.Maps) != len(osn.Maps()) {
+		t.Errorf("expected %d maps, got %d", len(osn.Maps()), len(maps.Maps))
+	}
+	var legacymap struct {
+		MapSummary
+		Width int               `json:"columns"`
+		Init  []osn.MapTileInit `json:"background"`
+	}
+	for _, id := range []string{"16", "sweet-tooth"} {
+		get_json(t, server, "/api/v1/maps/"+id, http.StatusOK, &legacymap)
+		if legacymap.Name != "Sweet Tooth" || legacymap.Width == 0 || len(legacymap.Init) == 0 {
+			t.Errorf("unexpected map %s: %+v", id, legacymap.MapSummary)
+		}
+	}
+	var failure struct{}
+	get_json(t, server, "/api/v1/maps/99", http.StatusNotFound, &failure)
+
+	var description struct {
+		OpenAPI string         `json:"openapi"`
+		Paths   map[string]any `json:"paths"`
+	}
+	get_json(t, server, "/api/v1/openapi.json", http.StatusOK, &description)
+	if description.OpenAPI == "" {
+		t.Error("expected an OpenAPI version")
+	}
+	for _, pattern := range []string{
+		"/api/v1/matches", "/api/v1/matches/{id}", "/api/v1/matches/{id}/replay",
+		"/api/v1/matches/{id}/replay/raw", "/api/v1/players", "/api/v1/players/{id}",
+		"/api/v1/players/{id}/matches", "/api/v1/maps", "/api/v1/maps/{id}",
+	} {
+		if _, ok := description.Paths[pattern]; !ok {
+			t.Errorf("the OpenAPI description is missing %s", pattern)
+		}
+	}
+}
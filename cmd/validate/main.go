@@ -0,0 +1,114 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/cmd/validate/main.go
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	osn "github.com/kevindamm/wits-osn"
+	"github.com/kevindamm/wits-osn/db"
+	"github.com/kevindamm/wits-osn/pipeline"
+)
+
+// Re-simulates canonical replays and marks each match as VALIDATED or INVALID.
+// This is the validate stage of the pipeline (see `cmd/pipeline`), reading the
+// canonical replays from the workspace.
+//
+// Replay files may be given as arguments instead, these are validated and the
+// result printed without updating the database.
+func main() {
+	db_path := flag.String("db-path", ".data/osn.db",
+		"path of the sqlite3 database where match status is updated")
+	data_path := flag.String("data", ".data/",
+		"path of the workspace where the pipeline wrote canonical replays")
+	workers := flag.Int("workers", 4,
+		"number of matches validated concurrently")
+	revalidate := flag.Bool("revalidate", false,
+		"validate the matches which are already VALIDATED instead")
+
+	flag.Parse()
+
+	if flag.NArg() > 0 {
+		invalid := 0
+		for _, filename := range flag.Args() {
+			if err := validate_file(filename); err != nil {
+				invalid += 1
+				fmt.Printf("%s INVALID: %s\n", filename, err)
+			} else {
+				fmt.Printf("%s valid\n", filename)
+			}
+		}
+		fmt.Printf("%d replays validated, %d invalid\n", flag.NArg()-invalid, invalid)
+		return
+	}
+
+	witsdb := db.OpenOsnDB(*db_path)
+	defer witsdb.Close()
+
+	stages, err := pipeline.NewFilePipeline(pipeline.Workspace(*data_path))
+	assert_nilerr(err)
+	stage, err := stages.StageAfter(osn.STATUS_CANONICAL)
+	assert_nilerr(err)
+	if *revalidate {
+		stage = revalidating{stage}
+	}
+
+	// Interrupting lets the matches in progress finish before exiting.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	summary, err := pipeline.RunStage(ctx, witsdb, stage, *workers)
+	assert_nilerr(err)
+	fmt.Printf("%s: %s\n", stage.Name(), summary)
+}
+
+// The validate stage, run on matches which were already validated.  Those that
+// are still valid keep their status (recording another attempt), the rest are
+// marked INVALID.
+type revalidating struct{ pipeline.Stage }
+
+func (revalidating) From() osn.FetchStatus { return osn.STATUS_VALIDATED }
+
+// Decodes and validates the replay file.
+func validate_file(filename string) error {
+	filedata, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	match, err := osn.DecodeReplay(filedata)
+	if err != nil {
+		return err
+	}
+	return osn.ValidateReplay(match)
+}
+
+func assert_nilerr(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/cmd/view/main.go
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+
+	osn "github.com/kevindamm/wits-osn"
+	"github.com/kevindamm/wits-osn/db"
+	"github.com/kevindamm/wits-osn/pipeline"
+)
+
+const usage = `usage: view [flags] [replay.json]
+
+Steps through a replay in the terminal, loaded from a file or (with -game)
+from the artifacts of a match in the database.
+
+  right, l, space   next action        left, h   previous action
+  down, j, n        next turn          up, k, p  previous turn
+  g                 first turn         G         last turn
+  q                 quit
+
+`
+
+func main() {
+	game_id := flag.String("game", "",
+		"ID of the match to view, found by its status in the database")
+	db_path := flag.String("db-path", ".data/osn.db",
+		"path of the sqlite3 database where matches are found")
+	data_path := flag.String("data", ".data/",
+		"path where the pipeline wrote the replay artifacts")
+	print_turn := flag.Int("print", -1,
+		"print the indicated turn (and its actions) without interaction")
+	plain := flag.Bool("plain", false,
+		"do not use ANSI colors")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var match osn.LegacyMatchWithReplay
+	var err error
+	switch {
+	case *game_id != "":
+		match, err = load_match(*db_path, pipeline.Workspace(*data_path), osn.GameID(*game_id))
+	case flag.NArg() == 1:
+		match, err = load_file(flag.Arg(0))
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	assert_nilerr(err)
+
+	viewer, err := NewViewer(match, !*plain)
+	assert_nilerr(err)
+	if *print_turn >= 0 {
+		assert_nilerr(viewer.PrintTurn(os.Stdout, *print_turn))
+		return
+	}
+	assert_nilerr(viewer.Interact(os.Stdin, os.Stdout))
+}
+
+func load_file(filename string) (osn.LegacyMatchWithReplay, error) {
+	filedata, err := os.ReadFile(filename)
+	if err != nil {
+		return osn.LegacyMatchWithReplay{}, err
+	}
+	return osn.DecodeReplay(filedata)
+}
+
+// Artifacts are preferred in their original orientation, over canonical ones.
+var artifact_order = []osn.FetchStatus{
+	osn.STATUS_CONVERTED,
+	osn.STATUS_UNWRAPPED,
+	osn.STATUS_FETCHED,
+	osn.STATUS_CANONICAL,
+}
+
+// Finds the match in the database and reads the first of its replay artifacts
+// that can be decoded.
+func load_match(db_path string, workspace pipeline.Workspace, matchID osn.GameID) (osn.LegacyMatchWithReplay, error) {
+	witsdb := db.OpenOsnDB(db_path)
+	defer witsdb.Close()
+
+	record, err := witsdb.Matches().GetByName(string(matchID))
+	if err != nil {
+		return osn.LegacyMatchWithReplay{}, fmt.Errorf("match %s: %w", matchID, err)
+	}
+	log.Printf("match %s is %s", matchID.ShortID(), record.FetchStatus)
+	for _, status := range artifact_order {
+		match, err := workspace.ReadReplay(status, record.MatchHash)
+		if err == nil && len(match.Replay) > 0 {
+			match.LegacyMatch = record.LegacyMatch
+			return match, nil
+		}
+	}
+	return osn.LegacyMatchWithReplay{},
+		fmt.Errorf("no replay found for %s in %s", matchID, workspace)
+}
+
+// Puts the terminal into cbreak mode (keys are read without waiting for a
+// newline, and not echoed), returning the function which restores it.  If the
+// input is not a terminal, keys are read a line at a time instead.
+func cbreak_terminal(input *os.File) func() {
+	get := exec.Command("stty", "-g")
+	get.Stdin = input
+	saved, err := get.Output()
+	if err != nil {
+		return func() {}
+	}
+	set := exec.Command("stty", "cbreak", "-echo")
+	set.Stdin = input
+	if err := set.Run(); err != nil {
+		return func() {}
+	}
+	return func() {
+		restore := exec.Command("stty", strings.TrimSpace(string(saved)))
+		restore.Stdin = input
+		restore.Run()
+	}
+}
+
+// Reads keys from the input, translating arrow keys into their letter
+// equivalents, until the viewer is quit or the input is closed.
+func (viewer *Viewer) Interact(input *os.File, output io.Writer) error {
+	restore := cbreak_terminal(input)
+	defer restore()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		restore()
+		os.Exit(1)
+	}()
+
+	reader := bufio.NewReader(input)
+	viewer.Draw(output)
+	for {
+		key, err := reader.ReadByte()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if key == 0x1b {
+			key = read_arrow(reader)
+		}
+		if !viewer.Key(key) {
+			return nil
+		}
+		if key != '\n' {
+			viewer.Draw(output)
+		}
+	}
+}
+
+// Reads the rest of an arrow key's escape sequence.
+func read_arrow(reader *bufio.Reader) byte {
+	if next, err := reader.ReadByte(); err != nil || next != '[' {
+		return 0
+	}
+	arrow, err := reader.ReadByte()
+	if err != nil {
+		return 0
+	}
+	switch arrow {
+	case 'A':
+		return 'k'
+	case 'B':
+		return 'j'
+	case 'C':
+		return 'l'
+	case 'D':
+		return 'h'
+	}
+	return 0
+}
+
+func assert_nilerr(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
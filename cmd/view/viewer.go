@@ -0,0 +1,166 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/cmd/view/viewer.go
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	osn "github.com/kevindamm/wits-osn"
+	"github.com/kevindamm/wits-osn/render"
+)
+
+// The game state before any action of a turn, or after one of its actions.
+type frame struct {
+	turn   int
+	action int // -1 for the state recorded at the start of the turn
+	state  osn.OsnGameState
+}
+
+// A replay, as a sequence of frames, and the frame that is being viewed.
+type Viewer struct {
+	match     osn.LegacyMatchWithReplay
+	legacymap osn.LegacyMap
+	frames    []frame
+	current   int
+	ansi      bool
+
+	// Why the actions of a turn could not be applied, by turn.
+	errors map[int]error
+}
+
+// Prepares the frames of the replay by applying each turn's actions to the
+// state recorded at the start of the turn.  If an action can't be applied, the
+// remaining actions of that turn are not shown.
+func NewViewer(match osn.LegacyMatchWithReplay, ansi bool) (*Viewer, error) {
+	if len(match.Replay) == 0 {
+		return nil, osn.ErrEmptyReplay
+	}
+	legacymap, err := osn.MapByName(match.Replay[0].State.MapName)
+	if err != nil {
+		return nil, err
+	}
+	viewer := &Viewer{match: match, legacymap: legacymap, ansi: ansi,
+		errors: make(map[int]error)}
+	for i, turn := range match.Replay {
+		state := turn.State
+		viewer.frames = append(viewer.frames, frame{i, -1, state})
+		for j, action := range turn.Actions {
+			state, err = osn.Apply(state, action)
+			if err != nil {
+				viewer.errors[i] = fmt.Errorf("action %d: %w", j, err)
+				break
+			}
+			viewer.frames = append(viewer.frames, frame{i, j, state})
+		}
+	}
+	return viewer, nil
+}
+
+// Moves through the replay according to the key, returning false to quit.
+func (viewer *Viewer) Key(key byte) bool {
+	switch key {
+	case 'q', 'Q':
+		return false
+	case 'l', ' ':
+		viewer.current = min(viewer.current+1, len(viewer.frames)-1)
+	case 'h':
+		viewer.current = max(viewer.current-1, 0)
+	case 'j', 'n':
+		viewer.seek_turn(viewer.frames[viewer.current].turn + 1)
+	case 'k', 'p':
+		turn := viewer.frames[viewer.current].turn
+		if viewer.frames[viewer.current].action == -1 {
+			turn -= 1
+		}
+		viewer.seek_turn(turn)
+	case 'g':
+		viewer.current = 0
+	case 'G':
+		viewer.seek_turn(len(viewer.match.Replay) - 1)
+	}
+	return true
+}
+
+// Moves to the start of the indicated turn, if it exists.
+func (viewer *Viewer) seek_turn(turn int) {
+	for i, frame := range viewer.frames {
+		if frame.turn == turn && frame.action == -1 {
+			viewer.current = i
+			return
+		}
+	}
+}
+
+// Clears the terminal and draws the current frame.
+func (viewer *Viewer) Draw(output io.Writer) {
+	if viewer.ansi {
+		fmt.Fprint(output, "\x1b[H\x1b[2J")
+	}
+	fmt.Fprint(output, viewer.describe(viewer.frames[viewer.current]))
+}
+
+// Prints every frame of the turn, one after another.
+func (viewer *Viewer) PrintTurn(output io.Writer, turn int) error {
+	if turn >= len(viewer.match.Replay) {
+		return fmt.Errorf("turn %d is beyond the last turn (%d)",
+			turn, len(viewer.match.Replay)-1)
+	}
+	for _, frame := range viewer.frames {
+		if frame.turn == turn {
+			fmt.Fprintln(output, viewer.describe(frame))
+		}
+	}
+	return nil
+}
+
+// A heading for the frame, the board and status, then the next action.
+func (viewer *Viewer) describe(frame frame) string {
+	var text strings.Builder
+	turn := viewer.match.Replay[frame.turn]
+	fmt.Fprintf(&text, "%s  turn %d/%d", viewer.legacymap.Name,
+		frame.turn+1, len(viewer.match.Replay))
+	if frame.action >= 0 {
+		fmt.Fprintf(&text, "  after action %d/%d: %s",
+			frame.action+1, len(turn.Actions), describe_action(turn.Actions[frame.action]))
+	}
+	text.WriteString("\n")
+	text.WriteString(render.Terminal(viewer.legacymap, &frame.state, viewer.ansi))
+
+	if next := frame.action + 1; next < len(turn.Actions) {
+		fmt.Fprintf(&text, "  next: %s\n", describe_action(turn.Actions[next]))
+	}
+	if err, ok := viewer.errors[frame.turn]; ok {
+		fmt.Fprintf(&text, "  cannot replay this turn past %s\n", err)
+	}
+	return text.String()
+}
+
+func describe_action(action osn.OsnPlayerAction) string {
+	fields := strings.TrimPrefix(fmt.Sprintf("%+v", action), "&")
+	if fields == "{}" {
+		return action.Name()
+	}
+	return action.Name() + " " + fields
+}
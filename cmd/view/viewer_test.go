@@ -0,0 +1,90 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/cmd/view/viewer_test.go
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	osn "github.com/kevindamm/wits-osn"
+)
+
+func TestViewerKeys(t *testing.T) {
+	filedata, err := os.ReadFile("../../testdata/ahRzfm91dHdpdHRlcnNnYW1lLWhyZHIVCxIIR2FtZVJvb20YgIDQlK_hqAoM.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	match, err := osn.DecodeReplay(filedata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	viewer, err := NewViewer(match, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(viewer.errors) != 0 {
+		t.Errorf("unexpected errors replaying the sample: %v", viewer.errors)
+	}
+
+	position := func() (int, int) {
+		frame := viewer.frames[viewer.current]
+		return frame.turn, frame.action
+	}
+	steps := []struct {
+		key          byte
+		turn, action int
+	}{
+		{'h', 0, -1},
+		{'l', 0, 0},
+		{'l', 0, 1},
+		{'j', 1, -1},
+		{'k', 0, -1},
+		{'G', 14, -1},
+		{'j', 14, -1},
+		{'l', 14, -1},
+		{'p', 13, -1},
+		{'g', 0, -1},
+	}
+	for i, step := range steps {
+		if !viewer.Key(step.key) {
+			t.Fatalf("step %d: key %c quit", i, step.key)
+		}
+		if turn, action := position(); turn != step.turn || action != step.action {
+			t.Errorf("step %d (%c): at turn %d action %d, expected %d %d",
+				i, step.key, turn, action, step.turn, step.action)
+		}
+	}
+	if viewer.Key('q') {
+		t.Error("expected q to quit")
+	}
+
+	var output bytes.Buffer
+	if err := viewer.PrintTurn(&output, 4); err != nil {
+		t.Fatal(err)
+	}
+	if count := strings.Count(output.String(), "Sweet Tooth  turn 5/15"); count != 11 {
+		t.Errorf("expected the turn's state and its 10 actions, got %d frames", count)
+	}
+}
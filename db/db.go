@@ -24,8 +24,11 @@ package db
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	osn "github.com/kevindamm/wits-osn"
 	_ "github.com/mattn/go-sqlite3"
@@ -40,14 +43,40 @@ type OsnDB interface {
 
 	MapByID(id uint8) (osn.LegacyMap, error)
 	MapByName(name string) (osn.LegacyMap, error)
+	Maps() Table[*LegacyMapRecord]
 
 	Players() MutableTable[*PlayerRecord]
 	Matches() MutableTable[*LegacyMatchRecord]
+	Roles() MutableTable[*PlayerRoleRecord]
 	Standings() MutableTable[*StandingsRecord]
 
-	UpdateMatchStatus(osn.GameID, osn.FetchStatus) error
+	// Matches satisfying the filter, most recently created first (by default).
+	SearchMatches(MatchFilter) ([]osn.LegacyMatch, error)
+	// The number of matches satisfying the filter, ignoring its page.
+	CountMatches(MatchFilter) (int, error)
+	// Players whose name begins with the prefix, ordered by name.
+	SearchPlayers(prefix string, page Page) ([]osn.Player, error)
+	// The players of a match (with their turn order), in turn order.  A match
+	// which is unknown, or has no roles, has no players.
+	MatchPlayers(osn.GameID) ([]osn.PlayerRole, error)
+
+	// Inserts or updates the match along with its players and roles, setting
+	// the match's index and status to those in the database.  The status of a
+	// new match is recorded in its history, from the indicated source.
+	UpsertMatch(match *osn.LegacyMatch, source string) error
+	// Begins a transaction for upserting many matches, see [Batch].
+	BeginBatch() (Batch, error)
+
+	// Sets the match's status, recording the change in its status history along
+	// with the tool (or stage) making the change and the reason, if any.
+	UpdateMatchStatus(matchID osn.GameID, status osn.FetchStatus, source string, reason error) error
+	// All of the changes to the match's status, oldest first.
+	MatchStatusHistory(osn.GameID) ([]StatusChangeRecord, error)
 }
 
+// Returned when a match's status is updated to one it can't become.
+var ErrStatusTransition = errors.New("illegal status transition")
+
 // Opens a Sqlite db at indicated path and prepares queries.
 // Does not create tables, only prepares the connection and statements.
 //
@@ -58,6 +87,12 @@ func OpenOsnDB(filepath string) OsnDB {
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err := migrate_map_details(osndb.sqldb, osndb.maps.Name()); err != nil {
+		log.Fatal(err)
+	}
+	if err := migrate_match_statuses(osndb.sqldb); err != nil {
+		log.Fatal(err)
+	}
 	return OsnDB(osndb)
 }
 
@@ -67,6 +102,9 @@ type osndb struct {
 	status  EnumTable[osn.FetchStatus]
 	leagues EnumTable[osn.LeagueEnum]
 	races   EnumTable[osn.UnitRaceEnum]
+	classes EnumTable[osn.UnitClass]
+	tiles   EnumTable[osn.MapTileType]
+	capture EnumTable[osn.TileType]
 
 	maps Table[*LegacyMapRecord]
 
@@ -74,6 +112,7 @@ type osndb struct {
 	matches   MutableTable[*LegacyMatchRecord]
 	roles     MutableTable[*PlayerRoleRecord]
 	standings MutableTable[*StandingsRecord]
+	history   MutableTable[*StatusChangeRecord]
 }
 
 // Opens a connection to the database but does not prepare any queries.
@@ -84,7 +123,7 @@ type osndb struct {
 // Internally, this is used to get a connection without preparing queries (which
 // would fail when required tables had not been created yet).
 func open_database(filepath string) (*osndb, error) {
-	db, err := sql.Open("sqlite3", filepath)
+	db, err := sql.Open("sqlite3", connection_dsn(filepath))
 	if err != nil {
 		return nil, err
 	}
@@ -99,16 +138,37 @@ func open_database(filepath string) (*osndb, error) {
 		osn.EnumValuesFor(osn.LeagueRange))
 	osndb.races = MakeEnumTable("races",
 		osn.EnumValuesFor(osn.UnitRaceRange))
+	osndb.classes = MakeEnumTable("unit_classes",
+		osn.EnumValuesFor(osn.UnitClassRange))
+	osndb.tiles = MakeEnumTable("map_tiles",
+		osn.EnumValuesFor(osn.MapTileRange))
+	osndb.capture = MakeEnumTable("tile_states",
+		osn.EnumValuesFor(osn.TileTypeRange))
 
 	osndb.maps = MakeMapsTable(osndb.sqldb)
 	osndb.players = MakePlayersTable(osndb.sqldb)
 	osndb.matches = MakeMatchesTable(osndb.sqldb)
 	osndb.roles = MakeRolesTable(osndb.sqldb)
 	osndb.standings = MakeStandingsTable(osndb.sqldb)
+	osndb.history = MakeStatusHistoryTable(osndb.sqldb)
 
 	return osndb, nil
 }
 
+// Transactions lock the database for writing when they begin, waiting on any
+// other writer (up to the busy timeout).  With the default deferred locking,
+// two transactions that have both read cannot both upgrade to write, and one
+// of them fails immediately with "database is locked".  The fetch workers and
+// pipeline stages update statuses concurrently, so this must not happen.
+const connection_params = "_txlock=immediate&_busy_timeout=10000"
+
+func connection_dsn(filepath string) string {
+	if strings.Contains(filepath, "?") {
+		return filepath + "&" + connection_params
+	}
+	return filepath + "?" + connection_params
+}
+
 func (db *osndb) Close() {
 	// TODO: Perhaps track all open requests via [Context] and cancel them too.
 	db.sqldb.Close()
@@ -130,13 +190,80 @@ func (db *osndb) MapByName(name string) (osn.LegacyMap, error) {
 	return osn.LegacyMap(*record), nil
 }
 
+func (db *osndb) Maps() Table[*LegacyMapRecord]             { return db.maps }
 func (db *osndb) Players() MutableTable[*PlayerRecord]      { return db.players }
 func (db *osndb) Matches() MutableTable[*LegacyMatchRecord] { return db.matches }
+func (db *osndb) Roles() MutableTable[*PlayerRoleRecord]    { return db.roles }
 func (db *osndb) Standings() MutableTable[*StandingsRecord] { return db.standings }
 
-func (db *osndb) UpdateMatchStatus(matchID osn.GameID, status osn.FetchStatus) error {
-	// TODO
-	return nil
+// Sets the fetch_status of the match and appends the change to its history, in
+// a single transaction (which holds the write lock from when it begins, so the
+// old status can't change before the new one is written).  Returns an error if
+// there is no match with the ID, or if its status can't become the new status
+// (see [osn.FetchStatus.CanBecome]).  Keeping the same status is allowed, it
+// records the attempt (e.g. a fetch that failed and will be retried).
+func (db *osndb) UpdateMatchStatus(matchID osn.GameID, status osn.FetchStatus, source string, reason error) error {
+	if !status.IsValid() {
+		return fmt.Errorf("invalid fetch status %d", status)
+	}
+	tx, err := db.sqldb.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	change := StatusChangeRecord{
+		NewStatus: status,
+		Changed:   time.Now().UTC(),
+		Source:    source}
+	if reason != nil {
+		change.Message = reason.Error()
+	}
+	err = tx.QueryRow(
+		`SELECT rowid, fetch_status FROM matches WHERE match_hash = ?;`,
+		matchID).Scan(&change.MatchID, &change.OldStatus)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no match %s to update", matchID)
+	} else if err != nil {
+		return err
+	}
+	if change.OldStatus != status && !change.OldStatus.CanBecome(status) {
+		return fmt.Errorf("%w: match %s is %s, can't become %s",
+			ErrStatusTransition, matchID, change.OldStatus, status)
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE matches SET fetch_status = ? WHERE rowid = ?;`,
+		status, change.MatchID); err != nil {
+		return err
+	}
+	if err := insert_status_change(tx, change); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *osndb) MatchStatusHistory(matchID osn.GameID) ([]StatusChangeRecord, error) {
+	record := NewStatusChangeRecord()
+	rows, err := db.sqldb.Query(fmt.Sprintf(
+		`SELECT %s FROM %s
+		WHERE match_id = (SELECT rowid FROM matches WHERE match_hash = ?)
+		ORDER BY changed_ts, rowid;`,
+		strings.Join(record.Columns(), ", "), db.history.Name()),
+		matchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := make([]StatusChangeRecord, 0)
+	for rows.Next() {
+		if err := rows.Scan(record.Scannables()...); err != nil {
+			return nil, err
+		}
+		history = append(history, *record)
+	}
+	return history, rows.Err()
 }
 
 // Only needs to be called once at database setup.  Also closes the database.
@@ -146,11 +273,15 @@ func (db *osndb) MustCreateAndPopulateTables() {
 		db.status,
 		db.leagues,
 		db.races,
+		db.classes,
+		db.tiles,
+		db.capture,
 		db.maps,
 		db.players,
 		db.matches,
 		db.roles,
 		db.standings,
+		db.history,
 	} {
 		createsql := table.SqlCreate()
 		log.Println(createsql)
@@ -164,5 +295,13 @@ func (db *osndb) MustCreateAndPopulateTables() {
 				must_execsql(db.sqldb, sql)
 			}
 		}
+
+		if seeded, ok := table.(SeededTable); ok {
+			records := seeded.SeedRecords()
+			log.Printf("INSERT INTO %s ... (%d records)", table.Name(), len(records))
+			for _, record := range records {
+				must_insert(db.sqldb, table.Name(), record)
+			}
+		}
 	}
 }
@@ -52,4 +52,7 @@ func TestDB(t *testing.T) {
 	if osnmap.Name != "Candy Core Mine" {
 		t.Errorf("unexpected name %s for (map 5) Candy Core Mine", osnmap.Name)
 	}
+	if osnmap.Width != 13 || osnmap.Height != 13 || len(osnmap.Init) != 83 {
+		t.Errorf("map details not persisted for (map 5) Candy Core Mine")
+	}
 }
@@ -26,7 +26,6 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"fmt"
-	"strings"
 
 	osn "github.com/kevindamm/wits-osn"
 )
@@ -40,7 +39,7 @@ func NewMapRecord() *LegacyMapRecord {
 }
 
 func (LegacyMapRecord) Columns() []string {
-	return []string{"id", "name", "shortname", "role_count"}
+	return []string{"id", "name", "shortname", "role_count", "details"}
 }
 
 func (record LegacyMapRecord) Values() ([]any, error) {
@@ -49,6 +48,7 @@ func (record LegacyMapRecord) Values() ([]any, error) {
 		record.Name,
 		record.Shortname,
 		record.RoleCount,
+		record.LegacyMapDetails,
 	}, nil
 }
 
@@ -70,40 +70,46 @@ func (record LegacyMapRecord) NamedValues() ([]driver.NamedValue, error) {
 			Name:    "role_count",
 			Ordinal: 3,
 			Value:   record.RoleCount},
+		{
+			Name:    "details",
+			Ordinal: 4,
+			Value:   record.LegacyMapDetails},
 	}, nil
 }
 
 func (record *LegacyMapRecord) ScanValues(values ...driver.Value) error {
-	var ok bool
-	record.MapID, ok = values[0].(uint8)
+	map_id, ok := values[0].(int64)
 	if !ok {
-		return fmt.Errorf("LegacyMap.MapID value %v not uint8", values[0])
+		return fmt.Errorf("LegacyMap.MapID value %v not int64", values[0])
 	}
+	record.MapID = uint8(map_id)
 	record.Name, ok = values[1].(string)
 	if !ok {
 		return fmt.Errorf("LegacyMap.Name value %v not string", values[1])
 	}
 	record.Shortname, ok = values[2].(string)
 	if !ok {
-		return fmt.Errorf("LegacyMap.Shortname value %v not []byte", values[3])
+		return fmt.Errorf("LegacyMap.Shortname value %v not string", values[2])
 	}
-	record.RoleCount, ok = values[3].(int)
+	role_count, ok := values[3].(int64)
 	if !ok {
-		return fmt.Errorf("LegacyMap.RoleCount value %v not int", values[2])
+		return fmt.Errorf("LegacyMap.RoleCount value %v not int64", values[3])
 	}
-	return nil
+	record.RoleCount = int(role_count)
+	return record.LegacyMapDetails.Scan(values[4])
 }
 
 func (record *LegacyMapRecord) ScanRow(row *sql.Row) error {
-	return row.Scan(&record.MapID, &record.Name, &record.Shortname, &record.RoleCount)
+	return row.Scan(record.Scannables()...)
 }
 
-// This is provided for completeness but it has a flaw in that the details are
-// not recoverable.  However, at present this method is only called from the
-// `SELECT * FROM maps;` path, so it actually works out to get an abbreviation.
 func (record *LegacyMapRecord) Scannables() []any {
 	return []any{
-		&record.MapID, &record.Name, &record.RoleCount, &record.Shortname,
+		&record.MapID,
+		&record.Name,
+		&record.Shortname,
+		&record.RoleCount,
+		&record.LegacyMapDetails,
 	}
 }
 
@@ -128,38 +134,71 @@ func (table tableMaps) SqlCreate() string {
 	return fmt.Sprintf(`CREATE TABLE "%s" (
       "id"          INTEGER PRIMARY KEY,
       "name"        TEXT NOT NULL,
-			"shortname"   VARCHAR(127) UNIQUE,
+      "shortname"   VARCHAR(127) UNIQUE,
       "role_count"  INTEGER
-			  CHECK(role_count == 2 OR role_count == 4 OR role_count == 0)
+        CHECK(role_count == 2 OR role_count == 4 OR role_count == 0),
+      "details"     BLOB NOT NULL  -- JSON encoding of LegacyMapDetails
     ) WITHOUT ROWID;`, table.name)
 }
 
+// Map rows are inserted by SeedRecords(), the details do not belong in a literal.
 func (table tableMaps) SqlInit() string {
-	return strings.Join([]string{
-		fmt.Sprintf(`INSERT INTO %s VALUES
-	    (0, "UNKNOWN", "", 0)`, table.name),
-
-		fmt.Sprintf(`INSERT INTO %s
-      (id, name, role_count, shortname)
-    VALUES
-      (1,      "Machination",       4, "machination"),
-      (2,      "Foundry (v1)",      0, "foundry-deprecated"),
-      (3,      "Foundry",           2, "foundry"),
-			(4,      "Glitch",            2, "glitch"),
-			(5,      "Candy Core Mine",   4, "candy-core-mine"),
-			(6,      "Sweetie Plains",    2, "sweetie-plains"),
-			(7,      "Peek-a-boo",        2, "peekaboo"),
-			(8,      "Blitz Beach",       4, "blitz-beach"),
-			(9,      "Long Nine",         2, "long-nine"),
-			(10,     "Sharkfood Island",  2, "sharkfood-island"),
-			(11,     "Acrospire",         4, "acrospire"),
-			(12,     "Thorn Gulley",      2, "thorn-gulley"),
-			(13,     "Reaper",            2, "reaper"),
-			(14,     "Skull Duggery",     2, "skull-duggery"),
-			(15,     "War Garden",        2, "war-garden"),
-			(16,     "Sweet Tooth",       2, "sweet-tooth"),
-			(17,     "Sugar Rock",        4, "sugar-rock"),
-			(18,     "Mechanism",         4, "mechanism")`,
-			table.name),
-	}, ";\n")
+	return ""
+}
+
+// The map rows, derived from the map catalog in [osn.Maps], including layouts.
+// This allows the database to be used without access to the `maps/` source.
+func (table tableMaps) SeedRecords() []Record {
+	records := []Record{(*LegacyMapRecord)(&osn.LegacyMap{Name: "UNKNOWN"})}
+	for _, legacymap := range osn.Maps() {
+		records = append(records, (*LegacyMapRecord)(&legacymap))
+	}
+	return records
+}
+
+// Databases created before map details were stored have no "details" column.
+// It is added (as an empty JSON object) and then filled from the map catalog.
+func migrate_map_details(sqldb *sql.DB, table string) error {
+	rows, err := sqldb.Query(
+		fmt.Sprintf(`SELECT name FROM pragma_table_info('%s');`, table))
+	if err != nil {
+		return err
+	}
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			rows.Close()
+			return err
+		}
+		columns[column] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(columns) == 0 || columns["details"] {
+		// The table is yet to be created, or is already up to date.
+		return nil
+	}
+
+	tx, err := sqldb.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(fmt.Sprintf(
+		`ALTER TABLE "%s" ADD COLUMN "details" BLOB NOT NULL DEFAULT X'7B7D';`,
+		table))
+	if err != nil {
+		return err
+	}
+	update := fmt.Sprintf(`UPDATE "%s" SET details = ? WHERE id = ?;`, table)
+	for _, legacymap := range osn.Maps() {
+		_, err = tx.Exec(update, legacymap.LegacyMapDetails, legacymap.MapID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
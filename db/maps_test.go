@@ -23,6 +23,8 @@
 package db_test
 
 import (
+	"database/sql"
+	"path"
 	"testing"
 
 	"github.com/kevindamm/wits-osn/db"
@@ -46,6 +48,64 @@ func TestMapsTable(t *testing.T) {
 	if mapobj.MapID != 3 || mapobj.Name != "Foundry" {
 		t.Error("retrieved incorrect map for ID 3")
 	}
+	if mapobj.Width != 13 || mapobj.Height != 12 || len(mapobj.Init) == 0 {
+		t.Error("map details were not joined with the Foundry map")
+	}
 
 	// Maps are read-only and no INSERT/DELETE interface is exposed.
 }
+
+func TestMapsSelectAll(t *testing.T) {
+	osndb := db.OpenOsnDB(":memory:")
+	osndb.MustCreateAndPopulateTables()
+
+	maps, err := osndb.Maps().SelectAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for record := range maps {
+		count += 1
+		if record.RoleCount > 0 && len(record.Init) == 0 {
+			t.Errorf("map %s is missing its tile layout", record.Name)
+		}
+	}
+	if count != 19 {
+		t.Errorf("expected 19 map rows (including UNKNOWN), got %d", count)
+	}
+}
+
+func TestMapsMigrateDetails(t *testing.T) {
+	// A maps table as created before map details were included.
+	filepath := path.Join(t.TempDir(), "osn.db")
+	sqldb, err := sql.Open("sqlite3", filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = sqldb.Exec(`CREATE TABLE "maps" (
+      "id"          INTEGER PRIMARY KEY,
+      "name"        TEXT NOT NULL,
+      "shortname"   VARCHAR(127) UNIQUE,
+      "role_count"  INTEGER
+    ) WITHOUT ROWID;
+    INSERT INTO maps VALUES
+      (0, "UNKNOWN", "", 0),
+      (3, "Foundry", "foundry", 2);`)
+	sqldb.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	osndb := db.OpenOsnDB(filepath)
+	defer osndb.Close()
+	mapobj, err := osndb.MapByName("foundry")
+	if err != nil {
+		t.Fatalf("could not read migrated map: %s", err)
+	}
+	if mapobj.Width != 13 || mapobj.Height != 12 || len(mapobj.Init) == 0 {
+		t.Error("map details were not filled in for Foundry")
+	}
+	if _, err := osndb.MapByID(0); err != nil {
+		t.Errorf("could not read migrated UNKNOWN map: %s", err)
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"fmt"
+	"strings"
 	"time"
 
 	osn "github.com/kevindamm/wits-osn"
@@ -51,15 +52,19 @@ func (*LegacyMatchRecord) Columns() []string {
 		"rowid",
 		"match_hash",
 		"competitive", "season", "created_ts",
-		"turn_count",
+		"map_id", "turn_count",
 		"version",
 		"fetch_status",
 	}
 }
 
 func (record *LegacyMatchRecord) Values() ([]any, error) {
+	var rowid any
+	if record.MatchIndex != 0 {
+		rowid = record.MatchIndex
+	}
 	return []any{
-		record.MatchIndex,
+		rowid,
 		record.MatchHash,
 		record.Competitive,
 		record.Season,
@@ -111,6 +116,11 @@ func (record *LegacyMatchRecord) NamedValues() ([]driver.NamedValue, error) {
 	}, nil
 }
 
+// The match's index, zero until it is assigned when the match is inserted.
+func (record *LegacyMatchRecord) Primary() int64 { return record.MatchIndex }
+
+func (record *LegacyMatchRecord) SetPrimary(rowid int64) { record.MatchIndex = rowid }
+
 func (record *LegacyMatchRecord) ScanValues(values ...driver.Value) error {
 	var ok bool
 	record.MatchIndex, ok = values[0].(int64)
@@ -132,7 +142,7 @@ func (record *LegacyMatchRecord) ScanValues(values ...driver.Value) error {
 	}
 	record.CreatedTime, ok = values[4].(time.Time)
 	if !ok {
-		return fmt.Errorf("LegacyMatch.StartTime value %v not time.Time", values[4])
+		return fmt.Errorf("LegacyMatch.CreatedTime value %v not time.Time", values[4])
 	}
 	record.MapID, ok = values[5].(int)
 	if !ok {
@@ -154,17 +164,7 @@ func (record *LegacyMatchRecord) ScanValues(values ...driver.Value) error {
 }
 
 func (record *LegacyMatchRecord) ScanRow(row *sql.Row) error {
-	row.Scan(
-		&record.MatchIndex,
-		&record.MatchHash,
-		&record.Competitive,
-		&record.Season,
-		&record.CreatedTime,
-		&record.MapID,
-		&record.TurnCount,
-		&record.Version,
-		&record.FetchStatus)
-	return nil
+	return row.Scan(record.Scannables()...)
 }
 
 func (record *LegacyMatchRecord) Scannables() []any {
@@ -224,8 +224,124 @@ func (table tableMatches) SqlCreate() string {
   );`, table.name)
 }
 
+// Inserts the match along with the roles of its players (who are expected to
+// be in the players table already), in a single transaction.  As with other
+// tables, the match's index is set if it was zero.  The match's status is the
+// first entry of its status history, with "insert" as its source.
+func (table tableMatches) Insert(record *LegacyMatchRecord) error {
+	tx, err := table.sqldb.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	values, err := record.Values()
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec(fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s);`,
+		table.name, strings.Join(record.Columns(), ", "), qmarks(len(values))),
+		values...)
+	if err != nil {
+		return err
+	}
+	rowid, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if err := insert_roles(tx, rowid, record.Players); err != nil {
+		return err
+	}
+	if err := insert_initial_status(tx, rowid, record.FetchStatus, "insert"); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	record.MatchIndex = rowid
+	return nil
+}
+
+// Retrieves the match by its index, along with its players.
+func (table tableMatches) Get(id int64) (*LegacyMatchRecord, error) {
+	record, err := table.mutableBase.Get(id)
+	if err != nil {
+		return record, err
+	}
+	record.Players, err = select_roles(table.sqldb, record.MatchIndex)
+	return record, err
+}
+
+// Retrieves the match by its hash, along with its players.
+func (table tableMatches) GetByName(name string) (*LegacyMatchRecord, error) {
+	record, err := table.mutableBase.GetByName(name)
+	if err != nil {
+		return record, err
+	}
+	record.Players, err = select_roles(table.sqldb, record.MatchIndex)
+	return record, err
+}
+
+// Writes a role for each of the players, in the order they are listed unless
+// they have a turn order.
+func insert_roles(tx *sql.Tx, match_id int64, players []osn.PlayerRole) error {
+	for i, role := range players {
+		turn_order := role.TurnOrder
+		if turn_order == 0 {
+			turn_order = osn.PlayerColorEnum(i + 1)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(
+			`INSERT INTO %s (match_id, player_id, turn_order) VALUES (?, ?, ?);`,
+			roles_table), match_id, role.RowID, turn_order); err != nil {
+			return fmt.Errorf("role of %q: %w", role.Name, err)
+		}
+	}
+	return nil
+}
+
+// The players in the match, in turn order, or nil if it has no roles.
+func select_roles(sqldb *sql.DB, match_id int64) ([]osn.PlayerRole, error) {
+	rows, err := sqldb.Query(fmt.Sprintf(
+		`SELECT players.id, COALESCE(players.gcid, ''), players.name, roles.turn_order
+		FROM %s AS roles JOIN %s AS players ON players.id = roles.player_id
+		WHERE roles.match_id = ?
+		ORDER BY roles.turn_order;`, roles_table, players_table), match_id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []osn.PlayerRole
+	for rows.Next() {
+		var role osn.PlayerRole
+		if err := rows.Scan(&role.RowID, &role.GCID, &role.Name, &role.TurnOrder); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// Matches are found by their hash, and the pipeline selects them by status.
 func (tableMatches) SqlInit() string {
-	return `CREATE UNIQUE INDEX match_hashes ON matches (match_hash);`
+	return `CREATE UNIQUE INDEX match_hashes ON matches (match_hash);
+    ` + match_statuses_index
+}
+
+const match_statuses_index = `CREATE INDEX IF NOT EXISTS match_statuses
+      ON matches (fetch_status);`
+
+// Databases created before matches were selected by status have no index of
+// their statuses, it is added when the database is opened.
+func migrate_match_statuses(sqldb *sql.DB) error {
+	var count int
+	err := sqldb.QueryRow(`SELECT COUNT(*) FROM sqlite_master
+	  WHERE type = 'table' AND name = 'matches';`).Scan(&count)
+	if err != nil || count == 0 {
+		return err
+	}
+	_, err = sqldb.Exec(match_statuses_index)
+	return err
 }
 
 // Relation for which players are participating in which matches, and the turn
@@ -297,6 +413,8 @@ func (record *PlayerRoleRecord) Scannables() []any {
 		&record.TurnOrder}
 }
 
+const roles_table = "roles"
+
 type tableRoles struct {
 	mutableBase[*PlayerRoleRecord]
 }
@@ -305,7 +423,7 @@ func MakeRolesTable(sqldb *sql.DB) MutableTable[*PlayerRoleRecord] {
 	return tableRoles{
 		mutableBase[*PlayerRoleRecord]{tableBase[*PlayerRoleRecord]{
 			sqldb: sqldb,
-			name:  "roles",
+			name:  roles_table,
 			zero:  NewRoleRecord(),
 			new:   NewRoleRecord}}}
 }
@@ -315,7 +433,7 @@ func (table tableRoles) SqlCreate() string {
       -- rowid INTEGER PRIMARY KEY,
       "match_id" INTEGER NOT NULL,
       "player_id" INTEGER NOT NULL,
-      "turn_order" INTEGER CHECK(turn_order > 0 AND turn_order <= 2),
+      "turn_order" INTEGER CHECK(turn_order > 0 AND turn_order <= 4),
 
       FOREIGN KEY (match_id)
         REFERENCES matches (rowid)
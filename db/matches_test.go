@@ -23,7 +23,12 @@
 package db_test
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
 	"log"
+	"path"
+	"sync"
 	"testing"
 
 	osn "github.com/kevindamm/wits-osn"
@@ -67,14 +72,34 @@ func TestMatchesTable(t *testing.T) {
 		FirstPlayer: "3",
 	}
 
+	// The match's roles refer to players that are already known.
+	for _, player := range []osn.Player{
+		{RowID: 2, Name: "Alvendor"},
+		{RowID: 3, Name: "Lenoxe"},
+	} {
+		if err := osndb.Players().Insert(&db.PlayerRecord{Player: player}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
 	match := metadata.ToLegacyMatch()
-	err := osndb.Matches().Insert(db.MakeMatchRecord(match))
+	record := db.MakeMatchRecord(match)
+	err := osndb.Matches().Insert(record)
 	if err != nil {
 		t.Errorf("error when inserting match metadata:\n%s", err)
 	}
+	// The listing has no index, one is assigned when it is inserted.
+	if record.MatchIndex == 0 {
+		t.Error("expected an index to be assigned to the inserted match")
+	}
+	match.MatchIndex = record.MatchIndex
 
 	log.Print(metadata, " => ", match)
 	check_match(t, osndb, match)
+
+	if _, err := osndb.Matches().GetByName("missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected no rows for a missing match, got %v", err)
+	}
 }
 
 func check_match(t *testing.T, db db.OsnDB, expected osn.LegacyMatch) {
@@ -97,9 +122,9 @@ func check_match(t *testing.T, db db.OsnDB, expected osn.LegacyMatch) {
 	if match.Season != expected.Season {
 		t.Errorf("season %d != expected.season %d", match.Season, expected.Season)
 	}
-	if match.StartTime != expected.StartTime {
+	if match.CreatedTime != expected.CreatedTime {
 		t.Errorf("start_time %s != expected.start_time %s",
-			match.StartTime, expected.StartTime)
+			match.CreatedTime, expected.CreatedTime)
 	}
 	if match.MapID != expected.MapID {
 		t.Errorf("map_id %d != expected.map_id %d", match.MapID, expected.MapID)
@@ -129,3 +154,142 @@ func check_match(t *testing.T, db db.OsnDB, expected osn.LegacyMatch) {
 		}
 	}
 }
+
+func TestUpdateMatchStatus(t *testing.T) {
+	osndb := db.OpenOsnDB(":memory:")
+	osndb.MustCreateAndPopulateTables()
+
+	match := osn.LegacyMatch{
+		MatchHash:   "ag5vdXR3aXR0ZXJzZ2FtZXIQCxIIR2FtZVJvb20Y9-5HDA",
+		MapID:       7,
+		FetchStatus: osn.STATUS_FETCHED}
+	if err := osndb.Matches().Insert(db.MakeMatchRecord(match)); err != nil {
+		t.Fatal(err)
+	}
+
+	err := osndb.UpdateMatchStatus(match.MatchHash, osn.STATUS_UNWRAPPED, "unwrap", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	err = osndb.UpdateMatchStatus(match.MatchHash, osn.STATUS_INVALID, "convert",
+		errors.New("unknown action DanceAction"))
+	if err != nil {
+		t.Error(err)
+	}
+	record, err := osndb.Matches().GetByName(string(match.MatchHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.FetchStatus != osn.STATUS_INVALID {
+		t.Errorf("status %s, expected %s", record.FetchStatus, osn.STATUS_INVALID)
+	}
+
+	history, err := osndb.MatchStatusHistory(match.MatchHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []db.StatusChangeRecord{
+		{OldStatus: osn.STATUS_UNKNOWN, NewStatus: osn.STATUS_FETCHED,
+			Source: "insert"},
+		{OldStatus: osn.STATUS_FETCHED, NewStatus: osn.STATUS_UNWRAPPED,
+			Source: "unwrap"},
+		{OldStatus: osn.STATUS_UNWRAPPED, NewStatus: osn.STATUS_INVALID,
+			Source: "convert", Message: "unknown action DanceAction"},
+	}
+	if len(history) != len(expected) {
+		t.Fatalf("expected %d status changes, got %d", len(expected), len(history))
+	}
+	for i, change := range history {
+		if change.MatchID != history[0].MatchID || change.Changed.IsZero() ||
+			change.OldStatus != expected[i].OldStatus ||
+			change.NewStatus != expected[i].NewStatus ||
+			change.Source != expected[i].Source ||
+			change.Message != expected[i].Message {
+			t.Errorf("status change %d: %+v", i, change)
+		}
+	}
+
+	if err := osndb.UpdateMatchStatus("unknown", osn.STATUS_INVALID, "test", nil); err == nil {
+		t.Error("expected an error updating an unknown match")
+	}
+	if err := osndb.UpdateMatchStatus(match.MatchHash, osn.FetchStatusRange, "test", nil); err == nil {
+		t.Error("expected an error for an invalid status")
+	}
+	err = osndb.UpdateMatchStatus(match.MatchHash, osn.STATUS_VALIDATED, "test", nil)
+	if !errors.Is(err, db.ErrStatusTransition) {
+		t.Errorf("expected INVALID can't become VALIDATED, got %v", err)
+	}
+	if history, _ := osndb.MatchStatusHistory(match.MatchHash); len(history) != 3 {
+		t.Errorf("failed updates should not be recorded, got %d changes", len(history))
+	}
+}
+
+func TestInsertAssignsIndex(t *testing.T) {
+	osndb := db.OpenOsnDB(":memory:")
+	osndb.MustCreateAndPopulateTables()
+
+	for i, matchID := range []osn.GameID{"first", "second"} {
+		record := db.MakeMatchRecord(osn.LegacyMatch{MatchHash: matchID, MapID: 7})
+		if err := osndb.Matches().Insert(record); err != nil {
+			t.Fatal(err)
+		}
+		if record.MatchIndex != int64(i+1) {
+			t.Errorf("match %s assigned index %d, expected %d", matchID, record.MatchIndex, i+1)
+		}
+	}
+	record := db.MakeMatchRecord(osn.LegacyMatch{MatchIndex: 86401, MatchHash: "listed", MapID: 7})
+	if err := osndb.Matches().Insert(record); err != nil {
+		t.Fatal(err)
+	}
+	if record.MatchIndex != 86401 {
+		t.Errorf("the index of a listed match should be kept, got %d", record.MatchIndex)
+	}
+}
+
+func TestConcurrentStatusUpdates(t *testing.T) {
+	osndb := db.OpenOsnDB(path.Join(t.TempDir(), "osn.db"))
+	defer osndb.Close()
+	osndb.MustCreateAndPopulateTables()
+
+	const workers, rounds = 8, 10
+	for i := range workers {
+		match := osn.LegacyMatch{MatchHash: osn.GameID(fmt.Sprintf("match%d", i)),
+			MapID: 7, FetchStatus: osn.STATUS_LISTED}
+		if err := osndb.Matches().Insert(db.MakeMatchRecord(match)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each worker fetches its match, then unwraps and reprocesses it repeatedly.
+	errs := make(chan error, workers*(2*rounds+1))
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func(matchID osn.GameID) {
+			defer wg.Done()
+			errs <- osndb.UpdateMatchStatus(matchID, osn.STATUS_FETCHED, "fetch", nil)
+			for range rounds {
+				errs <- osndb.UpdateMatchStatus(matchID, osn.STATUS_UNWRAPPED, "unwrap", nil)
+				errs <- osndb.UpdateMatchStatus(matchID, osn.STATUS_FETCHED, "reprocess", nil)
+			}
+		}(osn.GameID(fmt.Sprintf("match%d", i)))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	for i := range workers {
+		history, err := osndb.MatchStatusHistory(osn.GameID(fmt.Sprintf("match%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Along with the status it was inserted with.
+		if len(history) != 2*rounds+2 {
+			t.Errorf("match%d has %d status changes, expected %d", i, len(history), 2*rounds+2)
+		}
+	}
+}
@@ -53,7 +53,7 @@ func (player *PlayerRecord) Columns() []string {
 func (player *PlayerRecord) Values() ([]any, error) {
 	return []any{
 			player.RowID,
-			player.GCID,
+			null_if_empty(player.GCID),
 			player.Name},
 		nil
 }
@@ -67,7 +67,7 @@ func (player *PlayerRecord) NamedValues() ([]driver.NamedValue, error) {
 		{
 			Name:    "gcid",
 			Ordinal: 1,
-			Value:   player.GCID},
+			Value:   null_if_empty(player.GCID)},
 		{
 			Name:    "name",
 			Ordinal: 2,
@@ -81,8 +81,9 @@ func (player *PlayerRecord) ScanValues(values ...driver.Value) error {
 	if !ok {
 		return fmt.Errorf("Player.RowID value %v not int64", values[0])
 	}
-	player.GCID, ok = values[1].(string)
-	if !ok {
+	if values[1] == nil {
+		player.GCID = ""
+	} else if player.GCID, ok = values[1].(string); !ok {
 		return fmt.Errorf("Player.GCID value %v not string", values[1])
 	}
 	player.Name, ok = values[2].(string)
@@ -93,16 +94,39 @@ func (player *PlayerRecord) ScanValues(values ...driver.Value) error {
 }
 
 func (player *PlayerRecord) ScanRow(row *sql.Row) error {
-	return row.Scan(&player.RowID, &player.GCID, &player.Name)
+	return row.Scan(player.Scannables()...)
 }
 
 func (player *PlayerRecord) Scannables() []any {
 	return []any{
 		&player.RowID,
-		&player.GCID,
+		empty_if_null{&player.GCID},
 		&player.Name}
 }
 
+// Most players have no GCID.  It is stored as NULL rather than as "" so that
+// these players do not collide on the UNIQUE constraint of the gcid column.
+func null_if_empty(value string) any {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// Scans a nullable TEXT column into a string, which is empty if it was NULL.
+type empty_if_null struct{ value *string }
+
+func (scanner empty_if_null) Scan(src any) error {
+	var nullable sql.NullString
+	if err := nullable.Scan(src); err != nil {
+		return err
+	}
+	*scanner.value = nullable.String
+	return nil
+}
+
+const players_table = "players"
+
 type tablePlayers struct {
 	mutableBase[*PlayerRecord]
 	cachedPlayers map[int64]*osn.Player
@@ -112,7 +136,7 @@ func MakePlayersTable(sqldb *sql.DB) MutableTable[*PlayerRecord] {
 	return tablePlayers{
 		mutableBase: mutableBase[*PlayerRecord]{tableBase[*PlayerRecord]{
 			sqldb:   sqldb,
-			name:    "players",
+			name:    players_table,
 			zero:    NewPlayerRecord(),
 			new:     NewPlayerRecord,
 			Primary: "id",
@@ -47,20 +47,26 @@ func TestPlayersTable(t *testing.T) {
 		t.Errorf("player name %s expected Player1", player.Name)
 	}
 
+	// A player's ID is not reused, inserting another with the same ID fails.
 	err = osndb.Players().Insert(&db.PlayerRecord{osn.Player{
 		RowID: 1, GCID: "abcde", Name: "First"}})
+	if err == nil {
+		t.Error("expected an error inserting a player with an existing ID")
+	}
+	err = osndb.Players().Insert(&db.PlayerRecord{osn.Player{
+		RowID: 2, GCID: "abcde", Name: "First"}})
 	if err != nil {
 		t.Error(err)
 	}
 	err = osndb.Players().Insert(&db.PlayerRecord{osn.Player{
-		RowID: 2, GCID: "bcdef", Name: "2nd"}})
+		RowID: 3, GCID: "bcdef", Name: "2nd"}})
 	if err != nil {
 		t.Error(err)
 	}
 
-	check_player(t, osndb, 1, "First")
-	check_player(t, osndb, 2, "2nd")
-
+	check_player(t, osndb, 1, "Player1")
+	check_player(t, osndb, 2, "First")
+	check_player(t, osndb, 3, "2nd")
 }
 
 func check_player(t *testing.T, db db.OsnDB, id int64, name string) {
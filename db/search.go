@@ -0,0 +1,190 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/db/search.go
+
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	osn "github.com/kevindamm/wits-osn"
+)
+
+// Limits the number of results and where they begin, for paginated queries.
+// A Limit of zero (or less) does not limit the number of results.
+type Page struct {
+	Limit  int
+	Offset int
+}
+
+func (page Page) sql() (string, []any) {
+	limit := page.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	return `LIMIT ? OFFSET ?`, []any{limit, max(page.Offset, 0)}
+}
+
+// Conditions on the matches returned by [OsnDB.SearchMatches].  Zero values
+// (and nil pointers) match any value.
+type MatchFilter struct {
+	Page
+
+	MapID       int
+	Season      int
+	Competitive *bool
+	Status      *osn.FetchStatus
+	PlayerID    int64 // matches which the player had a role in
+
+	Since time.Time // created at or after
+	Until time.Time // created before
+
+	Ascending bool // lists the oldest matches first
+	ByIndex   bool // orders by the match index rather than its creation time
+}
+
+func (filter MatchFilter) sql() (string, []any) {
+	conditions := []string{}
+	args := []any{}
+	if filter.MapID != 0 {
+		conditions = append(conditions, "map_id = ?")
+		args = append(args, filter.MapID)
+	}
+	if filter.Season != 0 {
+		conditions = append(conditions, "season = ?")
+		args = append(args, filter.Season)
+	}
+	if filter.Competitive != nil {
+		conditions = append(conditions, "competitive = ?")
+		args = append(args, *filter.Competitive)
+	}
+	if filter.Status != nil {
+		conditions = append(conditions, "fetch_status = ?")
+		args = append(args, *filter.Status)
+	}
+	if filter.PlayerID != 0 {
+		conditions = append(conditions,
+			"rowid IN (SELECT match_id FROM roles WHERE player_id = ?)")
+		args = append(args, filter.PlayerID)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "created_ts >= ?")
+		args = append(args, filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "created_ts < ?")
+		args = append(args, filter.Until.UTC())
+	}
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+func (db *osndb) SearchMatches(filter MatchFilter) ([]osn.LegacyMatch, error) {
+	where, args := filter.sql()
+	limit, page_args := filter.Page.sql()
+	order := "DESC"
+	if filter.Ascending {
+		order = "ASC"
+	}
+	order_by := fmt.Sprintf("created_ts %s, rowid %s", order, order)
+	if filter.ByIndex {
+		order_by = fmt.Sprintf("rowid %s", order)
+	}
+	rows, err := db.sqldb.Query(fmt.Sprintf(
+		`SELECT %s FROM %s %s
+		ORDER BY %s %s;`,
+		strings.Join(db.matches.Columns(), ", "), db.matches.Name(), where,
+		order_by, limit),
+		append(args, page_args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := make([]osn.LegacyMatch, 0)
+	for rows.Next() {
+		record := NewMatchRecord()
+		if err := rows.Scan(record.Scannables()...); err != nil {
+			return nil, err
+		}
+		matches = append(matches, record.LegacyMatch)
+	}
+	return matches, rows.Err()
+}
+
+func (db *osndb) CountMatches(filter MatchFilter) (int, error) {
+	where, args := filter.sql()
+	var count int
+	err := db.sqldb.QueryRow(fmt.Sprintf(
+		`SELECT COUNT(*) FROM %s %s;`, db.matches.Name(), where),
+		args...).Scan(&count)
+	return count, err
+}
+
+func (db *osndb) SearchPlayers(prefix string, page Page) ([]osn.Player, error) {
+	limit, args := page.sql()
+	record := NewPlayerRecord()
+	// The gcid is NULL for most players, it is scanned as empty.
+	rows, err := db.sqldb.Query(fmt.Sprintf(
+		`SELECT id, COALESCE(gcid, ''), name FROM %s
+		WHERE id != 0 AND name LIKE ? ESCAPE '\'
+		ORDER BY name %s;`,
+		db.players.Name(), limit),
+		append([]any{like_prefix(prefix)}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	players := make([]osn.Player, 0)
+	for rows.Next() {
+		if err := rows.Scan(record.Scannables()...); err != nil {
+			return nil, err
+		}
+		players = append(players, record.Player)
+	}
+	return players, rows.Err()
+}
+
+// Escapes the wildcards of a LIKE pattern so the prefix is matched literally.
+func like_prefix(prefix string) string {
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return escaper.Replace(prefix) + "%"
+}
+
+func (db *osndb) MatchPlayers(matchID osn.GameID) ([]osn.PlayerRole, error) {
+	var match_id int64
+	err := db.sqldb.QueryRow(fmt.Sprintf(
+		`SELECT rowid FROM %s WHERE match_hash = ?;`, db.matches.Name()),
+		matchID).Scan(&match_id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return select_roles(db.sqldb, match_id)
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/db/search_test.go
+
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	osn "github.com/kevindamm/wits-osn"
+	"github.com/kevindamm/wits-osn/db"
+)
+
+func populate_search(t *testing.T) db.OsnDB {
+	osndb := db.OpenOsnDB(":memory:")
+	osndb.MustCreateAndPopulateTables()
+
+	for _, player := range []osn.Player{
+		{RowID: 7, GCID: "G:7", Name: "Alvendor"},
+		{RowID: 8, GCID: "G:8", Name: "Lenoxe"},
+		{RowID: 9, GCID: "G:9", Name: "Al_x"},
+	} {
+		if err := osndb.Players().Insert(&db.PlayerRecord{Player: player}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	created := time.Date(2012, 8, 5, 15, 0, 0, 0, time.UTC)
+	for i, match := range []osn.LegacyMatch{
+		{MatchHash: "first", MapID: 7, Season: 1, Competitive: true,
+			FetchStatus: osn.STATUS_VALIDATED},
+		{MatchHash: "second", MapID: 7, Season: 1, Competitive: false,
+			FetchStatus: osn.STATUS_LISTED},
+		{MatchHash: "third", MapID: 16, Season: 2, Competitive: true,
+			FetchStatus: osn.STATUS_LISTED},
+	} {
+		match.MatchIndex = int64(i + 1)
+		match.CreatedTime = created.Add(time.Duration(i) * time.Hour)
+		if err := osndb.Matches().Insert(db.MakeMatchRecord(match)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, role := range []db.PlayerRoleRecord{
+		{MatchID: 1, PlayerID: 8, TurnOrder: 1},
+		{MatchID: 1, PlayerID: 7, TurnOrder: 2},
+		{MatchID: 3, PlayerID: 7, TurnOrder: 1},
+		{MatchID: 3, PlayerID: 9, TurnOrder: 2},
+	} {
+		if err := osndb.Roles().Insert(&role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return osndb
+}
+
+func TestSearchMatches(t *testing.T) {
+	osndb := populate_search(t)
+	defer osndb.Close()
+
+	competitive := true
+	listed := osn.STATUS_LISTED
+	cases := []struct {
+		name     string
+		filter   db.MatchFilter
+		expected []osn.GameID
+	}{
+		{"all", db.MatchFilter{}, []osn.GameID{"third", "second", "first"}},
+		{"map", db.MatchFilter{MapID: 7}, []osn.GameID{"second", "first"}},
+		{"season", db.MatchFilter{Season: 2}, []osn.GameID{"third"}},
+		{"competitive", db.MatchFilter{Competitive: &competitive},
+			[]osn.GameID{"third", "first"}},
+		{"status", db.MatchFilter{Status: &listed}, []osn.GameID{"third", "second"}},
+		{"player", db.MatchFilter{PlayerID: 7}, []osn.GameID{"third", "first"}},
+		{"since", db.MatchFilter{
+			Since: time.Date(2012, 8, 5, 16, 0, 0, 0, time.UTC)},
+			[]osn.GameID{"third", "second"}},
+		{"until", db.MatchFilter{
+			Until: time.Date(2012, 8, 5, 16, 0, 0, 0, time.UTC)},
+			[]osn.GameID{"first"}},
+		{"ascending", db.MatchFilter{Ascending: true},
+			[]osn.GameID{"first", "second", "third"}},
+		{"page", db.MatchFilter{Page: db.Page{Limit: 1, Offset: 1}},
+			[]osn.GameID{"second"}},
+		{"none", db.MatchFilter{MapID: 7, Season: 2}, []osn.GameID{}},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, err := osndb.SearchMatches(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(matches) != len(tt.expected) {
+				t.Fatalf("expected %d matches, got %d: %v", len(tt.expected), len(matches), matches)
+			}
+			for i, match := range matches {
+				if match.MatchHash != tt.expected[i] {
+					t.Errorf("match %d is %s, expected %s", i, match.MatchHash, tt.expected[i])
+				}
+			}
+			count, err := osndb.CountMatches(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.filter.Limit == 0 && count != len(tt.expected) {
+				t.Errorf("counted %d matches, expected %d", count, len(tt.expected))
+			}
+		})
+	}
+}
+
+func TestSearchPlayers(t *testing.T) {
+	osndb := populate_search(t)
+	defer osndb.Close()
+
+	players, err := osndb.SearchPlayers("Al", db.Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(players) != 2 || players[0].Name != "Al_x" || players[1].Name != "Alvendor" {
+		t.Errorf("unexpected players for prefix Al: %v", players)
+	}
+	players, _ = osndb.SearchPlayers("Al_", db.Page{})
+	if len(players) != 1 || players[0].RowID != 9 {
+		t.Errorf("expected the underscore to match literally, got %v", players)
+	}
+	players, _ = osndb.SearchPlayers("", db.Page{Limit: 2})
+	if len(players) != 2 {
+		t.Errorf("expected a page of 2 players, got %v", players)
+	}
+
+	roles, err := osndb.MatchPlayers("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) != 2 ||
+		roles[0].Name != "Lenoxe" || roles[0].TurnOrder != 1 ||
+		roles[1].Name != "Alvendor" || roles[1].GCID != "G:7" {
+		t.Errorf("unexpected roles for match: %+v", roles)
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/db/status_history.go
+
+package db
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+
+	osn "github.com/kevindamm/wits-osn"
+)
+
+// A change in a match's fetch_status, with the tool (or pipeline stage) which
+// made the change and, when the match became INVALID, the error explaining why.
+type StatusChangeRecord struct {
+	MatchID   int64 // rowid of the match
+	OldStatus osn.FetchStatus
+	NewStatus osn.FetchStatus
+	Changed   time.Time
+	Source    string
+	Message   string
+}
+
+func NewStatusChangeRecord() *StatusChangeRecord {
+	return &StatusChangeRecord{}
+}
+
+func (*StatusChangeRecord) Columns() []string {
+	return []string{
+		"match_id",
+		"old_status", "new_status",
+		"changed_ts",
+		"source", "message",
+	}
+}
+
+func (record *StatusChangeRecord) Values() ([]any, error) {
+	return []any{
+			record.MatchID,
+			record.OldStatus,
+			record.NewStatus,
+			record.Changed,
+			record.Source,
+			record.Message},
+		nil
+}
+
+func (record *StatusChangeRecord) NamedValues() ([]driver.NamedValue, error) {
+	return []driver.NamedValue{
+		{
+			Name:    "match_id",
+			Ordinal: 1,
+			Value:   record.MatchID},
+		{
+			Name:    "old_status",
+			Ordinal: 2,
+			Value:   record.OldStatus},
+		{
+			Name:    "new_status",
+			Ordinal: 3,
+			Value:   record.NewStatus},
+		{
+			Name:    "changed_ts",
+			Ordinal: 4,
+			Value:   record.Changed},
+		{
+			Name:    "source",
+			Ordinal: 5,
+			Value:   record.Source},
+		{
+			Name:    "message",
+			Ordinal: 6,
+			Value:   record.Message},
+	}, nil
+}
+
+func (record *StatusChangeRecord) ScanValues(values ...driver.Value) error {
+	var ok bool
+	record.MatchID, ok = values[0].(int64)
+	if !ok {
+		return fmt.Errorf("StatusChange.MatchID value %v not int64", values[0])
+	}
+	old_status, ok := values[1].(int64)
+	if !ok {
+		return fmt.Errorf("StatusChange.OldStatus value %v not int64", values[1])
+	}
+	record.OldStatus = osn.FetchStatus(old_status)
+	new_status, ok := values[2].(int64)
+	if !ok {
+		return fmt.Errorf("StatusChange.NewStatus value %v not int64", values[2])
+	}
+	record.NewStatus = osn.FetchStatus(new_status)
+	record.Changed, ok = values[3].(time.Time)
+	if !ok {
+		return fmt.Errorf("StatusChange.Changed value %v not time.Time", values[3])
+	}
+	record.Source, ok = values[4].(string)
+	if !ok {
+		return fmt.Errorf("StatusChange.Source value %v not string", values[4])
+	}
+	record.Message, ok = values[5].(string)
+	if !ok {
+		return fmt.Errorf("StatusChange.Message value %v not string", values[5])
+	}
+	return nil
+}
+
+func (record *StatusChangeRecord) ScanRow(row *sql.Row) error {
+	return row.Scan(record.Scannables()...)
+}
+
+func (record *StatusChangeRecord) Scannables() []any {
+	return []any{
+		&record.MatchID,
+		&record.OldStatus,
+		&record.NewStatus,
+		&record.Changed,
+		&record.Source,
+		&record.Message}
+}
+
+// An append-only log of every change made to the status of each match.  The
+// first change of each match is from UNKNOWN to the status it was inserted with.
+type tableStatusHistory struct {
+	mutableBase[*StatusChangeRecord]
+}
+
+const history_table = "status_history"
+
+func MakeStatusHistoryTable(sqldb *sql.DB) MutableTable[*StatusChangeRecord] {
+	return tableStatusHistory{
+		mutableBase[*StatusChangeRecord]{tableBase[*StatusChangeRecord]{
+			sqldb: sqldb,
+			name:  history_table,
+			zero:  NewStatusChangeRecord(),
+			new:   NewStatusChangeRecord}}}
+}
+
+func (table tableStatusHistory) SqlCreate() string {
+	return fmt.Sprintf(`CREATE TABLE "%s" (
+    -- rowid INTEGER PRIMARY KEY,
+    "match_id"    INTEGER NOT NULL,
+    "old_status"  INTEGER NOT NULL,
+    "new_status"  INTEGER NOT NULL,
+    "changed_ts"  TIMESTAMP NOT NULL,  -- time of the change, UTC
+    "source"      TEXT NOT NULL,       -- tool or pipeline stage
+    "message"     TEXT NOT NULL,       -- error message, if any
+
+    FOREIGN KEY (match_id)
+      REFERENCES matches (rowid)
+      ON DELETE CASCADE ON UPDATE NO ACTION,
+    FOREIGN KEY (old_status)
+      REFERENCES fetch_status (id)
+      ON DELETE CASCADE ON UPDATE NO ACTION,
+    FOREIGN KEY (new_status)
+      REFERENCES fetch_status (id)
+      ON DELETE CASCADE ON UPDATE NO ACTION
+  );`, table.name)
+}
+
+func (table tableStatusHistory) SqlInit() string {
+	return fmt.Sprintf(
+		`CREATE INDEX status_history_matches ON %s (match_id);`,
+		table.name)
+}
+
+// Appends the change to the history, as part of the transaction which made it.
+func insert_status_change(tx *sql.Tx, change StatusChangeRecord) error {
+	values, _ := change.Values()
+	_, err := tx.Exec(fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s);`,
+		history_table, strings.Join(change.Columns(), ", "), qmarks(len(values))),
+		values...)
+	return err
+}
+
+// Records the status that a newly inserted match has, and what inserted it.
+func insert_initial_status(tx *sql.Tx, match_id int64, status osn.FetchStatus, source string) error {
+	return insert_status_change(tx, StatusChangeRecord{
+		MatchID:   match_id,
+		OldStatus: osn.STATUS_UNKNOWN,
+		NewStatus: status,
+		Changed:   time.Now().UTC(),
+		Source:    source})
+}
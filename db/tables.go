@@ -41,6 +41,14 @@ type Record interface {
 	Scannables() []any
 }
 
+// Records whose primary key is assigned by the database when it is zero.  The
+// record's Values() should have a nil key in that case, see [MutableTable].
+type KeyedRecord interface {
+	Record
+	Primary() int64
+	SetPrimary(int64)
+}
+
 // The SQL-specific features of a relational table.  Fortunately, these have
 // domains and codomains that aren't parameterized by the table's [Record] type,
 // even though the computation of CREATE, INDEX and INSERT commands
@@ -51,6 +59,13 @@ type TableSql interface {
 	SqlInit() string
 }
 
+// Tables with a fixed set of rows that are inserted using query parameters,
+// for values which are impractical to express in SqlInit() (e.g. JSON blobs).
+type SeededTable interface {
+	TableSql
+	SeedRecords() []Record
+}
+
 // Common interface for one or more database tables and retrieval of records.
 type Table[T Record] interface {
 	TableSql
@@ -147,7 +162,7 @@ func (table tableBase[T]) GetByName(name string) (T, error) {
 
 func (table tableBase[T]) SelectAll() (<-chan T, error) {
 	colnames := table.zero.Columns()
-	query := fmt.Sprintf(`SELECT (%s) FROM %s;`,
+	query := fmt.Sprintf(`SELECT %s FROM %s;`,
 		strings.Join(colnames, ", "), table.name)
 
 	stmt, err := table.sqldb.Prepare(query)
@@ -165,11 +180,11 @@ func (table tableBase[T]) SelectAll() (<-chan T, error) {
 		defer rows.Close()
 
 		for rows.Next() {
-			var value T
-			if rows.Err() != nil {
+			value := table.NewRecord()
+			if err := rows.Scan(value.Scannables()...); err != nil {
+				log.Printf("error scanning %s row: %s", table.name, err)
 				return
 			}
-			rows.Scan(value.Scannables()...)
 			channel <- value
 		}
 	}()
@@ -207,14 +222,18 @@ func (table mutableBase[T]) Insert(record T) error {
 	if err != nil {
 		return err
 	}
-	_, err = stmt.Exec(values...)
+	result, err := stmt.Exec(values...)
 	if err != nil {
 		return err
 	}
-	fmt.Println()
 
-	// TODO set the rowid value on `record`, if needed.
-	// record.SetPrimary(exec_result.LastInsertId()) or similar
+	if keyed, ok := any(record).(KeyedRecord); ok && keyed.Primary() == 0 {
+		rowid, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		keyed.SetPrimary(rowid)
+	}
 	return nil
 }
 
@@ -239,6 +258,24 @@ func (table mutableBase[T]) Delete(id int64) error {
 	return nil
 }
 
+// Insert the record into the named table or LOG(FATAL) trying.
+// Like must_execsql, this is reserved for use during database setup.
+func must_insert(db *sql.DB, tablename string, record Record) {
+	colnames := record.Columns()
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		tablename,
+		strings.Join(colnames, ", "),
+		qmarks(len(colnames)))
+	values, err := record.Values()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err = db.Exec(query, values...); err != nil {
+		log.Println("error inserting into", tablename)
+		log.Fatal(err)
+	}
+}
+
 // Execute the SQL statement on the provided database.
 //
 // Convenience check-fail for errors encountered during database setup.
@@ -0,0 +1,214 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/db/upsert.go
+
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	osn "github.com/kevindamm/wits-osn"
+)
+
+// A transaction in which many matches are upserted, see [OsnDB.BeginBatch].
+// Each upsert is atomic within the batch; when one fails none of its changes
+// are kept, but the batch can continue and be committed.
+type Batch interface {
+	UpsertMatch(match *osn.LegacyMatch, source string) error
+	Commit() error
+	Rollback() error
+}
+
+type upsert_batch struct {
+	*sql.Tx
+	db *osndb
+}
+
+func (db *osndb) BeginBatch() (Batch, error) {
+	tx, err := db.sqldb.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return upsert_batch{tx, db}, nil
+}
+
+// Upserts the match in a transaction of its own, see [upsert_batch.UpsertMatch].
+func (db *osndb) UpsertMatch(match *osn.LegacyMatch, source string) error {
+	batch, err := db.BeginBatch()
+	if err != nil {
+		return err
+	}
+	defer batch.Rollback()
+	if err := batch.UpsertMatch(match, source); err != nil {
+		return err
+	}
+	return batch.Commit()
+}
+
+// Inserts the match, or updates the match which has the same ID, along with its
+// players and their roles.  An existing match keeps its index and status, and
+// any of its metadata that the match has no value for (zero values are
+// considered missing).  The match's index and status are set to those that the
+// database has for it.  A new match's status is recorded in its status history,
+// along with the source (the tool) which inserted it.
+//
+// Players are found by their ID, or if it is zero, by their GCID and then by
+// their name.  Players that are not found are added (with the next unused ID).
+// The roles of the match are replaced if the match has any players.
+func (batch upsert_batch) UpsertMatch(match *osn.LegacyMatch, source string) error {
+	if _, err := batch.Exec(`SAVEPOINT upsert_match;`); err != nil {
+		return err
+	}
+	if err := batch.upsert_match(match, source); err != nil {
+		batch.Exec(`ROLLBACK TO upsert_match;`)
+		batch.Exec(`RELEASE upsert_match;`)
+		return err
+	}
+	_, err := batch.Exec(`RELEASE upsert_match;`)
+	return err
+}
+
+func (batch upsert_batch) upsert_match(match *osn.LegacyMatch, source string) error {
+	tx, db := batch.Tx, batch.db
+	for i := range match.Players {
+		player := &match.Players[i].Player
+		if err := upsert_player(tx, db.players.Name(), player); err != nil {
+			return fmt.Errorf("player %q: %w", player.Name, err)
+		}
+	}
+
+	existing := NewMatchRecord()
+	columns := existing.Columns()
+	err := tx.QueryRow(fmt.Sprintf(`SELECT %s FROM %s WHERE match_hash = ?;`,
+		strings.Join(columns, ", "), db.matches.Name()),
+		match.MatchHash).Scan(existing.Scannables()...)
+	if errors.Is(err, sql.ErrNoRows) {
+		record := MakeMatchRecord(*match)
+		values, _ := record.Values()
+		result, err := tx.Exec(fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s);`,
+			db.matches.Name(), strings.Join(columns, ", "), qmarks(len(values))),
+			values...)
+		if err != nil {
+			return err
+		}
+		if match.MatchIndex, err = result.LastInsertId(); err != nil {
+			return err
+		}
+		err = insert_initial_status(tx, match.MatchIndex, match.FetchStatus, source)
+		if err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	} else {
+		merged := merge_metadata(existing.LegacyMatch, *match)
+		if _, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET
+		    competitive = ?, season = ?, created_ts = ?,
+		    map_id = ?, turn_count = ?, version = ?
+		  WHERE rowid = ?;`, db.matches.Name()),
+			merged.Competitive, merged.Season, merged.CreatedTime,
+			merged.MapID, merged.TurnCount, merged.Version,
+			existing.MatchIndex); err != nil {
+			return err
+		}
+		match.MatchIndex = existing.MatchIndex
+		match.FetchStatus = existing.FetchStatus
+	}
+
+	if len(match.Players) > 0 {
+		if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE match_id = ?;`,
+			db.roles.Name()), match.MatchIndex); err != nil {
+			return err
+		}
+		return insert_roles(tx, match.MatchIndex, match.Players)
+	}
+	return nil
+}
+
+// The existing match's metadata, with any missing values taken from the update.
+func merge_metadata(existing, update osn.LegacyMatch) osn.LegacyMatch {
+	merged := existing
+	merged.Competitive = existing.Competitive || update.Competitive
+	if existing.Season == 0 {
+		merged.Season = update.Season
+	}
+	if existing.CreatedTime.IsZero() {
+		merged.CreatedTime = update.CreatedTime
+	}
+	if existing.MapID == 0 {
+		merged.MapID = update.MapID
+	}
+	if existing.TurnCount == 0 {
+		merged.TurnCount = update.TurnCount
+	}
+	if existing.Version == 0 {
+		merged.Version = update.Version
+	}
+	return merged
+}
+
+// Finds (or adds) the player, setting its ID.  Unnamed players without an ID or
+// GCID are the UNKNOWN player (whose ID is zero).
+func upsert_player(tx *sql.Tx, table string, player *osn.Player) error {
+	if player.RowID == 0 {
+		if player.GCID == "" && player.Name == "" {
+			return nil
+		}
+		var err error
+		if player.RowID, err = find_player(tx, table, *player); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(fmt.Sprintf(`INSERT INTO %s (id, gcid, name) VALUES (?, ?, ?)
+	  ON CONFLICT (id) DO UPDATE SET
+	    gcid = COALESCE(excluded.gcid, gcid),
+	    name = CASE excluded.name WHEN '' THEN name ELSE excluded.name END;`, table),
+		player.RowID, null_if_empty(player.GCID), player.Name)
+	return err
+}
+
+// The ID of the player with the same GCID or else with the same name, or the
+// next unused ID if there is no such player.
+func find_player(tx *sql.Tx, table string, player osn.Player) (int64, error) {
+	var id int64
+	queries := []struct {
+		where string
+		value string
+	}{{"gcid", player.GCID}, {"name", player.Name}}
+	for _, query := range queries {
+		if query.value == "" {
+			continue
+		}
+		err := tx.QueryRow(fmt.Sprintf(`SELECT id FROM %s WHERE %s = ?;`,
+			table, query.where), query.value).Scan(&id)
+		if err == nil {
+			return id, nil
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+	}
+	err := tx.QueryRow(fmt.Sprintf(`SELECT COALESCE(MAX(id), 0) + 1 FROM %s;`,
+		table)).Scan(&id)
+	return id, err
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/db/upsert_test.go
+
+package db_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	osn "github.com/kevindamm/wits-osn"
+	"github.com/kevindamm/wits-osn/db"
+)
+
+func TestUpsertMatch(t *testing.T) {
+	osndb := db.OpenOsnDB(":memory:")
+	osndb.MustCreateAndPopulateTables()
+
+	// A listed match, and one of its players, as the index would have them.
+	if err := osndb.Players().Insert(&db.PlayerRecord{Player: osn.Player{
+		RowID: 12, Name: "KevinDamm"}}); err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2013, 5, 1, 12, 0, 0, 0, time.UTC)
+	listed := osn.LegacyMatch{MatchIndex: 40, MatchHash: "listed", Season: 3,
+		CreatedTime: created, MapID: 16, TurnCount: 15, Version: 1063,
+		FetchStatus: osn.STATUS_LISTED}
+	if err := osndb.Matches().Insert(db.MakeMatchRecord(listed)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same match as found in its replay, without most of its metadata.
+	replay := osn.LegacyMatch{MatchHash: "listed", Competitive: true, MapID: 16,
+		TurnCount: 14, FetchStatus: osn.STATUS_FETCHED,
+		Players: []osn.PlayerRole{
+			{Player: osn.Player{GCID: "G:161628063", Name: "DarthMickeyJJ"}, TurnOrder: osn.PlayerColorEnum(1)},
+			{Player: osn.Player{GCID: "102573135124346212370", Name: "KevinDamm"}, TurnOrder: osn.PlayerColorEnum(2)},
+		}}
+	if err := osndb.UpsertMatch(&replay, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if replay.MatchIndex != 40 || replay.FetchStatus != osn.STATUS_LISTED {
+		t.Errorf("expected the existing index and status, got %d %s",
+			replay.MatchIndex, replay.FetchStatus)
+	}
+	record, _ := osndb.Matches().GetByName("listed")
+	if !record.Competitive || record.Season != 3 || record.TurnCount != 15 ||
+		!record.CreatedTime.Equal(created) || record.Version != 1063 {
+		t.Errorf("unexpected merged metadata %+v", record.LegacyMatch)
+	}
+
+	players, err := osndb.MatchPlayers("listed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(players_of(players)) != "[13 DarthMickeyJJ G:161628063 12 KevinDamm 102573135124346212370]" {
+		t.Errorf("unexpected players %v", players_of(players))
+	}
+
+	// A new match, with players that have no GCID (they do not collide) and
+	// a player that is already known.  Upserting it again changes nothing.
+	unlisted := osn.LegacyMatch{MatchHash: "unlisted", MapID: 7,
+		FetchStatus: osn.STATUS_UNWRAPPED,
+		Players: []osn.PlayerRole{
+			{Player: osn.Player{Name: "Alvendor"}},
+			{Player: osn.Player{Name: "Lenoxe"}},
+			{Player: osn.Player{GCID: "G:161628063", Name: "DarthMickeyJJ"}},
+			{Player: osn.Player{Name: "Syvan"}},
+		}}
+	for range 2 {
+		again := unlisted
+		again.Players = append([]osn.PlayerRole{}, unlisted.Players...)
+		if err := osndb.UpsertMatch(&again, "test"); err != nil {
+			t.Fatal(err)
+		}
+		if again.MatchIndex != 41 || again.FetchStatus != osn.STATUS_UNWRAPPED {
+			t.Errorf("unexpected index and status %d %s", again.MatchIndex, again.FetchStatus)
+		}
+	}
+	players, _ = osndb.MatchPlayers("unlisted")
+	if fmt.Sprint(players_of(players)) != "[14 Alvendor  15 Lenoxe  13 DarthMickeyJJ G:161628063 16 Syvan ]" {
+		t.Errorf("unexpected players %v", players_of(players))
+	}
+	player, err := osndb.Players().Get(14)
+	if err != nil || player.GCID != "" || player.Name != "Alvendor" {
+		t.Errorf("unexpected player %+v (%v)", player, err)
+	}
+
+	// The status each match was inserted with is its first (and only) change.
+	for matchID, expected := range map[osn.GameID]db.StatusChangeRecord{
+		"listed":   {OldStatus: osn.STATUS_UNKNOWN, NewStatus: osn.STATUS_LISTED, Source: "insert"},
+		"unlisted": {OldStatus: osn.STATUS_UNKNOWN, NewStatus: osn.STATUS_UNWRAPPED, Source: "test"},
+	} {
+		history, err := osndb.MatchStatusHistory(matchID)
+		if err != nil || len(history) != 1 || history[0].OldStatus != expected.OldStatus ||
+			history[0].NewStatus != expected.NewStatus || history[0].Source != expected.Source ||
+			history[0].Changed.IsZero() {
+			t.Errorf("unexpected history for %s: %+v (%v)", matchID, history, err)
+		}
+	}
+}
+
+func TestUpsertBatch(t *testing.T) {
+	osndb := db.OpenOsnDB(":memory:")
+	osndb.MustCreateAndPopulateTables()
+
+	batch, err := osndb.BeginBatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	matches := []osn.LegacyMatch{
+		{MatchHash: "first", MapID: 16, Players: []osn.PlayerRole{
+			{Player: osn.Player{RowID: 12, Name: "KevinDamm"}}}},
+		// Another player with the same name, the whole match is undone.
+		{MatchHash: "renamed", MapID: 16, Players: []osn.PlayerRole{
+			{Player: osn.Player{RowID: 20, Name: "Lenoxe"}},
+			{Player: osn.Player{RowID: 21, Name: "KevinDamm"}}}},
+		{MatchHash: "second", MapID: 7, Players: []osn.PlayerRole{
+			{Player: osn.Player{RowID: 12, Name: "KevinDamm"}}}},
+	}
+	for i := range matches {
+		err := batch.UpsertMatch(&matches[i], "test")
+		if (err != nil) != (matches[i].MatchHash == "renamed") {
+			t.Errorf("unexpected result upserting %s: %v", matches[i].MatchHash, err)
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := osndb.CountMatches(db.MatchFilter{})
+	if err != nil || count != 2 {
+		t.Errorf("expected 2 matches, found %d (%v)", count, err)
+	}
+	if _, err := osndb.Players().Get(20); err == nil {
+		t.Error("found a player of the failed upsert")
+	}
+	players, _ := osndb.MatchPlayers("second")
+	if fmt.Sprint(players_of(players)) != "[12 KevinDamm ]" {
+		t.Errorf("unexpected players %v", players_of(players))
+	}
+
+	// Nothing in a rolled back batch is kept.
+	batch, err = osndb.BeginBatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.UpsertMatch(&osn.LegacyMatch{MatchHash: "third", MapID: 7}, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := osndb.CountMatches(db.MatchFilter{}); count != 2 {
+		t.Errorf("expected 2 matches after rollback, found %d", count)
+	}
+}
+
+func players_of(roles []osn.PlayerRole) []any {
+	fields := make([]any, 0)
+	for _, role := range roles {
+		fields = append(fields, role.RowID, role.Name, role.GCID)
+	}
+	return fields
+}
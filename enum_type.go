@@ -42,10 +42,13 @@ type EnumType interface {
 }
 
 // Convenience method for listing the enum values from UNKNOWN (0) to its LIMIT.
+// Any values in that range which are not valid (unused) are skipped.
 func EnumValuesFor[T EnumType](limit T) []T {
-	values := make([]T, limit)
+	values := make([]T, 0, limit)
 	for i := range limit {
-		values[i] = T(i)
+		if T(i).IsValid() {
+			values = append(values, T(i))
+		}
 	}
 	return values
 }
@@ -22,6 +22,11 @@
 
 package osn
 
+import (
+	"fmt"
+	"strings"
+)
+
 // There is a finite, unchanging set of status values
 // based on the progress of processing a sing replay
 // from reading its listing through transforming its
@@ -65,3 +70,55 @@ func (status FetchStatus) String() string {
 	}
 	return status_names[status]
 }
+
+// The status with the name (in any case), e.g. for parsing command-line flags.
+func ParseFetchStatus(name string) (FetchStatus, error) {
+	for status := range FetchStatusRange {
+		if strings.EqualFold(name, status.String()) {
+			return status, nil
+		}
+	}
+	return STATUS_UNKNOWN, fmt.Errorf("unknown status %s", name)
+}
+
+// The status that a match advances to when the next stage of processing it
+// succeeds, or STATUS_UNKNOWN if there are no further stages.  Legacy matches,
+// from the backfilled index, may still have their replay fetched.
+func (status FetchStatus) Next() FetchStatus {
+	switch status {
+	case STATUS_UNKNOWN:
+		return STATUS_LISTED
+	case STATUS_LEGACY:
+		return STATUS_FETCHED
+	case STATUS_INDEXED, STATUS_INVALID:
+		return STATUS_UNKNOWN
+	}
+	if !status.IsValid() {
+		return STATUS_UNKNOWN
+	}
+	return status + 1
+}
+
+// True if a match with this status may be moved to the next status.  Besides
+// advancing to the following stage, any match may become INVALID, an unknown
+// match may be backfilled as LEGACY and an INVALID match may be listed again.
+// Matches processed beyond FETCHED (or found INVALID) may return to FETCHED,
+// to be processed again from their archived replay.
+func (status FetchStatus) CanBecome(next FetchStatus) bool {
+	if !status.IsValid() || !next.IsValid() || next == STATUS_UNKNOWN {
+		return false
+	}
+	switch {
+	case next == status.Next():
+		return true
+	case next == STATUS_INVALID:
+		return status != STATUS_INVALID
+	case next == STATUS_LEGACY:
+		return status == STATUS_UNKNOWN
+	case next == STATUS_LISTED:
+		return status == STATUS_INVALID
+	case next == STATUS_FETCHED:
+		return status > STATUS_FETCHED && status != STATUS_LEGACY
+	}
+	return false
+}
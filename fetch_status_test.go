@@ -0,0 +1,97 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/fetch_status_test.go
+
+package osn_test
+
+import (
+	"testing"
+
+	osn "github.com/kevindamm/wits-osn"
+)
+
+func TestFetchStatusTransitions(t *testing.T) {
+	pipeline := []osn.FetchStatus{
+		osn.STATUS_UNKNOWN,
+		osn.STATUS_LISTED,
+		osn.STATUS_FETCHED,
+		osn.STATUS_UNWRAPPED,
+		osn.STATUS_CONVERTED,
+		osn.STATUS_CANONICAL,
+		osn.STATUS_VALIDATED,
+		osn.STATUS_INDEXED,
+	}
+	for i, status := range pipeline[:len(pipeline)-1] {
+		next := pipeline[i+1]
+		if status.Next() != next || !status.CanBecome(next) {
+			t.Errorf("expected %s to become %s", status, next)
+		}
+		if status.CanBecome(osn.STATUS_INDEXED) != (next == osn.STATUS_INDEXED) {
+			t.Errorf("%s should not skip to INDEXED", status)
+		}
+		if !status.CanBecome(osn.STATUS_INVALID) {
+			t.Errorf("%s should be able to become INVALID", status)
+		}
+	}
+
+	tests := []struct {
+		from, to osn.FetchStatus
+		legal    bool
+	}{
+		{osn.STATUS_INDEXED, osn.STATUS_UNKNOWN, false},
+		{osn.STATUS_VALIDATED, osn.STATUS_CANONICAL, false},
+		{osn.STATUS_UNKNOWN, osn.STATUS_LEGACY, true},
+		{osn.STATUS_LEGACY, osn.STATUS_FETCHED, true},
+		{osn.STATUS_LISTED, osn.STATUS_LEGACY, false},
+		{osn.STATUS_INVALID, osn.STATUS_INVALID, false},
+		{osn.STATUS_INVALID, osn.STATUS_LISTED, true},
+		{osn.STATUS_INVALID, osn.STATUS_VALIDATED, false},
+		{osn.STATUS_FETCHED, osn.FetchStatusRange, false},
+		{osn.STATUS_CONVERTED, osn.STATUS_FETCHED, true},
+		{osn.STATUS_INVALID, osn.STATUS_FETCHED, true},
+		{osn.STATUS_LISTED, osn.STATUS_FETCHED, true},
+		{osn.STATUS_FETCHED, osn.STATUS_FETCHED, false},
+	}
+	for _, tt := range tests {
+		if tt.from.CanBecome(tt.to) != tt.legal {
+			t.Errorf("%s -> %s legal should be %v", tt.from, tt.to, tt.legal)
+		}
+	}
+	if osn.STATUS_INDEXED.Next() != osn.STATUS_UNKNOWN {
+		t.Error("expected no status after INDEXED")
+	}
+}
+
+func TestParseFetchStatus(t *testing.T) {
+	for _, name := range []string{"FETCHED", "fetched", "Fetched"} {
+		if status, err := osn.ParseFetchStatus(name); err != nil || status != osn.STATUS_FETCHED {
+			t.Errorf("parsing %s got %s (%v)", name, status, err)
+		}
+	}
+	for status := range osn.FetchStatusRange {
+		if parsed, err := osn.ParseFetchStatus(status.String()); err != nil || parsed != status {
+			t.Errorf("parsing %s got %s (%v)", status, parsed, err)
+		}
+	}
+	if _, err := osn.ParseFetchStatus("DOWNLOADED"); err == nil {
+		t.Error("expected an error for an unknown status")
+	}
+}
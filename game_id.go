@@ -52,3 +52,13 @@ func (id *GameID) UnmarshalJSON(encoded []byte) error {
 	*id = GameID(gameid)
 	return nil
 }
+
+// Restores the common prefix to a short ID, as returned by [GameID.ShortID].
+// This is the inverse of ShortID for games that share the common prefix, some
+// of the earliest games do not and their short ID is the same as their ID.
+func ExpandShortID(short string) GameID {
+	if strings.HasPrefix(short, commonprefix) {
+		return GameID(short)
+	}
+	return GameID(commonprefix + short)
+}
@@ -22,6 +22,11 @@
 
 package osn
 
+import "math"
+
+// The position of a hex in OSN's offset-column ("odd-q") layout, where each
+// column is vertically aligned and odd columns are shifted down by half a hex.
+// The column is the `I` and the row is the `J` of positions in replays and maps.
 type HexCoord struct {
 	Column int `json:"positionI"`
 	Row    int `json:"positionJ"`
@@ -30,3 +35,236 @@ type HexCoord struct {
 func (coord HexCoord) AsVector() []int {
 	return []int{coord.Column, coord.Row}
 }
+
+// Cube coordinates for the same hex grid, where Q + R + S == 0.
+// Most of the geometry is simpler (and symmetric) in this representation.
+type CubeCoord struct {
+	Q, R, S int
+}
+
+// Axial coordinates are cube coordinates with the (redundant) S omitted.
+type AxialCoord struct {
+	Q, R int
+}
+
+func (coord HexCoord) Cube() CubeCoord {
+	q := coord.Column
+	r := coord.Row - (coord.Column-(coord.Column&1))/2
+	return CubeCoord{q, r, -q - r}
+}
+
+func (cube CubeCoord) Offset() HexCoord {
+	return HexCoord{cube.Q, cube.R + (cube.Q-(cube.Q&1))/2}
+}
+
+func (coord HexCoord) Axial() AxialCoord {
+	cube := coord.Cube()
+	return AxialCoord{cube.Q, cube.R}
+}
+
+func (axial AxialCoord) Cube() CubeCoord {
+	return CubeCoord{axial.Q, axial.R, -axial.Q - axial.R}
+}
+
+func (axial AxialCoord) Offset() HexCoord {
+	return axial.Cube().Offset()
+}
+
+func (cube CubeCoord) Add(other CubeCoord) CubeCoord {
+	return CubeCoord{cube.Q + other.Q, cube.R + other.R, cube.S + other.S}
+}
+
+func (cube CubeCoord) Sub(other CubeCoord) CubeCoord {
+	return CubeCoord{cube.Q - other.Q, cube.R - other.R, cube.S - other.S}
+}
+
+func (cube CubeCoord) Scale(factor int) CubeCoord {
+	return CubeCoord{cube.Q * factor, cube.R * factor, cube.S * factor}
+}
+
+// The number of steps between the two hexes, ignoring any obstacles.
+func (cube CubeCoord) Distance(other CubeCoord) int {
+	diff := cube.Sub(other)
+	return max(abs(diff.Q), abs(diff.R), abs(diff.S))
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+// Directions are numbered clockwise from the upper-right neighbor.
+type HexDirection uint8
+
+const (
+	DIR_UPPER_RIGHT HexDirection = iota
+	DIR_LOWER_RIGHT
+	DIR_DOWN
+	DIR_LOWER_LEFT
+	DIR_UPPER_LEFT
+	DIR_UP
+	HexDirectionRange
+)
+
+var cube_directions = []CubeCoord{
+	{+1, -1, 0},
+	{+1, 0, -1},
+	{0, +1, -1},
+	{-1, +1, 0},
+	{-1, 0, +1},
+	{0, -1, +1},
+}
+
+func (cube CubeCoord) Neighbor(direction HexDirection) CubeCoord {
+	return cube.Add(cube_directions[direction%HexDirectionRange])
+}
+
+func (coord HexCoord) Neighbor(direction HexDirection) HexCoord {
+	return coord.Cube().Neighbor(direction).Offset()
+}
+
+// All six neighbors, in direction order.  Some may be outside the map bounds,
+// see [LegacyMapDetails.Neighbors] for the neighbors that are on the map.
+func (coord HexCoord) Neighbors() []HexCoord {
+	neighbors := make([]HexCoord, HexDirectionRange)
+	for dir := range HexDirectionRange {
+		neighbors[dir] = coord.Neighbor(dir)
+	}
+	return neighbors
+}
+
+func (coord HexCoord) Distance(other HexCoord) int {
+	return coord.Cube().Distance(other.Cube())
+}
+
+// The hexes at exactly the indicated distance, clockwise from the upper-left.
+// A radius of zero is the hex itself.
+func (coord HexCoord) Ring(radius int) []HexCoord {
+	if radius <= 0 {
+		return []HexCoord{coord}
+	}
+	ring := make([]HexCoord, 0, 6*radius)
+	cube := coord.Cube().Add(cube_directions[DIR_UPPER_LEFT].Scale(radius))
+	for dir := range HexDirectionRange {
+		for range radius {
+			ring = append(ring, cube.Offset())
+			cube = cube.Neighbor(dir)
+		}
+	}
+	return ring
+}
+
+// All hexes within the indicated distance (inclusive), nearest rings first.
+func (coord HexCoord) Range(radius int) []HexCoord {
+	hexes := make([]HexCoord, 0, 3*radius*(radius+1)+1)
+	for r := 0; r <= radius; r++ {
+		hexes = append(hexes, coord.Ring(r)...)
+	}
+	return hexes
+}
+
+// The hexes along a straight line between the two coordinates, inclusive of
+// both endpoints.  Lines which pass exactly between two hexes are nudged
+// consistently, so the line from A to B is the reverse of the line from B to A
+// only when no such ties are encountered.
+func (coord HexCoord) LineTo(other HexCoord) []HexCoord {
+	start, end := coord.Cube(), other.Cube()
+	steps := start.Distance(end)
+	line := make([]HexCoord, 0, steps+1)
+	if steps == 0 {
+		return append(line, coord)
+	}
+
+	const epsilon = 1e-6
+	for i := 0; i <= steps; i++ {
+		t := float64(i) / float64(steps)
+		q := lerp(float64(start.Q)+epsilon, float64(end.Q)+epsilon, t)
+		r := lerp(float64(start.R)+epsilon, float64(end.R)+epsilon, t)
+		s := lerp(float64(start.S)-2*epsilon, float64(end.S)-2*epsilon, t)
+		line = append(line, cube_round(q, r, s).Offset())
+	}
+	return line
+}
+
+func lerp(a, b, t float64) float64 {
+	return a + (b-a)*t
+}
+
+func cube_round(q, r, s float64) CubeCoord {
+	rq, rr, rs := math.Round(q), math.Round(r), math.Round(s)
+	dq, dr, ds := math.Abs(rq-q), math.Abs(rr-r), math.Abs(rs-s)
+	if dq > dr && dq > ds {
+		rq = -rr - rs
+	} else if dr > ds {
+		rr = -rq - rs
+	} else {
+		rs = -rq - rr
+	}
+	return CubeCoord{int(rq), int(rr), int(rs)}
+}
+
+// Rotates the coordinate around the center by 60 degree steps (clockwise for
+// positive steps, counter-clockwise for negative steps).
+func (coord HexCoord) RotateAround(center HexCoord, steps int) HexCoord {
+	origin := center.Cube()
+	vec := coord.Cube().Sub(origin)
+	steps = ((steps % 6) + 6) % 6
+	for range steps {
+		vec = CubeCoord{-vec.R, -vec.S, -vec.Q}
+	}
+	return origin.Add(vec).Offset()
+}
+
+// The axes that a coordinate may be reflected across, each passes through the
+// center of the reflection and is named for the cube coordinate held constant.
+type HexAxis uint8
+
+const (
+	AXIS_Q HexAxis = iota
+	AXIS_R
+	AXIS_S
+)
+
+// Reflects the coordinate across the indicated axis (through the center).
+func (coord HexCoord) ReflectAround(center HexCoord, axis HexAxis) HexCoord {
+	origin := center.Cube()
+	vec := coord.Cube().Sub(origin)
+	switch axis {
+	case AXIS_Q:
+		vec = CubeCoord{vec.Q, vec.S, vec.R}
+	case AXIS_R:
+		vec = CubeCoord{vec.S, vec.R, vec.Q}
+	case AXIS_S:
+		vec = CubeCoord{vec.R, vec.Q, vec.S}
+	}
+	return origin.Add(vec).Offset()
+}
+
+// True if the coordinate is within a map of the indicated dimensions.
+func (coord HexCoord) InBounds(columns, rows int) bool {
+	return coord.Column >= 0 && coord.Column < columns &&
+		coord.Row >= 0 && coord.Row < rows
+}
+
+// True if the coordinate is within this map's bounds.
+func (details LegacyMapDetails) Contains(coord HexCoord) bool {
+	return coord.InBounds(details.Width, details.Height)
+}
+
+// The neighbors of the coordinate which are within this map's bounds.
+func (details LegacyMapDetails) Neighbors(coord HexCoord) []HexCoord {
+	neighbors := make([]HexCoord, 0, HexDirectionRange)
+	for _, neighbor := range coord.Neighbors() {
+		if details.Contains(neighbor) {
+			neighbors = append(neighbors, neighbor)
+		}
+	}
+	return neighbors
+}
+
+// The position of the unit, as a hex coordinate.
+func (unit UnitStatus) Position() HexCoord {
+	return HexCoord{int(unit.PositionI), int(unit.PositionJ)}
+}
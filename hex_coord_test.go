@@ -0,0 +1,209 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/hex_coord_test.go
+
+package osn_test
+
+import (
+	"testing"
+
+	osn "github.com/kevindamm/wits-osn"
+)
+
+func TestCubeConversion(t *testing.T) {
+	for col := -3; col < 12; col++ {
+		for row := -3; row < 12; row++ {
+			coord := osn.HexCoord{Column: col, Row: row}
+			cube := coord.Cube()
+			if cube.Q+cube.R+cube.S != 0 {
+				t.Errorf("cube %v of %v does not sum to zero", cube, coord)
+			}
+			if cube.Offset() != coord {
+				t.Errorf("cube round-trip %v -> %v -> %v", coord, cube, cube.Offset())
+			}
+			if coord.Axial().Offset() != coord {
+				t.Errorf("axial round-trip %v -> %v", coord, coord.Axial())
+			}
+		}
+	}
+}
+
+func TestOddColumnNeighbors(t *testing.T) {
+	tests := []struct {
+		coord    osn.HexCoord
+		expected []osn.HexCoord
+	}{
+		{osn.HexCoord{Column: 2, Row: 2}, []osn.HexCoord{
+			{Column: 3, Row: 1}, {Column: 3, Row: 2}, {Column: 2, Row: 3},
+			{Column: 1, Row: 2}, {Column: 1, Row: 1}, {Column: 2, Row: 1}}},
+		{osn.HexCoord{Column: 3, Row: 2}, []osn.HexCoord{
+			{Column: 4, Row: 2}, {Column: 4, Row: 3}, {Column: 3, Row: 3},
+			{Column: 2, Row: 3}, {Column: 2, Row: 2}, {Column: 3, Row: 1}}},
+	}
+	for _, tt := range tests {
+		neighbors := tt.coord.Neighbors()
+		for i, neighbor := range neighbors {
+			if neighbor != tt.expected[i] {
+				t.Errorf("neighbor %d of %v = %v, expected %v",
+					i, tt.coord, neighbor, tt.expected[i])
+			}
+			if tt.coord.Distance(neighbor) != 1 {
+				t.Errorf("neighbor %v of %v not at distance 1", neighbor, tt.coord)
+			}
+		}
+	}
+}
+
+func TestDistance(t *testing.T) {
+	origin := osn.HexCoord{Column: 1, Row: 1}
+	tests := []struct {
+		other    osn.HexCoord
+		distance int
+	}{
+		{osn.HexCoord{Column: 1, Row: 1}, 0},
+		{osn.HexCoord{Column: 1, Row: 4}, 3},
+		{osn.HexCoord{Column: 4, Row: 1}, 3},
+		{osn.HexCoord{Column: 4, Row: 3}, 3},
+		{osn.HexCoord{Column: 4, Row: 4}, 4},
+		{osn.HexCoord{Column: 0, Row: 1}, 1},
+		{osn.HexCoord{Column: 0, Row: 0}, 2},
+		{osn.HexCoord{Column: 2, Row: 2}, 1},
+	}
+	for _, tt := range tests {
+		if dist := origin.Distance(tt.other); dist != tt.distance {
+			t.Errorf("distance %v to %v = %d, expected %d",
+				origin, tt.other, dist, tt.distance)
+		}
+		if origin.Distance(tt.other) != tt.other.Distance(origin) {
+			t.Errorf("distance %v to %v is not symmetric", origin, tt.other)
+		}
+	}
+}
+
+func TestRingsAndRanges(t *testing.T) {
+	center := osn.HexCoord{Column: 5, Row: 4}
+	for radius := 0; radius < 5; radius++ {
+		ring := center.Ring(radius)
+		expected := 6 * radius
+		if radius == 0 {
+			expected = 1
+		}
+		if len(ring) != expected {
+			t.Errorf("ring(%d) has %d hexes, expected %d", radius, len(ring), expected)
+		}
+		seen := make(map[osn.HexCoord]bool)
+		for _, coord := range ring {
+			if center.Distance(coord) != radius {
+				t.Errorf("ring(%d) includes %v at distance %d",
+					radius, coord, center.Distance(coord))
+			}
+			if seen[coord] {
+				t.Errorf("ring(%d) includes %v twice", radius, coord)
+			}
+			seen[coord] = true
+		}
+
+		hexes := center.Range(radius)
+		if len(hexes) != 3*radius*(radius+1)+1 {
+			t.Errorf("range(%d) has %d hexes", radius, len(hexes))
+		}
+	}
+}
+
+func TestLineTo(t *testing.T) {
+	start := osn.HexCoord{Column: 0, Row: 0}
+	end := osn.HexCoord{Column: 6, Row: 2}
+	line := start.LineTo(end)
+	if len(line) != start.Distance(end)+1 {
+		t.Fatalf("line has %d hexes, expected %d", len(line), start.Distance(end)+1)
+	}
+	if line[0] != start || line[len(line)-1] != end {
+		t.Errorf("line %v does not span %v to %v", line, start, end)
+	}
+	for i := 1; i < len(line); i++ {
+		if line[i-1].Distance(line[i]) != 1 {
+			t.Errorf("line is not contiguous between %v and %v", line[i-1], line[i])
+		}
+	}
+	if single := start.LineTo(start); len(single) != 1 || single[0] != start {
+		t.Errorf("line to self = %v", single)
+	}
+}
+
+func TestRotationsAndReflections(t *testing.T) {
+	center := osn.HexCoord{Column: 4, Row: 4}
+	coord := osn.HexCoord{Column: 6, Row: 3}
+	rotated := coord
+	for i := 1; i <= 6; i++ {
+		rotated = rotated.RotateAround(center, 1)
+		if center.Distance(rotated) != center.Distance(coord) {
+			t.Errorf("rotation %d changed distance from center", i)
+		}
+		if i < 6 && rotated == coord {
+			t.Errorf("rotation %d returned to the original", i)
+		}
+	}
+	if rotated != coord {
+		t.Errorf("six rotations %v != %v", rotated, coord)
+	}
+	if coord.RotateAround(center, -1) != coord.RotateAround(center, 5) {
+		t.Errorf("counter-clockwise rotation mismatch")
+	}
+
+	for _, axis := range []osn.HexAxis{osn.AXIS_Q, osn.AXIS_R, osn.AXIS_S} {
+		reflected := coord.ReflectAround(center, axis)
+		if center.Distance(reflected) != center.Distance(coord) {
+			t.Errorf("reflection across %d changed distance from center", axis)
+		}
+		if reflected.ReflectAround(center, axis) != coord {
+			t.Errorf("reflection across %d is not an involution", axis)
+		}
+	}
+}
+
+func TestMapNeighborsInBounds(t *testing.T) {
+	for _, legacymap := range osn.Maps() {
+		details := legacymap.LegacyMapDetails
+		for col := 0; col < details.Width; col++ {
+			for row := 0; row < details.Height; row++ {
+				coord := osn.HexCoord{Column: col, Row: row}
+				neighbors := details.Neighbors(coord)
+				if len(neighbors) < 2 || len(neighbors) > 6 {
+					t.Errorf("%s: %v has %d neighbors",
+						legacymap.Name, coord, len(neighbors))
+				}
+				for _, neighbor := range neighbors {
+					if !details.Contains(neighbor) {
+						t.Errorf("%s: neighbor %v of %v outside %dx%d",
+							legacymap.Name, neighbor, coord, details.Width, details.Height)
+					}
+				}
+			}
+		}
+		for _, tile := range details.Init {
+			coord := osn.HexCoord{Column: tile.I, Row: tile.J}
+			if !details.Contains(coord) {
+				t.Errorf("%s: tile %v outside %dx%d",
+					legacymap.Name, coord, details.Width, details.Height)
+			}
+		}
+	}
+}
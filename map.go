@@ -63,7 +63,7 @@ type MapTileInit struct {
 	Owner PlayerIndex `json:"owner,omitempty"`
 }
 
-type MapTileType uint8
+// Sprite variation of a floor or blocked tile, zero is the theme's default.
 type SpriteIndex uint8
 type PlayerIndex uint8
 
@@ -73,7 +73,8 @@ func (details LegacyMapDetails) Value() (driver.Value, error) {
 }
 
 // Recovers the structure from a database driver using JSON deserialization.
-func (details *LegacyMapDetails) Scan(value driver.Value) error {
+// Satisfies [sql.Scanner] so that details can be scanned from a query's row.
+func (details *LegacyMapDetails) Scan(value any) error {
 	bytes, ok := value.([]byte)
 	if !ok {
 		return errors.New("invalid DB-value representation for LegacyMapDetails")
@@ -88,6 +89,3 @@ type TileState struct {
 
 	Type TileType `json:"tileType"`
 }
-
-// TODO refine this enum (or drop from ETL)
-type TileType int
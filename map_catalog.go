@@ -0,0 +1,136 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/map_catalog.go
+
+package osn
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"sync"
+)
+
+//go:embed maps/*.hxm.json
+var mapfiles embed.FS
+
+// The legacy maps and the resource (.hxm) each was defined by.  The resource
+// name is also found in the `mapName` of a replay's wire format.  Deprecated
+// maps have no resource and are included only so that their ID is recognized.
+var legacy_maps = []struct {
+	LegacyMap
+	resource string
+}{
+	{LegacyMap{MapID: 1, Name: "Machination", RoleCount: 4, Shortname: "machination"}, "fb_2v2_machination_4.hxm"},
+	{LegacyMap{MapID: 2, Name: "Foundry (v1)", RoleCount: 0, Shortname: "foundry-deprecated"}, ""},
+	{LegacyMap{MapID: 3, Name: "Foundry", RoleCount: 2, Shortname: "foundry"}, "fb_md_foundry_8.hxm"},
+	{LegacyMap{MapID: 4, Name: "Glitch", RoleCount: 2, Shortname: "glitch"}, "fb_sm_glitch_3.hxm"},
+	{LegacyMap{MapID: 5, Name: "Candy Core Mine", RoleCount: 4, Shortname: "candy-core-mine"}, "ad_2v2_candycoreMine3.hxm"},
+	{LegacyMap{MapID: 6, Name: "Sweetie Plains", RoleCount: 2, Shortname: "sweetie-plains"}, "ad_md_sweetie-plains_2.hxm"},
+	{LegacyMap{MapID: 7, Name: "Peek-a-boo", RoleCount: 2, Shortname: "peekaboo"}, "ad_sm_peekaboo_4.hxm"},
+	{LegacyMap{MapID: 8, Name: "Blitz Beach", RoleCount: 4, Shortname: "blitz-beach"}, "sw_2v2_blitz-beach_4.hxm"},
+	{LegacyMap{MapID: 9, Name: "Long Nine", RoleCount: 2, Shortname: "long-nine"}, "sw_md_long-nine_7.hxm"},
+	{LegacyMap{MapID: 10, Name: "Sharkfood Island", RoleCount: 2, Shortname: "sharkfood-island"}, "sw_sm_sharkfood-island_5.hxm"},
+	{LegacyMap{MapID: 11, Name: "Acrospire", RoleCount: 4, Shortname: "acrospire"}, "vn_2v2_acrospire_3.hxm"},
+	{LegacyMap{MapID: 12, Name: "Thorn Gulley", RoleCount: 2, Shortname: "thorn-gulley"}, "vn_md_thornGulley.hxm"},
+	{LegacyMap{MapID: 13, Name: "Reaper", RoleCount: 2, Shortname: "reaper"}, "vn_sm_reaper.hxm"},
+	{LegacyMap{MapID: 14, Name: "Skull Duggery", RoleCount: 2, Shortname: "skull-duggery"}, "sw_sm_skullduggery_1.hxm"},
+	{LegacyMap{MapID: 15, Name: "War Garden", RoleCount: 2, Shortname: "war-garden"}, "vn_sm_war-garden_1.hxm"},
+	{LegacyMap{MapID: 16, Name: "Sweet Tooth", RoleCount: 2, Shortname: "sweet-tooth"}, "ad_sm_sweet-tooth_1.hxm"},
+	{LegacyMap{MapID: 17, Name: "Sugar Rock", RoleCount: 4, Shortname: "sugar-rock"}, "ad_2v2_sugar-rock_1.hxm"},
+	{LegacyMap{MapID: 18, Name: "Mechanism", RoleCount: 4, Shortname: "mechanism"}, "fb_2v2_mechanism_1.hxm"},
+}
+
+var (
+	catalog_once sync.Once
+	catalog      []LegacyMap
+)
+
+// All legacy maps, ordered by their ID, with tile layouts (where available).
+//
+// The map layouts are embedded in the package and parsed on first use.  It is
+// a LOG(FATAL) if any of them fail to parse, because it would mean that the
+// embedded files are corrupt (or the decoder is broken).  The tile layouts are
+// shared between callers and should be treated as read-only.
+func Maps() []LegacyMap {
+	catalog_once.Do(func() {
+		catalog = make([]LegacyMap, len(legacy_maps))
+		for i, entry := range legacy_maps {
+			catalog[i] = entry.LegacyMap
+			if entry.resource == "" {
+				continue
+			}
+			details, err := read_map_details(entry.resource)
+			if err != nil {
+				log.Fatalf("map %s (%s): %s", entry.Shortname, entry.resource, err)
+			}
+			catalog[i].LegacyMapDetails = details
+		}
+	})
+
+	maps := make([]LegacyMap, len(catalog))
+	copy(maps, catalog)
+	return maps
+}
+
+func read_map_details(resource string) (LegacyMapDetails, error) {
+	var details LegacyMapDetails
+	data, err := mapfiles.ReadFile(path.Join("maps", resource+".json"))
+	if err != nil {
+		return details, err
+	}
+	err = json.Unmarshal(data, &details)
+	return details, err
+}
+
+// Finds the map with the indicated shortname (e.g. "sweet-tooth").
+func MapByShortname(shortname string) (LegacyMap, error) {
+	for _, legacymap := range Maps() {
+		if legacymap.Shortname == shortname {
+			return legacymap, nil
+		}
+	}
+	return UnknownMap(), fmt.Errorf("unrecognized map shortname %s", shortname)
+}
+
+// Finds the map defined by the indicated resource (e.g. "ad_sm_sweet-tooth_1.hxm")
+// as found in the `mapName` property of a replay's wire format.
+func MapByResource(resource string) (LegacyMap, error) {
+	for _, entry := range legacy_maps {
+		if entry.resource != "" && entry.resource == resource {
+			return MapByShortname(entry.Shortname)
+		}
+	}
+	return UnknownMap(), fmt.Errorf("unrecognized map resource %s", resource)
+}
+
+// Finds the map with the indicated display name (e.g. "Sweet Tooth") as found
+// in the `mapName` property of a replay's game state.
+func MapByName(name string) (LegacyMap, error) {
+	for _, legacymap := range Maps() {
+		if legacymap.Name == name {
+			return legacymap, nil
+		}
+	}
+	return UnknownMap(), fmt.Errorf("unrecognized map name %s", name)
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/map_catalog_test.go
+
+package osn_test
+
+import (
+	"testing"
+
+	osn "github.com/kevindamm/wits-osn"
+)
+
+func TestMapCatalog(t *testing.T) {
+	maps := osn.Maps()
+	if len(maps) != 18 {
+		t.Fatalf("expected 18 maps, found %d", len(maps))
+	}
+	for i, legacymap := range maps {
+		if int(legacymap.MapID) != i+1 {
+			t.Errorf("map %s out of order (ID %d at index %d)",
+				legacymap.Name, legacymap.MapID, i)
+		}
+		if legacymap.RoleCount == 0 {
+			// Deprecated maps have no layout.
+			continue
+		}
+		if legacymap.Width == 0 || legacymap.Height == 0 || len(legacymap.Init) == 0 {
+			t.Errorf("map %s is missing its tile layout", legacymap.Name)
+		}
+		if legacymap.Theme == 0 || legacymap.Theme >= int(osn.UnitRaceRange) {
+			t.Errorf("map %s has unexpected theme %d", legacymap.Name, legacymap.Theme)
+		}
+	}
+}
+
+func TestMapByResource(t *testing.T) {
+	legacymap, err := osn.MapByResource("ad_sm_sweet-tooth_1.hxm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legacymap.MapID != 16 || legacymap.Name != "Sweet Tooth" {
+		t.Errorf("incorrect map %d %s", legacymap.MapID, legacymap.Name)
+	}
+	if legacymap.Width != 13 || legacymap.Height != 10 {
+		t.Errorf("incorrect dimensions %d x %d", legacymap.Width, legacymap.Height)
+	}
+
+	if _, err := osn.MapByResource("xx_sm_nonexistent.hxm"); err == nil {
+		t.Error("expected an error for unrecognized resource")
+	}
+	if _, err := osn.MapByShortname("foundry-deprecated"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/map_tile.go
+
+package osn
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Enumeration of the tile types in a map's layout (`type` of .hxm tiles).
+//
+// Floor and blocked tiles may have a `sub` index selecting a sprite variation.
+// Base and spawn tiles have an `owner` (the player's turn order, from 1).
+type MapTileType uint8
+
+const (
+	MAPTILE_EMPTY   MapTileType = iota // off the playable board
+	MAPTILE_FLOOR                      // open, passable terrain
+	MAPTILE_BLOCKED                    // obstacles such as rocks or thorns
+	MAPTILE_BASE                       // a player's base
+	MAPTILE_WITS                       // a capturable wit space
+	MAPTILE_SPAWN                      // a player's spawn point
+	MapTileRange
+)
+
+func (tile MapTileType) IsValid() bool {
+	return tile < MapTileRange
+}
+
+var maptile_names = []string{
+	"Empty",
+	"Floor",
+	"Blocked",
+	"Base",
+	"Wits",
+	"Spawn",
+}
+
+func (tile MapTileType) String() string {
+	if !tile.IsValid() {
+		return MAPTILE_EMPTY.String()
+	}
+	return maptile_names[tile]
+}
+
+// Units may only stand on (and move through) floor, wit and spawn tiles.
+func (tile MapTileType) IsPassable() bool {
+	return tile == MAPTILE_FLOOR || tile == MAPTILE_WITS || tile == MAPTILE_SPAWN
+}
+
+func (tile *MapTileType) UnmarshalJSON(encoded []byte) error {
+	var value uint8
+	if err := json.Unmarshal(encoded, &value); err != nil {
+		return fmt.Errorf("map tile type [%s] is not an integer", encoded)
+	}
+	if !MapTileType(value).IsValid() {
+		return fmt.Errorf("unrecognized map tile type %d", value)
+	}
+	*tile = MapTileType(value)
+	return nil
+}
+
+// Enumeration of the ownership of wit spaces (`tileType` of captured tiles).
+//
+// Values 1 and 2 are not used by OSN, a wit space is either neutral or owned
+// by one of the two teams.
+type TileType uint8
+
+const (
+	TILE_UNKNOWN TileType = iota
+	_
+	_
+	TILE_NEUTRAL
+	TILE_TEAM1
+	TILE_TEAM2
+	TileTypeRange
+)
+
+func (tile TileType) IsValid() bool {
+	return tile == TILE_UNKNOWN ||
+		(tile >= TILE_NEUTRAL && tile < TileTypeRange)
+}
+
+var tile_names = []string{
+	"UNKNOWN",
+	"",
+	"",
+	"Neutral",
+	"Team1",
+	"Team2",
+}
+
+func (tile TileType) String() string {
+	if !tile.IsValid() {
+		return TILE_UNKNOWN.String()
+	}
+	return tile_names[tile]
+}
+
+// The tile state for a wit space captured by the indicated team (1 or 2).
+func TileCapturedBy(team uint) TileType {
+	switch team {
+	case 1:
+		return TILE_TEAM1
+	case 2:
+		return TILE_TEAM2
+	}
+	return TILE_NEUTRAL
+}
+
+// The team that has captured this tile, or 0 if it is neutral.
+func (tile TileType) Team() uint {
+	switch tile {
+	case TILE_TEAM1:
+		return 1
+	case TILE_TEAM2:
+		return 2
+	}
+	return 0
+}
+
+func (tile *TileType) UnmarshalJSON(encoded []byte) error {
+	var value uint8
+	if err := json.Unmarshal(encoded, &value); err != nil {
+		return fmt.Errorf("tile type [%s] is not an integer", encoded)
+	}
+	if !TileType(value).IsValid() {
+		return fmt.Errorf("unrecognized tile type %d", value)
+	}
+	*tile = TileType(value)
+	return nil
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/map_tile_test.go
+
+package osn_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	osn "github.com/kevindamm/wits-osn"
+)
+
+func TestDecodeMapTiles(t *testing.T) {
+	paths, err := filepath.Glob("maps/*.hxm.json")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no map files found: %v", err)
+	}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var details osn.LegacyMapDetails
+		if err := json.Unmarshal(data, &details); err != nil {
+			t.Errorf("%s: %s", path, err)
+			continue
+		}
+
+		bases := make(map[osn.PlayerIndex]int)
+		for _, tile := range details.Init {
+			if tile.Type == osn.MAPTILE_BASE {
+				bases[tile.Owner] += 1
+			}
+			if (tile.Type == osn.MAPTILE_BASE || tile.Type == osn.MAPTILE_SPAWN) &&
+				tile.Owner == 0 {
+				t.Errorf("%s: %s tile (%d, %d) has no owner",
+					path, tile.Type, tile.I, tile.J)
+			}
+		}
+		if len(bases) != 2 && len(bases) != 4 {
+			t.Errorf("%s: unexpected number of bases %v", path, bases)
+		}
+	}
+}
+
+func TestRejectUnknownTiles(t *testing.T) {
+	var tile osn.MapTileInit
+	err := json.Unmarshal([]byte(`{"i": 1, "j": 2, "type": 9}`), &tile)
+	if err == nil || !strings.Contains(err.Error(), "map tile type 9") {
+		t.Errorf("expected an error for map tile type 9, got %v", err)
+	}
+
+	var state osn.TileState
+	err = json.Unmarshal([]byte(`{"tileI": 1, "tileJ": 2, "tileType": 2}`), &state)
+	if err == nil {
+		t.Error("expected an error for tile type 2")
+	}
+	err = json.Unmarshal([]byte(`{"tileI": 1, "tileJ": 2, "tileType": 5}`), &state)
+	if err != nil || state.Type != osn.TILE_TEAM2 || state.Type.Team() != 2 {
+		t.Errorf("incorrect tile state %v (%v)", state, err)
+	}
+}
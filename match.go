@@ -50,7 +50,7 @@ type LegacyMatch struct {
 
 type GameOverData struct {
 	Competitive Boolish           `json:"isLeagueMatch"`
-	Online      Boolish           `json:"-"`
+	Online      Boolish           `json:"isOnline"`
 	Winners     []OsnPlayerUpdate `json:"winners"`
 	Losers      []OsnPlayerUpdate `json:"losers"`
 }
@@ -175,7 +175,7 @@ func (metadata *LegacyReplayMetadata) ToLegacyMatch() LegacyMatch {
 		OsnIndex:    int(index),
 		Competitive: metadata.LeagueMatch == "1",
 		Season:      int(assert_int64(metadata.Season)),
-		StartTime:   time,
+		CreatedTime: time,
 		MapID:       int(assert_int64(metadata.MapID)),
 		TurnCount:   int(assert_int64(metadata.TurnCount)),
 		Version:     int(assert_int64(metadata.OsnVersion)),
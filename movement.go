@@ -0,0 +1,195 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/movement.go
+
+package osn
+
+import (
+	"fmt"
+	"slices"
+)
+
+// The type of tile at the indicated position.  Positions on the map which are
+// not listed in the layout are open floor, positions outside it are empty.
+func (details LegacyMapDetails) TileAt(coord HexCoord) MapTileType {
+	if !details.Contains(coord) {
+		return MAPTILE_EMPTY
+	}
+	// Later entries are layered over earlier ones (e.g. rocks on a floor sprite).
+	for i := len(details.Init) - 1; i >= 0; i-- {
+		tile := details.Init[i]
+		if tile.I == coord.Column && tile.J == coord.Row {
+			return tile.Type
+		}
+	}
+	return MAPTILE_FLOOR
+}
+
+// The tile types of every position listed in the map's layout.
+func (details LegacyMapDetails) Terrain() map[HexCoord]MapTileType {
+	terrain := make(map[HexCoord]MapTileType, len(details.Init))
+	for _, tile := range details.Init {
+		terrain[HexCoord{tile.I, tile.J}] = tile.Type
+	}
+	return terrain
+}
+
+// The owner of the base covering the indicated position, if any.  A base is
+// drawn over its own tile and all six of its neighbors; units cannot stand on
+// any of these hexes but attacks against any of them will damage the base.
+func (details LegacyMapDetails) BaseAt(coord HexCoord) (PlayerIndex, bool) {
+	for _, tile := range details.Init {
+		if tile.Type == MAPTILE_BASE &&
+			coord.Distance(HexCoord{tile.I, tile.J}) <= 1 {
+			return tile.Owner, true
+		}
+	}
+	return 0, false
+}
+
+// The destinations a unit can reach within its move budget, along with the
+// shortest path to each of them.
+//
+// Units may pass through hexes occupied by units on their own team but cannot
+// end their movement there.  Hexes occupied by enemy units, blocked tiles,
+// bases (see [LegacyMapDetails.BaseAt]) and any position off of the board
+// cannot be entered at all.
+type MovementRange struct {
+	Origin HexCoord
+	Budget int
+
+	cost     map[HexCoord]int
+	prev     map[HexCoord]HexCoord
+	occupied map[HexCoord]bool
+}
+
+// Computes the movement range of `mover` among the other `units` on the map.
+// The mover itself is identified by its position and is not an obstacle.
+func NewMovementRange(legacymap LegacyMap, units []UnitStatus, mover UnitStatus, budget int) MovementRange {
+	origin := mover.Position()
+	movement := MovementRange{
+		Origin:   origin,
+		Budget:   budget,
+		cost:     map[HexCoord]int{origin: 0},
+		prev:     make(map[HexCoord]HexCoord),
+		occupied: make(map[HexCoord]bool)}
+
+	enemies := make(map[HexCoord]bool)
+	for _, unit := range units {
+		position := unit.Position()
+		if position == origin {
+			continue
+		}
+		movement.occupied[position] = true
+		if unit.Team != mover.Team {
+			enemies[position] = true
+		}
+	}
+	terrain := legacymap.Terrain()
+	passable := func(coord HexCoord) bool {
+		if !legacymap.Contains(coord) || enemies[coord] {
+			return false
+		}
+		if _, isbase := legacymap.BaseAt(coord); isbase {
+			return false
+		}
+		tile, listed := terrain[coord]
+		return !listed || tile.IsPassable()
+	}
+
+	// Every step costs the same, so a breadth-first search finds shortest paths.
+	frontier := []HexCoord{origin}
+	for len(frontier) > 0 {
+		current := frontier[0]
+		frontier = frontier[1:]
+		steps := movement.cost[current] + 1
+		if steps > budget {
+			continue
+		}
+		for _, next := range current.Neighbors() {
+			if _, visited := movement.cost[next]; visited || !passable(next) {
+				continue
+			}
+			movement.cost[next] = steps
+			movement.prev[next] = current
+			frontier = append(frontier, next)
+		}
+	}
+	return movement
+}
+
+// Computes the movement range using the mover's own move range as its budget.
+func MovementRangeFor(legacymap LegacyMap, units []UnitStatus, mover UnitStatus) (MovementRange, error) {
+	stats, err := mover.Stats()
+	if err != nil {
+		return MovementRange{}, err
+	}
+	return NewMovementRange(legacymap, units, mover, int(stats.MoveRange)), nil
+}
+
+// True if the unit can end its movement at `dest`.  Staying in place is not
+// considered a move.
+func (movement MovementRange) CanReach(dest HexCoord) bool {
+	_, reachable := movement.cost[dest]
+	return reachable && dest != movement.Origin && !movement.occupied[dest]
+}
+
+// The number of steps needed to reach `dest`, or an error if it can't be reached.
+func (movement MovementRange) Cost(dest HexCoord) (int, error) {
+	if !movement.CanReach(dest) {
+		return 0, fmt.Errorf("hex %v unreachable from %v within %d steps",
+			dest, movement.Origin, movement.Budget)
+	}
+	return movement.cost[dest], nil
+}
+
+// All of the legal destinations, nearest first and in column-major order
+// among those at the same distance.
+func (movement MovementRange) Reachable() []HexCoord {
+	reachable := make([]HexCoord, 0, len(movement.cost))
+	for coord := range movement.cost {
+		if movement.CanReach(coord) {
+			reachable = append(reachable, coord)
+		}
+	}
+	slices.SortFunc(reachable, func(a, b HexCoord) int {
+		if diff := movement.cost[a] - movement.cost[b]; diff != 0 {
+			return diff
+		}
+		return compare_coords(a, b)
+	})
+	return reachable
+}
+
+// A shortest path from the origin to `dest`, including both endpoints.
+// Returns nil if the destination cannot be reached.
+func (movement MovementRange) PathTo(dest HexCoord) []HexCoord {
+	if !movement.CanReach(dest) {
+		return nil
+	}
+	path := []HexCoord{dest}
+	for current := dest; current != movement.Origin; {
+		current = movement.prev[current]
+		path = append(path, current)
+	}
+	slices.Reverse(path)
+	return path
+}
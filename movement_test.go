@@ -0,0 +1,255 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/movement_test.go
+
+package osn_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	osn "github.com/kevindamm/wits-osn"
+)
+
+// A small open map with a wall of rocks across the middle, open at the bottom.
+func walled_map() osn.LegacyMap {
+	return osn.LegacyMap{
+		Name: "walled",
+		LegacyMapDetails: osn.LegacyMapDetails{
+			Width:  5,
+			Height: 5,
+			Init: []osn.MapTileInit{
+				{I: 2, J: 0, Type: osn.MAPTILE_BLOCKED},
+				{I: 2, J: 1, Type: osn.MAPTILE_BLOCKED},
+				{I: 2, J: 2, Type: osn.MAPTILE_BLOCKED},
+				{I: 2, J: 3, Type: osn.MAPTILE_BLOCKED},
+				{I: 4, J: 4, Type: osn.MAPTILE_EMPTY},
+			}}}
+}
+
+func unit_at(col, row int, team uint) osn.UnitStatus {
+	return osn.UnitStatus{
+		Class:     osn.CLASS_SOLDIER,
+		UnitRace:  osn.RACE_FEEDBACK,
+		Team:      team,
+		PositionI: uint(col),
+		PositionJ: uint(row)}
+}
+
+func TestMovementAroundObstacles(t *testing.T) {
+	legacymap := walled_map()
+	mover := unit_at(1, 1, 1)
+	movement := osn.NewMovementRange(legacymap, nil, mover, 4)
+
+	for _, blocked := range []osn.HexCoord{{Column: 2, Row: 1}, {Column: 4, Row: 4}, {Column: -1, Row: 0}} {
+		if movement.CanReach(blocked) {
+			t.Errorf("can reach blocked hex %v", blocked)
+		}
+	}
+	if movement.CanReach(mover.Position()) {
+		t.Error("staying in place counted as reachable")
+	}
+
+	// Crossing the wall requires going around it through row 4.
+	dest := osn.HexCoord{Column: 3, Row: 3}
+	cost, err := movement.Cost(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost != 4 {
+		t.Errorf("cost to %v = %d, expected 4", dest, cost)
+	}
+	path := movement.PathTo(dest)
+	if len(path) != 5 || path[0] != mover.Position() || path[4] != dest {
+		t.Fatalf("unexpected path %v", path)
+	}
+	for i := 1; i < len(path); i++ {
+		if path[i-1].Distance(path[i]) != 1 {
+			t.Errorf("path %v is not contiguous", path)
+		}
+		if legacymap.TileAt(path[i]) != osn.MAPTILE_FLOOR {
+			t.Errorf("path %v crosses %s", path, legacymap.TileAt(path[i]))
+		}
+	}
+
+	short := osn.NewMovementRange(legacymap, nil, mover, 3)
+	if short.CanReach(dest) {
+		t.Errorf("reached %v with insufficient budget", dest)
+	}
+	for _, coord := range short.Reachable() {
+		if cost, _ := short.Cost(coord); cost > 3 {
+			t.Errorf("reachable %v exceeds budget at cost %d", coord, cost)
+		}
+	}
+}
+
+func TestMovementBlockedByUnits(t *testing.T) {
+	legacymap := walled_map()
+	mover := unit_at(1, 3, 1)
+	ally := unit_at(1, 4, 1)
+	enemy := unit_at(2, 4, 2)
+
+	// The only way past the wall is through (1, 4) and then (2, 4).
+	movement := osn.NewMovementRange(legacymap,
+		[]osn.UnitStatus{mover, ally}, mover, 3)
+	if movement.CanReach(ally.Position()) {
+		t.Errorf("can end movement on an ally at %v", ally.Position())
+	}
+	if !movement.CanReach(osn.HexCoord{Column: 3, Row: 4}) {
+		t.Error("could not pass through an ally")
+	}
+
+	movement = osn.NewMovementRange(legacymap,
+		[]osn.UnitStatus{mover, ally, enemy}, mover, 3)
+	if movement.CanReach(osn.HexCoord{Column: 3, Row: 4}) {
+		t.Error("passed through an enemy")
+	}
+	if movement.CanReach(enemy.Position()) {
+		t.Error("can end movement on an enemy")
+	}
+}
+
+// Replays the sample match's actions over each turn's initial units, calling
+// `check` with the units as they are just before each action.  This is only
+// as much of the rules as the board tests need (positions, deaths and spawns).
+func walk_sample_replay(t *testing.T, check func(turn int, legacymap osn.LegacyMap, units []osn.UnitStatus, action osn.OsnPlayerAction)) {
+	var match osn.LegacyMatchWithReplay
+	if err := json.Unmarshal(read_test_replay(t), &match); err != nil {
+		t.Fatal(err)
+	}
+	legacymap, err := osn.MapByName(match.MapName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, turn := range match.Replay {
+		units := append([]osn.UnitStatus{}, turn.State.Units...)
+		find := func(pawnID int) int {
+			for j, unit := range units {
+				if unit.Identifier == uint(pawnID) {
+					return j
+				}
+			}
+			return -1
+		}
+		at := func(coord osn.HexCoord) int {
+			for j, unit := range units {
+				if unit.Position() == coord {
+					return j
+				}
+			}
+			return -1
+		}
+		nextPawn := uint(turn.State.CurrentPawnID)
+		var spawn osn.HexCoord
+		var eaten *osn.UnitStatus
+
+		for _, action := range turn.Actions {
+			check(i, legacymap, units, action)
+
+			switch action := action.(type) {
+			case *osn.MoveUnitAction:
+				if index := find(action.PawnID); index >= 0 {
+					units[index].PositionI = uint(action.DestI)
+					units[index].PositionJ = uint(action.DestJ)
+				}
+			case *osn.RangeAttackAction:
+				// Units destroyed by an attack no longer block movement.
+				attacker := find(action.PawnID)
+				target := at(osn.HexCoord{Column: action.DestI, Row: action.DestJ})
+				if attacker < 0 || target < 0 {
+					continue
+				}
+				stats, _ := units[attacker].Stats()
+				if units[target].Health <= stats.Damage {
+					units = append(units[:target], units[target+1:]...)
+				} else {
+					units[target].Health -= stats.Damage
+				}
+			case *osn.EatAction:
+				if target := at(osn.HexCoord{Column: action.DestI, Row: action.DestJ}); target >= 0 {
+					eaten = &osn.UnitStatus{}
+					*eaten = units[target]
+					units = append(units[:target], units[target+1:]...)
+				}
+			case *osn.SpitAction:
+				if eaten != nil {
+					eaten.PositionI, eaten.PositionJ = uint(action.DestI), uint(action.DestJ)
+					units = append(units, *eaten)
+					eaten = nil
+				}
+			case *osn.SelectSpawnTileAction:
+				spawn = osn.HexCoord{Column: action.SpawnX, Row: action.SpawnY}
+			case *osn.SpawnUnitAction:
+				unit := osn.UnitStatus{
+					Class:      action.Role,
+					Color:      action.Color,
+					Identifier: nextPawn,
+					PositionI:  uint(spawn.Column),
+					PositionJ:  uint(spawn.Row)}
+				for _, other := range units {
+					if other.Color == action.Color {
+						unit.Owner, unit.Team, unit.UnitRace = other.Owner, other.Team, other.UnitRace
+					}
+				}
+				stats, _ := unit.Stats()
+				unit.Health = stats.Health
+				units = append(units, unit)
+				nextPawn++
+			}
+		}
+	}
+}
+
+// Every move in the sample replay should be legal according to the
+// movement range of the unit at the time it was moved.
+func TestReplayMovesAreLegal(t *testing.T) {
+	moves := 0
+	walk_sample_replay(t, func(turn int, legacymap osn.LegacyMap, units []osn.UnitStatus, action osn.OsnPlayerAction) {
+		move, ok := action.(*osn.MoveUnitAction)
+		if !ok {
+			return
+		}
+		var mover *osn.UnitStatus
+		for i := range units {
+			if units[i].Identifier == uint(move.PawnID) {
+				mover = &units[i]
+			}
+		}
+		if mover == nil {
+			t.Errorf("turn %d: moved unknown unit %d", turn, move.PawnID)
+			return
+		}
+		dest := osn.HexCoord{Column: move.DestI, Row: move.DestJ}
+		movement, err := osn.MovementRangeFor(legacymap, units, *mover)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !movement.CanReach(dest) {
+			t.Errorf("turn %d: unit %d cannot reach %v from %v",
+				turn, move.PawnID, dest, mover.Position())
+		}
+		moves++
+	})
+	if moves == 0 {
+		t.Error("no moves checked in the sample replay")
+	}
+}
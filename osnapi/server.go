@@ -0,0 +1,249 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/osnapi/server.go
+
+// Package osnapi re-implements the endpoints of OSN that the fetcher uses, the
+// listing of recent replays and the retrieval of a single replay, serving them
+// from the local archive.  Tools written against OSN can be pointed at it.
+package osnapi
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	osn "github.com/kevindamm/wits-osn"
+	"github.com/kevindamm/wits-osn/db"
+	"github.com/kevindamm/wits-osn/pipeline"
+)
+
+// The paths of the endpoints, relative to the site's base URL.
+const (
+	ListingPath = "/replays/getReplays/"
+	ReplayPath  = "/api/getReplay/"
+)
+
+// The response to a listing of replays.  The total is only included when the
+// request did not set ret_total=false.  The timestamp is when it was served.
+type ReplayListing struct {
+	Total   string                     `json:"total,omitempty"`
+	Replays []osn.LegacyReplayMetadata `json:"replays"`
+	When    string                     `json:"ts"`
+}
+
+// Listings have this many replays unless the request's limit is set.
+const default_limit = 20
+const max_limit = 100
+
+// Serves the listing from the database and replays from the fetched replays
+// (see [pipeline.Workspace]).
+type Server struct {
+	witsdb    db.OsnDB
+	workspace pipeline.Workspace
+	mux       *http.ServeMux
+}
+
+func NewServer(witsdb db.OsnDB, workspace pipeline.Workspace) *Server {
+	server := &Server{witsdb, workspace, http.NewServeMux()}
+	server.Register(server.mux)
+	return server
+}
+
+// Adds the endpoints to the mux, so they can be served along with others.
+func (server *Server) Register(mux *http.ServeMux) {
+	mux.HandleFunc("POST "+ListingPath, server.list_replays)
+	mux.HandleFunc("GET "+ReplayPath+"{id}", server.get_replay)
+}
+
+func (server *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	server.mux.ServeHTTP(writer, request)
+}
+
+// Lists the matches in the database, with the same form values as OSN's:
+// page (from 0), limit, order (created or id), order_asc and ret_total.
+func (server *Server) list_replays(writer http.ResponseWriter, request *http.Request) {
+	if err := request.ParseForm(); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter := db.MatchFilter{Page: db.Page{Limit: default_limit}}
+	if limit, err := strconv.Atoi(request.Form.Get("limit")); err == nil {
+		filter.Limit = min(max(limit, 1), max_limit)
+	}
+	if page, err := strconv.Atoi(request.Form.Get("page")); err == nil && page > 0 {
+		filter.Offset = page * filter.Limit
+	}
+	switch request.Form.Get("order") {
+	case "", "created":
+	case "id":
+		filter.ByIndex = true
+	default:
+		http.Error(writer, fmt.Sprintf("unknown order %q", request.Form.Get("order")),
+			http.StatusBadRequest)
+		return
+	}
+	filter.Ascending = request.Form.Get("order_asc") == "true"
+
+	matches, err := server.witsdb.SearchMatches(filter)
+	if err != nil {
+		log.Print(err)
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	listing := ReplayListing{
+		Replays: make([]osn.LegacyReplayMetadata, len(matches)),
+		When:    time.Now().UTC().Format(osn.TimeLayout)}
+	for i, match := range matches {
+		listing.Replays[i] = server.metadata(match)
+	}
+	if request.Form.Get("ret_total") != "false" {
+		total, err := server.witsdb.CountMatches(db.MatchFilter{})
+		if err != nil {
+			log.Print(err)
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		listing.Total = strconv.Itoa(total)
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(listing)
+}
+
+// The match's metadata as OSN listed it, from the matches and roles tables.
+// The players' races, whether they won and their base's health are only found
+// in the replay, and leagues, views and likes are not in the archive at all;
+// these are all listed as zero.
+func (server *Server) metadata(match osn.LegacyMatch) osn.LegacyReplayMetadata {
+	metadata := osn.LegacyReplayMetadata{
+		Index:       strconv.FormatInt(match.MatchIndex, 10),
+		GameID:      string(match.MatchHash),
+		LeagueMatch: boolish(match.Competitive),
+		Created:     match.CreatedTime.UTC().Format(osn.TimeLayout),
+		Season:      strconv.Itoa(match.Season),
+		OsnVersion:  strconv.Itoa(match.Version),
+		MapID:       strconv.Itoa(match.MapID),
+		TurnCount:   strconv.Itoa(match.TurnCount),
+		NumPlayers:  "2",
+		ViewCount:   "0",
+		LikeCount:   "0",
+	}
+	if legacymap, err := server.witsdb.MapByID(uint8(match.MapID)); err == nil {
+		metadata.MapName = legacymap.Name
+		metadata.MapTheme = strconv.Itoa(legacymap.Theme)
+		if legacymap.RoleCount == 4 {
+			metadata.NumPlayers = "4"
+		}
+	}
+
+	roles, err := server.witsdb.MatchPlayers(match.MatchHash)
+	if err != nil {
+		log.Printf("players of match %s: %s", match.MatchHash.ShortID(), err)
+	}
+	if len(roles) > 0 {
+		metadata.NumPlayers = strconv.Itoa(len(roles))
+	}
+
+	players := [][]*string{
+		{&metadata.Player1_ID, &metadata.Player1_Name, &metadata.Player1_Race,
+			&metadata.Player1_Wins, &metadata.Player1_BaseHP},
+		{&metadata.Player2_ID, &metadata.Player2_Name, &metadata.Player2_Race,
+			&metadata.Player2_Wins, &metadata.Player2_BaseHP},
+		{&metadata.Player3_ID, &metadata.Player3_Name, &metadata.Player3_Race,
+			&metadata.Player3_Wins, &metadata.Player3_BaseHP},
+		{&metadata.Player4_ID, &metadata.Player4_Name, &metadata.Player4_Race,
+			&metadata.Player4_Wins, &metadata.Player4_BaseHP},
+	}
+	// Players without a role in the archive are listed as the unknown player.
+	num_players, _ := strconv.Atoi(metadata.NumPlayers)
+	for _, fields := range players[:min(max(num_players, 2), len(players))] {
+		*fields[0] = "0"
+		*fields[2] = "0"
+		*fields[3] = "0"
+		*fields[4] = "0"
+	}
+	for i, role := range roles[:min(len(roles), len(players))] {
+		fields := players[i]
+		*fields[0] = strconv.FormatInt(role.RowID, 10)
+		*fields[1] = role.Name
+	}
+	if len(roles) > 0 {
+		metadata.FirstPlayer = metadata.Player1_ID
+	}
+	return metadata
+}
+
+func boolish(value bool) string {
+	if value {
+		return "1"
+	}
+	return "0"
+}
+
+// Serves the replay as it was fetched, in its wire format.
+func (server *Server) get_replay(writer http.ResponseWriter, request *http.Request) {
+	// Only the replays of matches in the database are served, so that the ID
+	// (which is unescaped from the path) is never used as a path itself.
+	match, err := server.find_match(request.PathValue("id"))
+	if err != nil {
+		log.Print(err)
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if match == nil {
+		http.Error(writer, fmt.Sprintf("no replay %s", request.PathValue("id")),
+			http.StatusNotFound)
+		return
+	}
+	filedata, err := server.workspace.ReadArtifact(osn.STATUS_FETCHED, match.MatchHash)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(writer, fmt.Sprintf("no replay %s", match.MatchHash.ShortID()),
+			http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Print(err)
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(filedata)
+}
+
+// The match with the ID, which may be in either its full or short form, or nil
+// if there is no such match in the database.
+func (server *Server) find_match(id string) (*osn.LegacyMatch, error) {
+	for _, matchID := range []osn.GameID{osn.GameID(id), osn.ExpandShortID(id)} {
+		record, err := server.witsdb.Matches().GetByName(string(matchID))
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return &record.LegacyMatch, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,222 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/osnapi/server_test.go
+
+package osnapi_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	osn "github.com/kevindamm/wits-osn"
+	"github.com/kevindamm/wits-osn/db"
+	"github.com/kevindamm/wits-osn/osnapi"
+	"github.com/kevindamm/wits-osn/pipeline"
+)
+
+const sampleID = osn.GameID("ahRzfm91dHdpdHRlcnNnYW1lLWhyZHIVCxIIR2FtZVJvb20YgIDQlK_hqAoM")
+
+// Serves the sample replay (as fetched) and three listed matches without
+// replays.  The last of these was created first but listed after the others.
+func sample_server(t *testing.T) (*httptest.Server, []byte) {
+	tempdir := t.TempDir()
+	workspace := pipeline.Workspace(tempdir)
+	filedata, err := os.ReadFile(path.Join("..", "testdata", string(sampleID)+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := workspace.WriteFetched(sampleID, filedata); err != nil {
+		t.Fatal(err)
+	}
+
+	witsdb := db.OpenOsnDB(path.Join(tempdir, "osn.db"))
+	t.Cleanup(witsdb.Close)
+	witsdb.MustCreateAndPopulateTables()
+	for _, player := range []osn.Player{
+		{RowID: 11, GCID: "G:11", Name: "DarthMickeyJJ"},
+		{RowID: 12, GCID: "G:12", Name: "KevinDamm"},
+	} {
+		if err := witsdb.Players().Insert(&db.PlayerRecord{Player: player}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	created := time.Date(2013, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i, matchID := range []osn.GameID{sampleID, "listed", "latest", "backfilled"} {
+		age := time.Duration(i) * time.Hour
+		if matchID == "backfilled" {
+			age = -time.Hour
+		}
+		match := osn.LegacyMatch{
+			MatchIndex:  int64(i + 1),
+			MatchHash:   matchID,
+			Competitive: i == 0,
+			Season:      3,
+			CreatedTime: created.Add(age),
+			MapID:       16,
+			TurnCount:   15,
+			Version:     1063,
+			FetchStatus: osn.STATUS_LISTED}
+		if err := witsdb.Matches().Insert(db.MakeMatchRecord(match)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, role := range []db.PlayerRoleRecord{
+		{MatchID: 1, PlayerID: 11, TurnOrder: 1},
+		{MatchID: 1, PlayerID: 12, TurnOrder: 2},
+	} {
+		if err := witsdb.Roles().Insert(&role); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := httptest.NewServer(osnapi.NewServer(witsdb, workspace))
+	t.Cleanup(server.Close)
+	return server, filedata
+}
+
+func list(t *testing.T, server *httptest.Server, values url.Values) osnapi.ReplayListing {
+	t.Helper()
+	response, err := http.PostForm(server.URL+osnapi.ListingPath, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("listing %v: status %d", values, response.StatusCode)
+	}
+	var listing osnapi.ReplayListing
+	if err := json.NewDecoder(response.Body).Decode(&listing); err != nil {
+		t.Fatal(err)
+	}
+	return listing
+}
+
+func TestListing(t *testing.T) {
+	server, _ := sample_server(t)
+
+	listing := list(t, server, url.Values{
+		"limit": {"2"}, "order": {"created"}, "order_asc": {"false"}, "list": {"recent"}})
+	if listing.Total != "4" || len(listing.Replays) != 2 ||
+		listing.Replays[0].GameID != "latest" || listing.Replays[1].GameID != "listed" {
+		t.Errorf("unexpected first page %+v", listing)
+	}
+
+	listing = list(t, server, url.Values{
+		"page": {"1"}, "limit": {"2"}, "order_asc": {"false"}, "ret_total": {"false"}})
+	if listing.Total != "" || len(listing.Replays) != 2 {
+		t.Fatalf("unexpected second page %+v", listing)
+	}
+	expected := osn.LegacyReplayMetadata{
+		Index:          "1",
+		GameID:         string(sampleID),
+		NumPlayers:     "2",
+		LeagueMatch:    "1",
+		Created:        "2013-05-01 12:00:00",
+		Season:         "3",
+		OsnVersion:     "1063",
+		MapID:          "16",
+		MapName:        "Sweet Tooth",
+		MapTheme:       listing.Replays[0].MapTheme,
+		TurnCount:      "15",
+		ViewCount:      "0",
+		LikeCount:      "0",
+		Player1_ID:     "11",
+		Player1_Name:   "DarthMickeyJJ",
+		Player1_Race:   "0",
+		Player1_Wins:   "0",
+		Player1_BaseHP: "0",
+		Player2_ID:     "12",
+		Player2_Name:   "KevinDamm",
+		Player2_Race:   "0",
+		Player2_Wins:   "0",
+		Player2_BaseHP: "0",
+		FirstPlayer:    "11",
+	}
+	if listing.Replays[0] != expected {
+		t.Errorf("unexpected metadata\n%+v\nexpected\n%+v", listing.Replays[0], expected)
+	}
+
+	// The listing converts into the same metadata that the fetcher keeps.
+	match := listing.Replays[0].ToLegacyMatch()
+	if match.MatchHash != sampleID || match.MatchIndex != 1 || !match.Competitive {
+		t.Errorf("unexpected match %+v", match)
+	}
+
+	listing = list(t, server, url.Values{"order_asc": {"true"}})
+	if len(listing.Replays) != 4 || listing.Replays[0].GameID != "backfilled" ||
+		listing.Replays[1].GameID != string(sampleID) {
+		t.Errorf("unexpected ascending listing %+v", listing)
+	}
+
+	listing = list(t, server, url.Values{"limit": {"2"}, "order": {"id"}})
+	if len(listing.Replays) != 2 ||
+		listing.Replays[0].GameID != "backfilled" || listing.Replays[1].GameID != "latest" {
+		t.Errorf("unexpected listing by index %+v", listing)
+	}
+
+	response, err := http.PostForm(server.URL+osnapi.ListingPath, url.Values{"order": {"views"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected bad request for an unknown order, got %d", response.StatusCode)
+	}
+}
+
+func TestGetReplay(t *testing.T) {
+	server, filedata := sample_server(t)
+
+	for _, id := range []string{string(sampleID), sampleID.ShortID()} {
+		response, err := http.Get(server.URL + osnapi.ReplayPath + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		served, err := io.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != http.StatusOK || string(served) != string(filedata) {
+			t.Errorf("%s: status %d, expected the fetched replay", id, response.StatusCode)
+		}
+	}
+
+	// Matches without a replay, IDs of no match and paths are all not found.
+	for _, id := range []string{"listed", "unlisted",
+		"..%2F..%2F..%2Fetc%2Fpasswd", "..%2Freplays%2F" + sampleID.ShortID()} {
+		response, err := http.Get(server.URL + osnapi.ReplayPath + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: expected not found, got %d", id, response.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/pipeline/checkpoint.go
+
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// The progress of long-running tasks (e.g. reading a large file), as a mark for
+// each task that it has finished everything up to, persisted to a JSON file.
+// A nil checkpoint is valid and does not keep any progress.
+type Checkpoint struct {
+	filepath string
+	mutex    sync.Mutex
+	marks    map[string]int64
+	unsaved  int
+}
+
+// Progress is written to the file after this many updates, and when saved.
+const checkpoint_interval = 100
+
+// Reads the checkpoint at the indicated path, which may not exist yet.
+func OpenCheckpoint(filepath string) (*Checkpoint, error) {
+	checkpoint := &Checkpoint{filepath: filepath, marks: make(map[string]int64)}
+	data, err := os.ReadFile(filepath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &checkpoint.marks); err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+// The mark that the task has finished up to, or 0 if it hasn't started.
+func (checkpoint *Checkpoint) Mark(task string) int64 {
+	if checkpoint == nil {
+		return 0
+	}
+	checkpoint.mutex.Lock()
+	defer checkpoint.mutex.Unlock()
+	return checkpoint.marks[task]
+}
+
+// Records the task's progress, saving it periodically.
+func (checkpoint *Checkpoint) Update(task string, mark int64) error {
+	if checkpoint == nil {
+		return nil
+	}
+	checkpoint.mutex.Lock()
+	checkpoint.marks[task] = mark
+	checkpoint.unsaved += 1
+	unsaved := checkpoint.unsaved
+	checkpoint.mutex.Unlock()
+
+	if unsaved >= checkpoint_interval {
+		return checkpoint.Save()
+	}
+	return nil
+}
+
+// Forgets the progress of the task, so that it starts from the beginning.
+func (checkpoint *Checkpoint) Reset(task string) {
+	if checkpoint == nil {
+		return
+	}
+	checkpoint.mutex.Lock()
+	defer checkpoint.mutex.Unlock()
+	delete(checkpoint.marks, task)
+	checkpoint.unsaved += 1
+}
+
+// Writes the progress to its file, replacing the previous checkpoint.
+func (checkpoint *Checkpoint) Save() error {
+	if checkpoint == nil {
+		return nil
+	}
+	checkpoint.mutex.Lock()
+	defer checkpoint.mutex.Unlock()
+	data, err := json.MarshalIndent(checkpoint.marks, "", "  ")
+	if err != nil {
+		return err
+	}
+	temp := checkpoint.filepath + ".tmp"
+	if err := os.WriteFile(temp, data, 0644); err != nil {
+		return err
+	}
+	checkpoint.unsaved = 0
+	return os.Rename(temp, checkpoint.filepath)
+}
@@ -0,0 +1,210 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/pipeline/pipeline.go
+
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	osn "github.com/kevindamm/wits-osn"
+	"github.com/kevindamm/wits-osn/db"
+)
+
+// A step of replay processing, which advances a match from one [osn.FetchStatus]
+// to the next.  Stages should be idempotent, a stage may be run again on the
+// same match if its result was not recorded (e.g. the process was interrupted).
+type Stage interface {
+	Name() string
+	From() osn.FetchStatus
+	To() osn.FetchStatus
+
+	// Processes the match, returning an error if it could not be advanced.
+	Run(ctx context.Context, match osn.LegacyMatch) error
+}
+
+// The stages of processing, indexed by the status of the matches they consume.
+type Pipeline struct {
+	stages map[osn.FetchStatus]Stage
+}
+
+func NewPipeline() *Pipeline {
+	return &Pipeline{make(map[osn.FetchStatus]Stage)}
+}
+
+// Adds the stage to the pipeline.  Returns an error if the stage's transition
+// is not a legal one or if another stage already consumes the same status.
+func (pipeline *Pipeline) Register(stage Stage) error {
+	from, to := stage.From(), stage.To()
+	if to == osn.STATUS_INVALID || !from.CanBecome(to) {
+		return fmt.Errorf("stage %s: illegal transition %s -> %s",
+			stage.Name(), from, to)
+	}
+	if other, exists := pipeline.stages[from]; exists {
+		return fmt.Errorf("stage %s: %s matches are already consumed by %s",
+			stage.Name(), from, other.Name())
+	}
+	pipeline.stages[from] = stage
+	return nil
+}
+
+// The stage which advances matches from the indicated status.
+func (pipeline *Pipeline) StageAfter(status osn.FetchStatus) (Stage, error) {
+	stage, ok := pipeline.stages[status]
+	if !ok {
+		return nil, fmt.Errorf("no stage registered for %s matches", status)
+	}
+	return stage, nil
+}
+
+// Counts of the matches that a stage was run on.
+type Summary struct {
+	Advanced int // matches moved to the stage's status
+	Invalid  int // matches which failed and were marked INVALID
+	Skipped  int // matches whose result could not be recorded
+}
+
+func (summary Summary) String() string {
+	return fmt.Sprintf("%d advanced, %d invalid, %d skipped",
+		summary.Advanced, summary.Invalid, summary.Skipped)
+}
+
+// Runs the stage on every match at the stage's starting status, using at most
+// `workers` concurrent goroutines.  Matches which the stage fails on are marked
+// INVALID.  The status of each match is its progress, so an interrupted run is
+// resumed by running the stage again, and matches which reach the stage's
+// status later (e.g. a replay fetched again) are selected the next time.
+// Cancelling the context stops new matches from being started and returns once
+// running ones have finished.
+func RunStage(ctx context.Context, witsdb db.OsnDB, stage Stage, workers int) (Summary, error) {
+	var summary Summary
+	if workers < 1 {
+		return summary, fmt.Errorf("at least one worker is required, got %d", workers)
+	}
+	matches, err := select_matches(witsdb, stage.From())
+	if err != nil {
+		return summary, err
+	}
+	log.Printf("stage %s: %d %s matches", stage.Name(), len(matches), stage.From())
+
+	jobs := make(chan osn.LegacyMatch)
+	go func() {
+		defer close(jobs)
+		for _, match := range matches {
+			select {
+			case jobs <- match:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	type result struct {
+		match osn.LegacyMatch
+		err   error
+	}
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for match := range jobs {
+				results <- result{match, stage.Run(ctx, match)}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	for result := range results {
+		if errors.Is(result.err, context.Canceled) {
+			summary.Skipped += 1
+			continue
+		}
+
+		status := stage.To()
+		if result.err != nil {
+			status = osn.STATUS_INVALID
+			log.Printf("stage %s: %s INVALID: %s",
+				stage.Name(), result.match.MatchHash.ShortID(), result.err)
+		}
+		if err := witsdb.UpdateMatchStatus(
+			result.match.MatchHash, status, stage.Name(), result.err); err != nil {
+			log.Printf("stage %s: %s: %s",
+				stage.Name(), result.match.MatchHash.ShortID(), err)
+			summary.Skipped += 1
+			continue
+		}
+		if status == osn.STATUS_INVALID {
+			summary.Invalid += 1
+		} else {
+			summary.Advanced += 1
+		}
+	}
+	return summary, nil
+}
+
+// Returns matches which were processed beyond FETCHED, including those which
+// became INVALID, to FETCHED if their fetched replay is in the workspace.  The
+// stages can then be run on them again, e.g. after a fix to unwrapping them.
+// Returns the number of matches that were reset.
+func Reprocess(witsdb db.OsnDB, workspace Workspace) (int, error) {
+	matches := make([]osn.GameID, 0)
+	for status := range osn.FetchStatusRange {
+		if status <= osn.STATUS_FETCHED || status == osn.STATUS_LEGACY {
+			continue
+		}
+		records, err := select_matches(witsdb, status)
+		if err != nil {
+			return 0, err
+		}
+		for _, record := range records {
+			matches = append(matches, record.MatchHash)
+		}
+	}
+
+	count := 0
+	for _, matchID := range matches {
+		if !workspace.HasFetched(matchID) {
+			continue // INVALID when fetched, or its replay has been removed.
+		}
+		err := witsdb.UpdateMatchStatus(matchID, osn.STATUS_FETCHED, "reprocess", nil)
+		if err != nil {
+			return count, err
+		}
+		count += 1
+	}
+	return count, nil
+}
+
+// The matches with the indicated status, in order of their index.  These are
+// all read before any of their statuses are updated.
+func select_matches(witsdb db.OsnDB, status osn.FetchStatus) ([]osn.LegacyMatch, error) {
+	return witsdb.SearchMatches(db.MatchFilter{
+		Status: &status, ByIndex: true, Ascending: true})
+}
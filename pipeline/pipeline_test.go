@@ -0,0 +1,209 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/pipeline/pipeline_test.go
+
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"testing"
+
+	osn "github.com/kevindamm/wits-osn"
+	"github.com/kevindamm/wits-osn/db"
+	"github.com/kevindamm/wits-osn/pipeline"
+)
+
+const sampleID = osn.GameID("ahRzfm91dHdpdHRlcnNnYW1lLWhyZHIVCxIIR2FtZVJvb20YgIDQlK_hqAoM")
+const missingID = osn.GameID("ahRzfm91dHdpdHRlcnNnYW1lLWhyZHIVCxIIR2FtZVJvb20YmissingXYZ")
+
+type testStage struct {
+	name     string
+	from, to osn.FetchStatus
+}
+
+func (stage testStage) Name() string          { return stage.name }
+func (stage testStage) From() osn.FetchStatus { return stage.from }
+func (stage testStage) To() osn.FetchStatus   { return stage.to }
+func (stage testStage) Run(context.Context, osn.LegacyMatch) error {
+	return errors.New("not implemented")
+}
+
+func TestRegister(t *testing.T) {
+	stages := pipeline.NewPipeline()
+	if err := stages.Register(testStage{"skip", osn.STATUS_LISTED, osn.STATUS_VALIDATED}); err == nil {
+		t.Error("expected an error registering an illegal transition")
+	}
+	if err := stages.Register(testStage{"fail", osn.STATUS_LISTED, osn.STATUS_INVALID}); err == nil {
+		t.Error("expected an error registering a stage that only invalidates")
+	}
+	if err := stages.Register(testStage{"fetch", osn.STATUS_LISTED, osn.STATUS_FETCHED}); err != nil {
+		t.Error(err)
+	}
+	if err := stages.Register(testStage{"again", osn.STATUS_LISTED, osn.STATUS_FETCHED}); err == nil {
+		t.Error("expected an error registering a second stage for LISTED")
+	}
+	if _, err := stages.StageAfter(osn.STATUS_FETCHED); err == nil {
+		t.Error("expected an error for an unregistered stage")
+	}
+}
+
+func TestFileStages(t *testing.T) {
+	tempdir := t.TempDir()
+	workspace := pipeline.Workspace(tempdir)
+
+	// The sample replay as it was fetched, and a match whose replay is missing.
+	filedata, err := os.ReadFile(path.Join("..", "testdata", string(sampleID)+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := workspace.WriteFetched(sampleID, filedata); err != nil {
+		t.Fatal(err)
+	}
+
+	witsdb := db.OpenOsnDB(path.Join(tempdir, "osn.db"))
+	defer witsdb.Close()
+	witsdb.MustCreateAndPopulateTables()
+	for i, matchID := range []osn.GameID{sampleID, missingID} {
+		record := db.MakeMatchRecord(osn.LegacyMatch{
+			MatchIndex:  int64(i + 1),
+			MatchHash:   matchID,
+			MapID:       16,
+			FetchStatus: osn.STATUS_FETCHED})
+		if err := witsdb.Matches().Insert(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stages := pipeline.FileStages(workspace)
+	for i, stage := range stages {
+		summary, err := pipeline.RunStage(context.Background(), witsdb, stage, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := pipeline.Summary{Advanced: 1}
+		if i == 0 {
+			expected.Invalid = 1
+		}
+		if summary != expected {
+			t.Errorf("stage %s: %s", stage.Name(), summary)
+		}
+	}
+
+	for matchID, status := range map[osn.GameID]osn.FetchStatus{
+		sampleID:  osn.STATUS_VALIDATED,
+		missingID: osn.STATUS_INVALID,
+	} {
+		record, err := witsdb.Matches().GetByName(string(matchID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.FetchStatus != status {
+			t.Errorf("match %s is %s, expected %s", matchID.ShortID(), record.FetchStatus, status)
+		}
+	}
+	history, err := witsdb.MatchStatusHistory(missingID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[1].Source != "unwrap" || history[1].Message == "" {
+		t.Errorf("expected the reason the match is invalid, got %+v", history)
+	}
+	if _, err := workspace.ReadReplay(osn.STATUS_CANONICAL, sampleID); err != nil {
+		t.Error(err)
+	}
+
+	// Running a stage again finds nothing left to do.
+	summary, err := pipeline.RunStage(context.Background(), witsdb, stages[0], 1)
+	if err != nil || summary != (pipeline.Summary{}) {
+		t.Errorf("expected no matches to process, got %s (%v)", summary, err)
+	}
+
+	// The missing replay is found later, its match is run through the stage even
+	// though a later match has already been through it.
+	if err := workspace.WriteFetched(missingID, filedata); err != nil {
+		t.Fatal(err)
+	}
+	if err := witsdb.UpdateMatchStatus(missingID, osn.STATUS_FETCHED, "fetch", nil); err != nil {
+		t.Fatal(err)
+	}
+	summary, err = pipeline.RunStage(context.Background(), witsdb, stages[0], 1)
+	if err != nil || summary != (pipeline.Summary{Advanced: 1}) {
+		t.Errorf("expected the found replay to be unwrapped, got %s (%v)", summary, err)
+	}
+}
+
+func TestReprocess(t *testing.T) {
+	tempdir := t.TempDir()
+	workspace := pipeline.Workspace(tempdir)
+	filedata, err := os.ReadFile(path.Join("..", "testdata", string(sampleID)+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := workspace.WriteFetched(sampleID, filedata); err != nil {
+		t.Fatal(err)
+	}
+	archived, err := workspace.ReadArtifact(osn.STATUS_FETCHED, sampleID)
+	if err != nil || string(archived) != string(filedata) {
+		t.Fatalf("the archive does not read back the fetched bytes (%v)", err)
+	}
+
+	witsdb := db.OpenOsnDB(path.Join(tempdir, "osn.db"))
+	defer witsdb.Close()
+	witsdb.MustCreateAndPopulateTables()
+	for i, matchID := range []osn.GameID{sampleID, missingID} {
+		record := db.MakeMatchRecord(osn.LegacyMatch{
+			MatchIndex:  int64(i + 1),
+			MatchHash:   matchID,
+			MapID:       16,
+			FetchStatus: osn.STATUS_INVALID})
+		if err := witsdb.Matches().Insert(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only the match with an archived replay is returned to FETCHED.
+	count, err := pipeline.Reprocess(witsdb, workspace)
+	if err != nil || count != 1 {
+		t.Fatalf("expected one match reprocessed, got %d (%v)", count, err)
+	}
+	for matchID, status := range map[osn.GameID]osn.FetchStatus{
+		sampleID:  osn.STATUS_FETCHED,
+		missingID: osn.STATUS_INVALID,
+	} {
+		record, _ := witsdb.Matches().GetByName(string(matchID))
+		if record.FetchStatus != status {
+			t.Errorf("match %s is %s, expected %s", matchID.ShortID(), record.FetchStatus, status)
+		}
+	}
+
+	unwrap := pipeline.FileStages(workspace)[0]
+	summary, err := pipeline.RunStage(context.Background(), witsdb, unwrap, 1)
+	if err != nil || summary != (pipeline.Summary{Advanced: 1}) {
+		t.Errorf("expected the match to be unwrapped again, got %s (%v)", summary, err)
+	}
+	replay, err := workspace.ReadReplay(osn.STATUS_UNWRAPPED, sampleID)
+	if err != nil || len(replay.Replay) != 15 {
+		t.Errorf("expected 15 unwrapped turns, got %d (%v)", len(replay.Replay), err)
+	}
+}
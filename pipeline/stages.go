@@ -0,0 +1,253 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/pipeline/stages.go
+
+package pipeline
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	osn "github.com/kevindamm/wits-osn"
+)
+
+// The directory where each stage's artifacts are written, with a subdirectory
+// for each status.  Fetched replays are found in `replays/`, where the fetch
+// command writes them, other artifacts are in a directory named for their
+// status (e.g. `unwrapped/`).  Each artifact is named for its match's short ID.
+//
+// Fetched replays are kept as they were received, in their wire format, and are
+// compressed (`.json.gz`).  The artifacts of later stages are plain JSON.
+type Workspace string
+
+func (workspace Workspace) Path(status osn.FetchStatus, matchID osn.GameID) string {
+	if status == osn.STATUS_FETCHED {
+		return path.Join(string(workspace), "replays",
+			fmt.Sprintf("%s.json.gz", matchID.ShortID()))
+	}
+	return path.Join(string(workspace), strings.ToLower(status.String()),
+		fmt.Sprintf("%s.json", matchID.ShortID()))
+}
+
+// Earlier versions of the fetch command wrote replays uncompressed, after
+// unwrapping only the match's metadata.  These are read if there is no archive.
+func (workspace Workspace) legacy_fetched_path(matchID osn.GameID) string {
+	return path.Join(string(workspace), "replays",
+		fmt.Sprintf("%s.json", matchID.ShortID()))
+}
+
+// Whether the match's replay has been fetched (in either form) into the workspace.
+func (workspace Workspace) HasFetched(matchID osn.GameID) bool {
+	for _, filepath := range []string{
+		workspace.Path(osn.STATUS_FETCHED, matchID),
+		workspace.legacy_fetched_path(matchID),
+	} {
+		if _, err := os.Stat(filepath); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads the bytes of the match's artifact of the indicated status, which is
+// decompressed if it is the fetched replay.
+func (workspace Workspace) ReadArtifact(status osn.FetchStatus, matchID osn.GameID) ([]byte, error) {
+	if status != osn.STATUS_FETCHED {
+		return os.ReadFile(workspace.Path(status, matchID))
+	}
+	file, err := os.Open(workspace.Path(status, matchID))
+	if errors.Is(err, fs.ErrNotExist) {
+		return os.ReadFile(workspace.legacy_fetched_path(matchID))
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file.Name(), err)
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// Reads the match's artifact of the indicated status.
+func (workspace Workspace) ReadReplay(status osn.FetchStatus, matchID osn.GameID) (osn.LegacyMatchWithReplay, error) {
+	filedata, err := workspace.ReadArtifact(status, matchID)
+	if err != nil {
+		return osn.LegacyMatchWithReplay{}, err
+	}
+	return osn.DecodeReplay(filedata)
+}
+
+// Writes the match's artifact of the indicated status, replacing any previous
+// artifact only once it has been completely written.
+func (workspace Workspace) WriteReplay(status osn.FetchStatus, match osn.LegacyMatchWithReplay) error {
+	if status == osn.STATUS_FETCHED {
+		return fmt.Errorf("fetched replays are written in their wire format")
+	}
+	encoded, err := json.Marshal(match)
+	if err != nil {
+		return err
+	}
+	return write_artifact(workspace.Path(status, match.MatchHash), encoded)
+}
+
+// Archives the replay's bytes exactly as they were fetched, compressed.
+func (workspace Workspace) WriteFetched(matchID osn.GameID, wire_data []byte) error {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(wire_data); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return write_artifact(workspace.Path(osn.STATUS_FETCHED, matchID), compressed.Bytes())
+}
+
+func write_artifact(filepath string, filedata []byte) error {
+	if err := os.MkdirAll(path.Dir(filepath), 0755); err != nil {
+		return err
+	}
+	temp := filepath + ".tmp"
+	if err := os.WriteFile(temp, filedata, 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp, filepath)
+}
+
+// The stages which process replay files in the workspace, from FETCHED through
+// VALIDATED.  Fetching and indexing are performed by their own commands.
+func FileStages(workspace Workspace) []Stage {
+	return []Stage{
+		unwrapStage{workspace},
+		convertStage{workspace},
+		canonicalStage{workspace},
+		validateStage{workspace},
+	}
+}
+
+// Registers each of the stages, returning the pipeline or the first error.
+func NewFilePipeline(workspace Workspace) (*Pipeline, error) {
+	pipeline := NewPipeline()
+	for _, stage := range FileStages(workspace) {
+		if err := pipeline.Register(stage); err != nil {
+			return nil, err
+		}
+	}
+	return pipeline, nil
+}
+
+// Reads the match from the fetched replay and writes it without its wrapping.
+// Fetched replays are archived as they were received, so this may be run again
+// (see [Reprocess]) if a replay was unwrapped incorrectly.
+type unwrapStage struct{ Workspace }
+
+func (unwrapStage) Name() string          { return "unwrap" }
+func (unwrapStage) From() osn.FetchStatus { return osn.STATUS_FETCHED }
+func (unwrapStage) To() osn.FetchStatus   { return osn.STATUS_UNWRAPPED }
+
+func (stage unwrapStage) Run(ctx context.Context, match osn.LegacyMatch) error {
+	replay, err := stage.ReadReplay(stage.From(), match.MatchHash)
+	if err != nil {
+		return err
+	}
+	if len(replay.Replay) == 0 {
+		return osn.ErrEmptyReplay
+	}
+	replay.LegacyMatch = match
+	return stage.WriteReplay(stage.To(), replay)
+}
+
+// Checks that every turn of the replay decodes into known actions on a known
+// map, writing the replay in its re-encoded form.
+type convertStage struct{ Workspace }
+
+func (convertStage) Name() string          { return "convert" }
+func (convertStage) From() osn.FetchStatus { return osn.STATUS_UNWRAPPED }
+func (convertStage) To() osn.FetchStatus   { return osn.STATUS_CONVERTED }
+
+func (stage convertStage) Run(ctx context.Context, match osn.LegacyMatch) error {
+	replay, err := stage.ReadReplay(stage.From(), match.MatchHash)
+	if err != nil {
+		return err
+	}
+	if len(replay.Replay) == 0 {
+		return osn.ErrEmptyReplay
+	}
+	if _, err := osn.MapByName(replay.Replay[0].State.MapName); err != nil {
+		return err
+	}
+	for i, turn := range replay.Replay {
+		for j, action := range turn.Actions {
+			if unknown, ok := action.(*osn.UnknownAction); ok {
+				return fmt.Errorf("turn %d, action %d: unknown action %s",
+					i, j, unknown.Name())
+			}
+		}
+	}
+	replay.LegacyMatch = match
+	return stage.WriteReplay(stage.To(), replay)
+}
+
+// Rewrites the replay into its canonical orientation, see [osn.Canonicalize].
+type canonicalStage struct{ Workspace }
+
+func (canonicalStage) Name() string          { return "canonicalize" }
+func (canonicalStage) From() osn.FetchStatus { return osn.STATUS_CONVERTED }
+func (canonicalStage) To() osn.FetchStatus   { return osn.STATUS_CANONICAL }
+
+func (stage canonicalStage) Run(ctx context.Context, match osn.LegacyMatch) error {
+	replay, err := stage.ReadReplay(stage.From(), match.MatchHash)
+	if err != nil {
+		return err
+	}
+	canonical, _, err := osn.Canonicalize(replay)
+	if err != nil {
+		return err
+	}
+	canonical.LegacyMatch = match
+	return stage.WriteReplay(stage.To(), canonical)
+}
+
+// Re-simulates the canonical replay, see [osn.ValidateReplay].
+type validateStage struct{ Workspace }
+
+func (validateStage) Name() string          { return "validate" }
+func (validateStage) From() osn.FetchStatus { return osn.STATUS_CANONICAL }
+func (validateStage) To() osn.FetchStatus   { return osn.STATUS_VALIDATED }
+
+func (stage validateStage) Run(ctx context.Context, match osn.LegacyMatch) error {
+	replay, err := stage.ReadReplay(stage.From(), match.MatchHash)
+	if err != nil {
+		return err
+	}
+	return osn.ValidateReplay(replay)
+}
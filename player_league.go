@@ -23,6 +23,7 @@
 package osn
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"strconv"
@@ -73,6 +74,39 @@ func ParseLeague(str_uint string) LeagueEnum {
 	return LeagueEnum(value)
 }
 
+// Leagues appear by name ("Gifted") in the game over data of OSN replays,
+// where an unknown league (e.g. for friendly matches) is the empty string.
+func (league LeagueEnum) MarshalJSON() ([]byte, error) {
+	if league == LEAGUE_UNKNOWN || !league.IsValid() {
+		return json.Marshal("")
+	}
+	return json.Marshal(league.String())
+}
+
+// Accepts either the league's name or its integer representation.
+func (league *LeagueEnum) UnmarshalJSON(encoded []byte) error {
+	var name string
+	if err := json.Unmarshal(encoded, &name); err != nil {
+		var value uint8
+		if err := json.Unmarshal(encoded, &value); err != nil {
+			return fmt.Errorf("failed to convert [%s] to LeagueEnum", encoded)
+		}
+		*league = LeagueEnum(value)
+		return nil
+	}
+	if name == "" {
+		*league = LEAGUE_UNKNOWN
+		return nil
+	}
+	for i, league_name := range league_names {
+		if name == league_name {
+			*league = LeagueEnum(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unrecognized league name %s", name)
+}
+
 // The player's rank within their current group.
 // Players are placed in groups of around 100 when entering a league.
 // Each of these divisions is given a name but historical data of that is
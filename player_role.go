@@ -53,7 +53,7 @@ type OsnRoleSettings struct {
 	Color    uint         `json:"color"`
 	UnitRace UnitRaceEnum `json:"race"`
 
-	BaseTheme   uint    `json:"basePref,omitempty"`
-	Invited     Boolish `json:"isInvited,omitempty"`
-	Placeholder Boolish `json:"isPlaceHolder,omitempty"`
+	BaseTheme   uint    `json:"basePref"`
+	Invited     Boolish `json:"isInvited"`
+	Placeholder Boolish `json:"isPlaceHolder"`
 }
@@ -0,0 +1,131 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/render/font.go
+
+package render
+
+import (
+	"image"
+	"unicode"
+)
+
+// A bitmap font for raster images, three pixels wide and five tall.  Each row
+// of a glyph is three bits, the most significant bit is the left-most pixel.
+// Lowercase letters are drawn as uppercase, unknown runes are drawn as '?'.
+var font_glyphs = map[rune][5]uint8{
+	'A': {0b010, 0b101, 0b111, 0b101, 0b101},
+	'B': {0b110, 0b101, 0b110, 0b101, 0b110},
+	'C': {0b011, 0b100, 0b100, 0b100, 0b011},
+	'D': {0b110, 0b101, 0b101, 0b101, 0b110},
+	'E': {0b111, 0b100, 0b110, 0b100, 0b111},
+	'F': {0b111, 0b100, 0b110, 0b100, 0b100},
+	'G': {0b011, 0b100, 0b101, 0b101, 0b011},
+	'H': {0b101, 0b101, 0b111, 0b101, 0b101},
+	'I': {0b111, 0b010, 0b010, 0b010, 0b111},
+	'J': {0b001, 0b001, 0b001, 0b101, 0b010},
+	'K': {0b101, 0b101, 0b110, 0b101, 0b101},
+	'L': {0b100, 0b100, 0b100, 0b100, 0b111},
+	'M': {0b101, 0b111, 0b111, 0b101, 0b101},
+	'N': {0b110, 0b101, 0b101, 0b101, 0b101},
+	'O': {0b010, 0b101, 0b101, 0b101, 0b010},
+	'P': {0b110, 0b101, 0b110, 0b100, 0b100},
+	'Q': {0b010, 0b101, 0b101, 0b110, 0b011},
+	'R': {0b110, 0b101, 0b110, 0b101, 0b101},
+	'S': {0b011, 0b100, 0b010, 0b001, 0b110},
+	'T': {0b111, 0b010, 0b010, 0b010, 0b010},
+	'U': {0b101, 0b101, 0b101, 0b101, 0b111},
+	'V': {0b101, 0b101, 0b101, 0b101, 0b010},
+	'W': {0b101, 0b101, 0b111, 0b111, 0b101},
+	'X': {0b101, 0b101, 0b010, 0b101, 0b101},
+	'Y': {0b101, 0b101, 0b010, 0b010, 0b010},
+	'Z': {0b111, 0b001, 0b010, 0b100, 0b111},
+	'0': {0b111, 0b101, 0b101, 0b101, 0b111},
+	'1': {0b010, 0b110, 0b010, 0b010, 0b111},
+	'2': {0b110, 0b001, 0b010, 0b100, 0b111},
+	'3': {0b110, 0b001, 0b010, 0b001, 0b110},
+	'4': {0b101, 0b101, 0b111, 0b001, 0b001},
+	'5': {0b111, 0b100, 0b110, 0b001, 0b110},
+	'6': {0b011, 0b100, 0b111, 0b101, 0b111},
+	'7': {0b111, 0b001, 0b010, 0b010, 0b010},
+	'8': {0b111, 0b101, 0b111, 0b101, 0b111},
+	'9': {0b111, 0b101, 0b111, 0b001, 0b110},
+	' ': {0, 0, 0, 0, 0},
+	'-': {0b000, 0b000, 0b111, 0b000, 0b000},
+	'_': {0b000, 0b000, 0b000, 0b000, 0b111},
+	'.': {0b000, 0b000, 0b000, 0b000, 0b010},
+	':': {0b000, 0b010, 0b000, 0b010, 0b000},
+	'/': {0b001, 0b001, 0b010, 0b100, 0b100},
+	'>': {0b100, 0b010, 0b001, 0b010, 0b100},
+	'(': {0b010, 0b100, 0b100, 0b100, 0b010},
+	')': {0b010, 0b001, 0b001, 0b001, 0b010},
+	'?': {0b110, 0b001, 0b010, 0b000, 0b010},
+}
+
+// Glyphs are separated by one (unscaled) column of pixels.
+const font_width, font_height, font_pitch = 3, 5, 4
+
+// The width in pixels of the text when drawn at the indicated scale.
+func text_width(text string, scale int) int {
+	count := len([]rune(text))
+	if count == 0 {
+		return 0
+	}
+	return (count*font_pitch - 1) * scale
+}
+
+// Draws the text with its top-left corner at the point, returning the point
+// where the next text would continue.  Pixels outside the image are clipped.
+func draw_text(img *image.Paletted, at image.Point, text string, scale int, index uint8) image.Point {
+	for _, char := range text {
+		glyph, ok := font_glyphs[unicode.ToUpper(char)]
+		if !ok {
+			glyph = font_glyphs['?']
+		}
+		for row, bits := range glyph {
+			for column := range font_width {
+				if bits&(1<<(font_width-1-column)) == 0 {
+					continue
+				}
+				fill_rect(img, image.Rect(
+					at.X+column*scale, at.Y+row*scale,
+					at.X+(column+1)*scale, at.Y+(row+1)*scale), index)
+			}
+		}
+		at.X += font_pitch * scale
+	}
+	return at
+}
+
+// Draws the text centered on the point.
+func draw_centered(img *image.Paletted, x, y float64, text string, scale int, index uint8) {
+	draw_text(img, image.Pt(
+		int(x+0.5)-text_width(text, scale)/2,
+		int(y+0.5)-font_height*scale/2), text, scale, index)
+}
+
+func fill_rect(img *image.Paletted, rect image.Rectangle, index uint8) {
+	rect = rect.Intersect(img.Rect)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			img.SetColorIndex(x, y, index)
+		}
+	}
+}
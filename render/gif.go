@@ -0,0 +1,283 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/render/gif.go
+
+package render
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"math"
+	"time"
+
+	osn "github.com/kevindamm/wits-osn"
+)
+
+// How the frames of an animated replay are chosen and timed.  Zero values are
+// replaced by the corresponding value of [DefaultGIFOptions].
+type GIFOptions struct {
+	// Draws a frame after each action, not only at the start of each turn.
+	EveryAction bool
+
+	TurnDelay   time.Duration // how long the start of each turn is shown
+	ActionDelay time.Duration // how long each action is shown, see EveryAction
+	FinalDelay  time.Duration // how long the final state is shown
+
+	Radius int // of each hex, in pixels
+}
+
+var DefaultGIFOptions = GIFOptions{
+	EveryAction: false,
+	TurnDelay:   time.Second,
+	ActionDelay: 400 * time.Millisecond,
+	FinalDelay:  3 * time.Second,
+	Radius:      svg_radius,
+}
+
+func (options GIFOptions) with_defaults() GIFOptions {
+	if options.TurnDelay <= 0 {
+		options.TurnDelay = DefaultGIFOptions.TurnDelay
+	}
+	if options.ActionDelay <= 0 {
+		options.ActionDelay = DefaultGIFOptions.ActionDelay
+	}
+	if options.FinalDelay <= 0 {
+		options.FinalDelay = DefaultGIFOptions.FinalDelay
+	}
+	if options.Radius <= 0 {
+		options.Radius = DefaultGIFOptions.Radius
+	}
+	return options
+}
+
+// GIF frame delays are in hundredths of a second.
+func hundredths(delay time.Duration) int {
+	return int(delay / (10 * time.Millisecond))
+}
+
+// Height of the caption strip below the board, two lines of text.
+const gif_caption = 40
+
+var (
+	gif_background = color.RGBA{0xf7, 0xf4, 0xec, 0xff}
+	gif_ink        = color.RGBA{0x40, 0x40, 0x3a, 0xff}
+	gif_white      = color.RGBA{0xff, 0xff, 0xff, 0xff}
+)
+
+// Every color that a frame is drawn with, shared by all frames.  The first
+// entry is the background that new frames are filled with.
+var gif_palette = func() color.Palette {
+	palette := color.Palette{gif_background, gif_ink, gif_white}
+	for _, tile := range []osn.MapTileType{
+		osn.MAPTILE_FLOOR, osn.MAPTILE_BLOCKED, osn.MAPTILE_WITS} {
+		palette = append(palette, TileColors[tile])
+	}
+	for player := range osn.PlayerColorRange {
+		shade := PlayerColors[osn.PlayerColorEnum(player)]
+		palette = append(palette, shade, lighten(shade))
+	}
+	return palette
+}()
+
+func palette_index(shade color.RGBA) uint8 {
+	return uint8(gif_palette.Index(shade))
+}
+
+// Writes the replay as an animated GIF, see [ReplayGIF].
+func WriteGIF(output io.Writer, match osn.LegacyMatchWithReplay, options GIFOptions) error {
+	animation, err := ReplayGIF(match, options)
+	if err != nil {
+		return err
+	}
+	return gif.EncodeAll(output, animation)
+}
+
+// Draws a frame for the start of each turn of the replay (and, optionally,
+// after each of its actions) followed by the final state of the match.  Each
+// frame has a caption with the turn number, the current player and the health
+// of each player's base.
+//
+// If an action can't be applied, the remaining actions of that turn are not
+// drawn, the animation continues with the start of the next turn.
+func ReplayGIF(match osn.LegacyMatchWithReplay, options GIFOptions) (*gif.GIF, error) {
+	if len(match.Replay) == 0 {
+		return nil, osn.ErrEmptyReplay
+	}
+	legacymap, err := osn.MapByName(match.Replay[0].State.MapName)
+	if err != nil {
+		return nil, err
+	}
+	options = options.with_defaults()
+	canvas := new_canvas(legacymap, options.Radius)
+
+	animation := new(gif.GIF)
+	add_frame := func(state osn.OsnGameState, heading string, delay time.Duration) {
+		animation.Image = append(animation.Image, canvas.draw(&state, heading))
+		animation.Delay = append(animation.Delay, hundredths(delay))
+	}
+	for i, turn := range match.Replay {
+		heading := fmt.Sprintf("turn %d/%d", i+1, len(match.Replay))
+		add_frame(turn.State, heading, options.TurnDelay)
+		if !options.EveryAction {
+			continue
+		}
+		state := turn.State
+		for j, action := range turn.Actions {
+			state, err = osn.Apply(state, action)
+			if err != nil {
+				break
+			}
+			add_frame(state, fmt.Sprintf("%s action %d/%d",
+				heading, j+1, len(turn.Actions)), options.ActionDelay)
+		}
+	}
+	if len(match.OsnGameState.Settings) > 0 {
+		add_frame(match.OsnGameState, "final", options.FinalDelay)
+	} else {
+		animation.Delay[len(animation.Delay)-1] = hundredths(options.FinalDelay)
+	}
+	return animation, nil
+}
+
+// The dimensions and layout shared by every frame of an animation.
+type canvas struct {
+	legacymap osn.LegacyMap
+	hexes     layout
+	bounds    image.Rectangle
+	board_y   int // where the caption strip begins
+}
+
+func new_canvas(legacymap osn.LegacyMap, radius int) canvas {
+	hexes := layout{float64(radius), 4}
+	width, height := hexes.size(legacymap)
+	board_y := int(math.Ceil(height))
+	return canvas{legacymap, hexes,
+		image.Rect(0, 0, int(math.Ceil(width)), board_y+gif_caption),
+		board_y}
+}
+
+// Rasterizes the board in the state, with a caption beginning with the heading.
+func (frame canvas) draw(state *osn.OsnGameState, heading string) *image.Paletted {
+	img := image.NewPaletted(frame.bounds, gif_palette)
+	contents := new_board(frame.legacymap, state)
+	radius := frame.hexes.radius
+
+	for column := range frame.legacymap.Width {
+		for row := range frame.legacymap.Height {
+			coord := osn.HexCoord{Column: column, Row: row}
+			if fill, ok := contents.tile_color(coord); ok {
+				x, y := frame.hexes.center(coord)
+				fill_hex(img, x, y, radius, palette_index(fill), palette_index(gif_ink))
+			}
+		}
+	}
+
+	label_scale := max(1, int(radius)/8)
+	for _, tile := range frame.legacymap.Init {
+		if tile.Type != osn.MAPTILE_BASE {
+			continue
+		}
+		x, y := frame.hexes.center(osn.HexCoord{Column: tile.I, Row: tile.J})
+		hp, _ := contents.base_health(tile.Owner)
+		draw_centered(img, x, y, fmt.Sprint(hp), label_scale, palette_index(gif_white))
+	}
+
+	for _, unit := range state.Units {
+		x, y := frame.hexes.center(unit.Position())
+		fill := PlayerColors[unit.Color]
+		if unit.HasMoved || unit.HasAttacked {
+			fill = lighten(fill)
+		}
+		fill_circle(img, x, y, radius*0.62+1, palette_index(gif_white))
+		fill_circle(img, x, y, radius*0.62, palette_index(fill))
+		draw_centered(img, x, y, string(ClassGlyph(unit.Class)),
+			label_scale, palette_index(gif_white))
+		draw_centered(img, x, y-radius*0.62-3, string(RaceGlyph(unit.UnitRace)),
+			1, palette_index(gif_ink))
+		draw_centered(img, x, y+radius*0.62+3, fmt.Sprint(unit.Health),
+			1, palette_index(gif_ink))
+	}
+
+	frame.draw_caption(img, contents, heading)
+	return img
+}
+
+// The first line has the heading and the current player (or the winner), the
+// second line has each player's name and their base's health.
+func (frame canvas) draw_caption(img *image.Paletted, contents board, heading string) {
+	state := contents.state
+	ink := palette_index(gif_ink)
+	at := draw_text(img, image.Pt(6, frame.board_y+6), heading+"  ", 2, ink)
+	if state.Outcome != 0 {
+		draw_text(img, at, fmt.Sprintf("team %d wins", state.Outcome), 2, ink)
+	} else if current := int(state.CurrentPlayer); current < len(state.Settings) {
+		settings := state.Settings[current]
+		draw_text(img, at, settings.PlayerName, 2,
+			palette_index(PlayerColors[osn.PlayerColorEnum(settings.Color)]))
+	}
+
+	at = image.Pt(6, frame.board_y+22)
+	for _, settings := range state.Settings {
+		hp, _ := contents.base_health(osn.PlayerIndex(settings.PlayerID))
+		at = draw_text(img, at, settings.PlayerName, 2,
+			palette_index(PlayerColors[osn.PlayerColorEnum(settings.Color)]))
+		at = draw_text(img, at, fmt.Sprintf(" base %d   ", hp), 2, ink)
+	}
+}
+
+// Fills the flat-topped hex centered on the point, with a one-pixel outline.
+func fill_hex(img *image.Paletted, x, y, radius float64, fill, outline uint8) {
+	inside := func(dx, dy, radius float64) bool {
+		return dy <= radius*sqrt3/2 && sqrt3*dx+dy <= sqrt3*radius
+	}
+	bounds := image.Rect(
+		int(x-radius), int(y-radius), int(x+radius)+1, int(y+radius)+1).
+		Intersect(img.Rect)
+	for py := bounds.Min.Y; py < bounds.Max.Y; py++ {
+		for px := bounds.Min.X; px < bounds.Max.X; px++ {
+			dx := math.Abs(float64(px) + 0.5 - x)
+			dy := math.Abs(float64(py) + 0.5 - y)
+			if inside(dx, dy, radius-1) {
+				img.SetColorIndex(px, py, fill)
+			} else if inside(dx, dy, radius) {
+				img.SetColorIndex(px, py, outline)
+			}
+		}
+	}
+}
+
+func fill_circle(img *image.Paletted, x, y, radius float64, index uint8) {
+	bounds := image.Rect(
+		int(x-radius), int(y-radius), int(x+radius)+1, int(y+radius)+1).
+		Intersect(img.Rect)
+	for py := bounds.Min.Y; py < bounds.Max.Y; py++ {
+		for px := bounds.Min.X; px < bounds.Max.X; px++ {
+			dx := float64(px) + 0.5 - x
+			dy := float64(py) + 0.5 - y
+			if dx*dx+dy*dy <= radius*radius {
+				img.SetColorIndex(px, py, index)
+			}
+		}
+	}
+}
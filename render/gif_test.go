@@ -0,0 +1,102 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/render/gif_test.go
+
+package render_test
+
+import (
+	"bytes"
+	"image/gif"
+	"testing"
+	"time"
+
+	osn "github.com/kevindamm/wits-osn"
+	"github.com/kevindamm/wits-osn/render"
+)
+
+func TestReplayGIF(t *testing.T) {
+	match, _ := read_sample(t)
+	actions := 0
+	for _, turn := range match.Replay {
+		actions += len(turn.Actions)
+	}
+
+	cases := []struct {
+		name    string
+		options render.GIFOptions
+		frames  int
+		first   int
+		final   int
+	}{
+		{"turns", render.GIFOptions{}, len(match.Replay) + 1, 100, 300},
+		{"actions", render.GIFOptions{EveryAction: true, ActionDelay: 250 * time.Millisecond},
+			len(match.Replay) + actions + 1, 100, 300},
+		{"timing", render.GIFOptions{TurnDelay: 2 * time.Second, FinalDelay: 5 * time.Second},
+			len(match.Replay) + 1, 200, 500},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			var encoded bytes.Buffer
+			if err := render.WriteGIF(&encoded, match, tt.options); err != nil {
+				t.Fatal(err)
+			}
+			animation, err := gif.DecodeAll(&encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(animation.Image) != tt.frames {
+				t.Fatalf("expected %d frames, got %d", tt.frames, len(animation.Image))
+			}
+			if animation.Delay[0] != tt.first || animation.Delay[tt.frames-1] != tt.final {
+				t.Errorf("expected delays %d ... %d, got %v", tt.first, tt.final, animation.Delay)
+			}
+			if tt.options.EveryAction && animation.Delay[1] != 25 {
+				t.Errorf("expected action delay of 25, got %d", animation.Delay[1])
+			}
+		})
+	}
+}
+
+func TestReplayGIFColors(t *testing.T) {
+	match, _ := read_sample(t)
+	animation, err := render.ReplayGIF(match, render.GIFOptions{Radius: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := animation.Image[0]
+	for _, settings := range match.Settings {
+		expected := render.PlayerColors[osn.PlayerColorEnum(settings.Color)]
+		found := false
+		for _, index := range first.Pix {
+			if first.Palette[index] == expected {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected %s's color in the first frame", settings.PlayerName)
+		}
+	}
+
+	if _, err := render.ReplayGIF(osn.LegacyMatchWithReplay{}, render.GIFOptions{}); err != osn.ErrEmptyReplay {
+		t.Errorf("expected ErrEmptyReplay, got %v", err)
+	}
+}
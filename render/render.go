@@ -0,0 +1,244 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/render/render.go
+
+// Package render draws legacy maps and game states, for the terminal (as ANSI
+// text), as SVG documents and as animated GIFs of a replay.
+package render
+
+import (
+	"image/color"
+	"math"
+
+	osn "github.com/kevindamm/wits-osn"
+)
+
+// The colors that players' units, bases and spawns are drawn with.
+var PlayerColors = map[osn.PlayerColorEnum]color.RGBA{
+	osn.PLAYERCOLOR_UNKNOWN: {0x88, 0x88, 0x88, 0xff},
+	osn.PLAYERCOLOR_BLUE:    {0x2f, 0x6f, 0xd6, 0xff},
+	osn.PLAYERCOLOR_RED:     {0xd6, 0x3a, 0x2f, 0xff},
+	osn.PLAYERCOLOR_GREEN:   {0x2f, 0xa8, 0x4f, 0xff},
+	osn.PLAYERCOLOR_GOLD:    {0xd6, 0xa9, 0x2f, 0xff},
+}
+
+// The colors that each type of tile is drawn with.  Captured wit spaces and
+// spawn tiles are drawn with a lighter shade of their team's (or owner's) color.
+var TileColors = map[osn.MapTileType]color.RGBA{
+	osn.MAPTILE_FLOOR:   {0xd9, 0xcf, 0xb8, 0xff},
+	osn.MAPTILE_BLOCKED: {0x6b, 0x6b, 0x6b, 0xff},
+	osn.MAPTILE_WITS:    {0xf3, 0xd2, 0x50, 0xff},
+}
+
+// Mixes the color halfway to white.
+func lighten(shade color.RGBA) color.RGBA {
+	return color.RGBA{
+		uint8((uint(shade.R) + 0xff) / 2),
+		uint8((uint(shade.G) + 0xff) / 2),
+		uint8((uint(shade.B) + 0xff) / 2),
+		shade.A}
+}
+
+// A single-letter abbreviation of the unit class, used wherever a unit is drawn.
+func ClassGlyph(class osn.UnitClass) rune {
+	switch class {
+	case osn.CLASS_SOLDIER:
+		return 'S'
+	case osn.CLASS_RUNNER:
+		return 'R'
+	case osn.CLASS_HEAVY:
+		return 'H'
+	case osn.CLASS_SNIPER:
+		return 'N'
+	case osn.CLASS_MEDIC:
+		return 'M'
+	case osn.CLASS_SCRAMBLER:
+		return 'C'
+	case osn.CLASS_MOBI:
+		return 'O'
+	case osn.CLASS_BOMBSHELL:
+		return 'B'
+	case osn.CLASS_BRAMBLE:
+		return 'T'
+	}
+	return '?'
+}
+
+// A single-letter abbreviation of the race.
+func RaceGlyph(race osn.UnitRaceEnum) rune {
+	switch race {
+	case osn.RACE_FEEDBACK:
+		return 'f'
+	case osn.RACE_ADORABLES:
+		return 'a'
+	case osn.RACE_SCALLYWAGS:
+		return 's'
+	case osn.RACE_VEGGIENAUTS:
+		return 'v'
+	}
+	return '?'
+}
+
+// The contents of the board at each position, combining the map's layout with
+// a game state (which may be nil).
+type board struct {
+	legacymap osn.LegacyMap
+	state     *osn.OsnGameState
+
+	units    map[osn.HexCoord]osn.UnitStatus
+	captured map[osn.HexCoord]osn.TileType
+}
+
+func new_board(legacymap osn.LegacyMap, state *osn.OsnGameState) board {
+	contents := board{legacymap, state,
+		make(map[osn.HexCoord]osn.UnitStatus),
+		make(map[osn.HexCoord]osn.TileType)}
+	if state == nil {
+		return contents
+	}
+	for _, unit := range state.Units {
+		contents.units[unit.Position()] = unit
+	}
+	for _, tile := range state.CapturedTiles {
+		contents.captured[osn.HexCoord{Column: int(tile.I), Row: int(tile.J)}] = tile.Type
+	}
+	return contents
+}
+
+// The owner of the tile at the position, for base and spawn tiles.
+func (contents board) owner(coord osn.HexCoord) osn.PlayerIndex {
+	owner := osn.PlayerIndex(0)
+	for _, tile := range contents.legacymap.Init {
+		if tile.I == coord.Column && tile.J == coord.Row {
+			owner = tile.Owner
+		}
+	}
+	return owner
+}
+
+// The team of a player (by owner index), as found in the state's settings.
+// Without a state, each player is assumed to be on their own team.
+func (contents board) team(owner osn.PlayerIndex) uint {
+	if contents.state != nil {
+		for _, settings := range contents.state.Settings {
+			if osn.PlayerIndex(settings.PlayerID) == owner {
+				return settings.Team
+			}
+		}
+	}
+	return uint(owner)
+}
+
+// The color a player's pieces are drawn with.  Without a state, players are
+// given the colors in turn order.
+func (contents board) color(owner osn.PlayerIndex) osn.PlayerColorEnum {
+	if contents.state != nil {
+		for _, settings := range contents.state.Settings {
+			if osn.PlayerIndex(settings.PlayerID) == owner {
+				return osn.PlayerColorEnum(settings.Color)
+			}
+		}
+	}
+	if owner == 0 || owner >= osn.PlayerIndex(osn.PlayerColorRange) {
+		return osn.PLAYERCOLOR_UNKNOWN
+	}
+	return osn.PlayerColorEnum(owner)
+}
+
+// The color of the first player on the indicated team.
+func (contents board) team_color(team uint) osn.PlayerColorEnum {
+	if contents.state != nil {
+		for _, settings := range contents.state.Settings {
+			if settings.Team == team {
+				return osn.PlayerColorEnum(settings.Color)
+			}
+		}
+	}
+	return contents.color(osn.PlayerIndex(team))
+}
+
+// The fill color for the tile at the position, and false if it is off-board.
+func (contents board) tile_color(coord osn.HexCoord) (color.RGBA, bool) {
+	switch tile := contents.legacymap.TileAt(coord); tile {
+	case osn.MAPTILE_EMPTY:
+		return color.RGBA{}, false
+	case osn.MAPTILE_BASE:
+		return PlayerColors[contents.color(contents.owner(coord))], true
+	case osn.MAPTILE_SPAWN:
+		return lighten(PlayerColors[contents.color(contents.owner(coord))]), true
+	case osn.MAPTILE_WITS:
+		if team := contents.captured[coord].Team(); team != 0 {
+			return lighten(PlayerColors[contents.team_color(team)]), true
+		}
+		return TileColors[tile], true
+	default:
+		return TileColors[tile], true
+	}
+}
+
+// The health of the owner's base, or false if there is no state to show.
+func (contents board) base_health(owner osn.PlayerIndex) (osn.BaseHealth, bool) {
+	if contents.state == nil {
+		return 0, false
+	}
+	if contents.team(owner) == 2 {
+		return contents.state.Base1_HP, true
+	}
+	return contents.state.Base0_HP, true
+}
+
+// Pixel positions for flat-topped hexes of the indicated radius (center to
+// corner), as the columns of offset coordinates are laid out.
+type layout struct {
+	radius float64
+	margin float64
+}
+
+var sqrt3 = math.Sqrt(3)
+
+func (hexes layout) center(coord osn.HexCoord) (float64, float64) {
+	x := hexes.margin + hexes.radius*(1+1.5*float64(coord.Column))
+	y := hexes.margin + hexes.radius*sqrt3*(0.5+float64(coord.Row))
+	if coord.Column%2 == 1 {
+		y += hexes.radius * sqrt3 / 2
+	}
+	return x, y
+}
+
+// The corners of the hex, clockwise from its right-most corner.
+func (hexes layout) corners(coord osn.HexCoord) [6][2]float64 {
+	x, y := hexes.center(coord)
+	var corners [6][2]float64
+	for i := range corners {
+		angle := math.Pi / 3 * float64(i)
+		corners[i] = [2]float64{
+			x + hexes.radius*math.Cos(angle),
+			y + hexes.radius*math.Sin(angle)}
+	}
+	return corners
+}
+
+// The width and height needed to draw the whole map.
+func (hexes layout) size(legacymap osn.LegacyMap) (float64, float64) {
+	width := 2*hexes.margin + hexes.radius*(0.5+1.5*float64(legacymap.Width))
+	height := 2*hexes.margin + hexes.radius*sqrt3*(0.5+float64(legacymap.Height))
+	return width, height
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/render/svg.go
+
+package render
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"io"
+	"strings"
+
+	osn "github.com/kevindamm/wits-osn"
+)
+
+// The radius of each hex in an SVG, in pixels.  Documents can be scaled freely.
+const svg_radius = 24
+
+// Height of the caption below the board, when a game state is drawn.
+const svg_caption = 28
+
+// Writes an SVG document of the map.  If the state is not nil, its units, the
+// health of each base and the captured wit spaces are drawn as well, with a
+// caption listing each player's wits and base health.
+//
+// Units are circles in their player's color, labeled with their class glyph
+// (see [ClassGlyph]), with their race glyph above and their health below.
+// Units which have moved or attacked this turn are drawn faded.
+func WriteSVG(output io.Writer, legacymap osn.LegacyMap, state *osn.OsnGameState) error {
+	contents := new_board(legacymap, state)
+	hexes := layout{svg_radius, 4}
+	width, height := hexes.size(legacymap)
+	if state != nil {
+		height += svg_caption
+	}
+
+	svg := bufio.NewWriter(output)
+	fmt.Fprintf(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" viewBox="0 0 %.0f %.0f" font-family="sans-serif" text-anchor="middle">`+"\n",
+		width, height, width, height)
+	fmt.Fprintf(svg, "<title>%s</title>\n", escape(legacymap.Name))
+
+	// Tiles, then units over them.
+	fmt.Fprintln(svg, `<g stroke="#40403a" stroke-width="1">`)
+	for column := range legacymap.Width {
+		for row := range legacymap.Height {
+			coord := osn.HexCoord{Column: column, Row: row}
+			fill, ok := contents.tile_color(coord)
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(svg, `<polygon points="%s" fill="%s"/>`+"\n",
+				svg_points(hexes.corners(coord)), svg_color(fill))
+		}
+	}
+	fmt.Fprintln(svg, `</g>`)
+
+	fmt.Fprintln(svg, `<g font-weight="bold">`)
+	for _, tile := range legacymap.Init {
+		if tile.Type != osn.MAPTILE_BASE {
+			continue
+		}
+		x, y := hexes.center(osn.HexCoord{Column: tile.I, Row: tile.J})
+		label := "B"
+		if hp, ok := contents.base_health(tile.Owner); ok {
+			label = fmt.Sprint(hp)
+		}
+		fmt.Fprintf(svg, `<text x="%.1f" y="%.1f" font-size="%d" fill="white">%s</text>`+"\n",
+			x, y+svg_radius/3, svg_radius, label)
+	}
+	if state != nil {
+		for _, unit := range state.Units {
+			svg_unit(svg, hexes, unit)
+		}
+	}
+	fmt.Fprintln(svg, `</g>`)
+
+	if state != nil {
+		svg_status(svg, contents, width, height)
+	}
+	fmt.Fprintln(svg, `</svg>`)
+	return svg.Flush()
+}
+
+// Writes the SVG into a string, see [WriteSVG].
+func SVG(legacymap osn.LegacyMap, state *osn.OsnGameState) string {
+	var text strings.Builder
+	WriteSVG(&text, legacymap, state)
+	return text.String()
+}
+
+func svg_unit(svg io.Writer, hexes layout, unit osn.UnitStatus) {
+	x, y := hexes.center(unit.Position())
+	opacity := ""
+	if unit.HasMoved || unit.HasAttacked {
+		opacity = ` opacity="0.6"`
+	}
+	fmt.Fprintf(svg, `<g class="unit" id="unit-%d"%s>`+"\n", unit.Identifier, opacity)
+	fmt.Fprintf(svg, `<circle cx="%.1f" cy="%.1f" r="%.1f" fill="%s" stroke="white" stroke-width="1.5"/>`+"\n",
+		x, y, svg_radius*0.62, svg_color(PlayerColors[unit.Color]))
+	fmt.Fprintf(svg, `<text x="%.1f" y="%.1f" font-size="%d" fill="white">%c</text>`+"\n",
+		x, y+svg_radius/4, svg_radius*3/4, ClassGlyph(unit.Class))
+	fmt.Fprintf(svg, `<text x="%.1f" y="%.1f" font-size="%d" fill="#40403a">%c</text>`+"\n",
+		x, y-svg_radius*0.68, svg_radius/3, RaceGlyph(unit.UnitRace))
+	fmt.Fprintf(svg, `<text x="%.1f" y="%.1f" font-size="%d" fill="#40403a">%d</text>`+"\n",
+		x, y+svg_radius*0.92, svg_radius/3, unit.Health)
+	fmt.Fprintln(svg, `</g>`)
+}
+
+// A caption with each player's name, wits and base health.
+func svg_status(svg io.Writer, contents board, width, height float64) {
+	state := contents.state
+	players := make([]string, len(state.Settings))
+	for i, settings := range state.Settings {
+		hp, _ := contents.base_health(osn.PlayerIndex(settings.PlayerID))
+		current := ""
+		if i == int(state.CurrentPlayer) && state.Outcome == 0 {
+			current = ` text-decoration="underline"`
+		}
+		players[i] = fmt.Sprintf(`<tspan fill="%s"%s>%s</tspan> wits %d base %d`,
+			svg_color(PlayerColors[osn.PlayerColorEnum(settings.Color)]), current,
+			escape(settings.PlayerName), settings.ActionPoints, hp)
+	}
+	caption := strings.Join(players, " — ")
+	if state.Outcome != 0 {
+		caption += fmt.Sprintf(" — team %d wins", state.Outcome)
+	}
+	fmt.Fprintf(svg, `<text class="status" x="%.1f" y="%.1f" font-size="14" fill="#40403a">%s</text>`+"\n",
+		width/2, height-svg_caption/2+5, caption)
+}
+
+func svg_points(corners [6][2]float64) string {
+	points := make([]string, len(corners))
+	for i, corner := range corners {
+		points[i] = fmt.Sprintf("%.1f,%.1f", corner[0], corner[1])
+	}
+	return strings.Join(points, " ")
+}
+
+func svg_color(shade color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", shade.R, shade.G, shade.B)
+}
+
+func escape(text string) string {
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(text))
+	return escaped.String()
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/render/svg_test.go
+
+package render_test
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+
+	osn "github.com/kevindamm/wits-osn"
+	"github.com/kevindamm/wits-osn/render"
+)
+
+// Counts the elements of each name, and checks that the document is well-formed.
+func count_elements(t *testing.T, svg string) map[string]int {
+	counts := make(map[string]int)
+	decoder := xml.NewDecoder(strings.NewReader(svg))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			counts[start.Name.Local] += 1
+			for _, attr := range start.Attr {
+				if attr.Name.Local == "class" {
+					counts["."+attr.Value] += 1
+				}
+			}
+		}
+	}
+	return counts
+}
+
+func TestSVGMap(t *testing.T) {
+	_, legacymap := read_sample(t)
+	svg := render.SVG(legacymap, nil)
+	counts := count_elements(t, svg)
+
+	tiles := 0
+	for column := range legacymap.Width {
+		for row := range legacymap.Height {
+			if legacymap.TileAt(osn.HexCoord{Column: column, Row: row}) != osn.MAPTILE_EMPTY {
+				tiles += 1
+			}
+		}
+	}
+	if counts["polygon"] != tiles {
+		t.Errorf("expected %d hexes, got %d", tiles, counts["polygon"])
+	}
+	if counts[".unit"] != 0 || counts[".status"] != 0 {
+		t.Errorf("expected no units or status without a state, got %v", counts)
+	}
+	if !strings.Contains(svg, "<title>Sweet Tooth</title>") {
+		t.Error("expected the map's name as the title")
+	}
+}
+
+func TestSVGState(t *testing.T) {
+	match, legacymap := read_sample(t)
+	state := match.OsnGameState
+	state.Settings = append([]osn.OsnRoleSettings{}, state.Settings...)
+	state.Settings[0].PlayerName = "<Darth & Mickey>"
+	svg := render.SVG(legacymap, &state)
+	counts := count_elements(t, svg)
+
+	if counts[".unit"] != len(state.Units) {
+		t.Errorf("expected %d units, got %d", len(state.Units), counts[".unit"])
+	}
+	if counts[".status"] != 1 {
+		t.Errorf("expected a status caption, got %d", counts[".status"])
+	}
+	for _, expected := range []string{"&lt;Darth &amp; Mickey&gt;", "team 2 wins",
+		`fill="#2f6fd6"`, `fill="#d63a2f"`} {
+		if !strings.Contains(svg, expected) {
+			t.Errorf("expected %s in the SVG", expected)
+		}
+	}
+}
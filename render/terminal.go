@@ -0,0 +1,151 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/render/terminal.go
+
+package render
+
+import (
+	"fmt"
+	"strings"
+
+	osn "github.com/kevindamm/wits-osn"
+)
+
+// ANSI foreground colors for each player color, and for the board itself.
+var ansi_colors = map[osn.PlayerColorEnum]string{
+	osn.PLAYERCOLOR_UNKNOWN: "37",
+	osn.PLAYERCOLOR_BLUE:    "34",
+	osn.PLAYERCOLOR_RED:     "31",
+	osn.PLAYERCOLOR_GREEN:   "32",
+	osn.PLAYERCOLOR_GOLD:    "33",
+}
+
+const (
+	ansi_terrain = "90" // dark gray
+	ansi_neutral = "35" // magenta, for uncaptured wit spaces
+)
+
+// Draws the map (and the state, if not nil) as lines of text.  Columns of hexes
+// alternate between the even and odd lines, the odd columns a half-row lower,
+// as they are offset on the map.  Each hex shows:
+//
+//	S3.  a unit's class, health and "." if it has moved or attacked
+//	[5]  a base and its health
+//	 +   a spawn tile
+//	 *   a wit space, colored by the team that captured it
+//	###  blocked terrain
+//	 .   open floor
+//
+// Units are drawn in the color of their player.  Unless ansi is true the text
+// has no color escapes.  The players' wits and base health follow the board.
+func Terminal(legacymap osn.LegacyMap, state *osn.OsnGameState, ansi bool) string {
+	contents := new_board(legacymap, state)
+	var text strings.Builder
+
+	for line := range 2*legacymap.Height + 1 {
+		row_text := make([]string, 0, legacymap.Width)
+		for column := range legacymap.Width {
+			row := line - column%2
+			if row%2 != 0 || row/2 >= legacymap.Height || row < 0 {
+				row_text = append(row_text, "   ")
+				continue
+			}
+			cell, color := contents.terminal_cell(osn.HexCoord{Column: column, Row: row / 2})
+			if ansi && color != "" {
+				cell = fmt.Sprintf("\x1b[%sm%s\x1b[0m", color, cell)
+			}
+			row_text = append(row_text, cell)
+		}
+		text.WriteString(strings.TrimRight(strings.Join(row_text, " "), " "))
+		text.WriteString("\n")
+	}
+
+	if state != nil {
+		text.WriteString(contents.terminal_status(ansi))
+	}
+	return text.String()
+}
+
+// The three characters drawn for the hex, and their ANSI color.
+func (contents board) terminal_cell(coord osn.HexCoord) (string, string) {
+	if unit, ok := contents.units[coord]; ok {
+		exhausted := " "
+		if unit.HasMoved || unit.HasAttacked {
+			exhausted = "."
+		}
+		return fmt.Sprintf("%c%d%s", ClassGlyph(unit.Class), unit.Health, exhausted),
+			"1;" + ansi_colors[unit.Color]
+	}
+
+	switch contents.legacymap.TileAt(coord) {
+	case osn.MAPTILE_BASE:
+		owner := contents.owner(coord)
+		color := ansi_colors[contents.color(owner)]
+		if hp, ok := contents.base_health(owner); ok {
+			return fmt.Sprintf("[%d]", hp), color
+		}
+		return "[B]", color
+	case osn.MAPTILE_SPAWN:
+		return " + ", ansi_colors[contents.color(contents.owner(coord))]
+	case osn.MAPTILE_WITS:
+		if team := contents.captured[coord].Team(); team != 0 {
+			return " * ", ansi_colors[contents.team_color(team)]
+		}
+		return " * ", ansi_neutral
+	case osn.MAPTILE_BLOCKED:
+		return "###", ansi_terrain
+	case osn.MAPTILE_FLOOR:
+		return " . ", ansi_terrain
+	}
+	return "   ", ""
+}
+
+// A line for each player, then the wit spaces held by each team.
+func (contents board) terminal_status(ansi bool) string {
+	state := contents.state
+	var text strings.Builder
+	for i, settings := range state.Settings {
+		marker := " "
+		if i == int(state.CurrentPlayer) && state.Outcome == 0 {
+			marker = ">"
+		}
+		owner := osn.PlayerIndex(settings.PlayerID)
+		hp, _ := contents.base_health(owner)
+		name := settings.PlayerName
+		if ansi {
+			name = fmt.Sprintf("\x1b[1;%sm%s\x1b[0m",
+				ansi_colors[osn.PlayerColorEnum(settings.Color)], name)
+		}
+		fmt.Fprintf(&text, "%s %s (%s, team %d)  wits %d  base %d\n",
+			marker, name, settings.UnitRace, settings.Team, settings.ActionPoints, hp)
+	}
+
+	held := make(map[uint]int)
+	for _, tile := range state.CapturedTiles {
+		held[tile.Type.Team()] += 1
+	}
+	fmt.Fprintf(&text, "  wit spaces: team 1 %d, team 2 %d, neutral %d\n",
+		held[1], held[2], held[0])
+	if state.Outcome != 0 {
+		fmt.Fprintf(&text, "  team %d wins\n", state.Outcome)
+	}
+	return text.String()
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/render/terminal_test.go
+
+package render_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	osn "github.com/kevindamm/wits-osn"
+	"github.com/kevindamm/wits-osn/render"
+)
+
+const testReplayPath = "../testdata/ahRzfm91dHdpdHRlcnNnYW1lLWhyZHIVCxIIR2FtZVJvb20YgIDQlK_hqAoM.json"
+
+func read_sample(t *testing.T) (osn.LegacyMatchWithReplay, osn.LegacyMap) {
+	filedata, err := os.ReadFile(testReplayPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	match, err := osn.DecodeReplay(filedata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacymap, err := osn.MapByName(match.MapName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return match, legacymap
+}
+
+func TestTerminalMap(t *testing.T) {
+	_, legacymap := read_sample(t)
+	text := render.Terminal(legacymap, nil, false)
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	if len(lines) != 2*legacymap.Height+1 {
+		t.Errorf("expected %d lines, got %d", 2*legacymap.Height+1, len(lines))
+	}
+	if strings.Count(text, "[B]") != 2 || strings.Count(text, "+") != 2 {
+		t.Errorf("expected two bases and two spawns\n%s", text)
+	}
+	if strings.Contains(text, "\x1b[") {
+		t.Error("expected no ANSI escapes in plain text")
+	}
+
+	// The base of the first player is in the upper right: column 11, row 1.
+	// Odd columns are drawn on the line below their row's even columns.
+	line := lines[2*1+1]
+	if column := strings.Index(line, "[B]"); column != 11*4 {
+		t.Errorf("expected the base at text column %d, got %d:\n%s", 11*4, column, line)
+	}
+}
+
+func TestTerminalState(t *testing.T) {
+	match, legacymap := read_sample(t)
+	state := match.Replay[0].State
+	text := render.Terminal(legacymap, &state, false)
+	for _, expected := range []string{"[5]", "M1", "H4", "N1", "S3",
+		"> DarthMickeyJJ (Feedback, team 1)  wits 0  base 5",
+		"wit spaces: team 1 0, team 2 0, neutral 4"} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("expected %q in\n%s", expected, text)
+		}
+	}
+
+	colored := render.Terminal(legacymap, &state, true)
+	if !strings.Contains(colored, "\x1b[1;34mS3 \x1b[0m") {
+		t.Errorf("expected a blue soldier in\n%s", colored)
+	}
+	if !strings.Contains(render.Terminal(legacymap, &match.OsnGameState, false), "team 2 wins") {
+		t.Error("expected the outcome in the final state")
+	}
+}
@@ -27,24 +27,21 @@ import (
 	"log"
 )
 
-// Contains both the metadata (as LegacyMatch) and player turns (as ReplayData).
+// Contains both the metadata (as LegacyMatch) and player turns (as OsnReplay).
+// The final game state (including its GameOverData) is the embedded state.
 type LegacyMatchWithReplay struct {
 	LegacyMatch
 	OsnGameState
 
-	Terminal GameOverData `json:"gameOverData,omitempty"`
-	Replay   OsnReplay    `json:"replay"`
+	Replay OsnReplay `json:"replay"`
 }
 
-type OsnReplay []OsnReplayData
-
-// ReplayData is a sequence of player turns, each composed of spawns & actions.
+// OsnReplay is a sequence of player turns, each composed of spawns & actions.
 //
 // The player turn is composed of a partially-ordered sequence of actions, the
 // actions themselves are polymorphic under the interface type OsnPlayerAction.
-type OsnReplayData struct {
-	Turns []OsnPlayerTurn
-}
+// On the wire, it is a flat sequence of frames, see [OsnReplay.UnmarshalJSON].
+type OsnReplay []OsnPlayerTurn
 
 type OsnGameState struct {
 	CapturedTiles []TileState `json:"captureTileStates"`
@@ -53,15 +50,21 @@ type OsnGameState struct {
 
 	Base0_HP BaseHealth `json:"hp_base0"`
 	Base1_HP BaseHealth `json:"hp_base1"`
-	Outcome  GameStatus `json:"outcome,omitempty"`
+	Outcome  GameStatus `json:"outcome"`
 
-	MapName  string          `json:"mapName"`
-	MapTheme string          `json:"mapTheme"`
-	Settings OsnRoleSettings `json:"settings"`
+	// Only present in the final state of a match.
+	Terminal *GameOverData `json:"gameOverData,omitempty"`
 
-	TurnCount  int          `json:"turnCount,omitempty"`
+	MapName  string            `json:"mapName"`
+	MapTheme string            `json:"mapTheme"`
+	Settings []OsnRoleSettings `json:"settings"`
+
+	TurnCount  int          `json:"turnCount"`
 	Units      []UnitStatus `json:"units"`
-	UsedSpawns []UsedSpawn  `json:"usedSpawns,omitempty"`
+	UsedSpawns []UsedSpawn  `json:"usedSpawns"`
+
+	// Transient state between actions of the same turn, see [Apply].
+	turn turnContext
 }
 
 type GameStatus int
@@ -85,8 +88,10 @@ type outer struct {
 	RoomID  string `json:"room"`      // The game (and game replay) identifier.
 }
 
+// The outer gameState is a string encoding of this, whose gameState is the
+// match (see [UnwrapReplay]).
 type inner struct {
-	Wrapper string `json:"gameState"`
+	Wrapper json.RawMessage `json:"gameState"`
 }
 
 func ParseRawReplay(filedata []byte) (string, []byte, error) {
@@ -101,14 +106,48 @@ func ParseRawReplay(filedata []byte) (string, []byte, error) {
 	if err != nil {
 		return on_wire.Wrapper.RoomID, []byte{}, err
 	}
-	err = json.Unmarshal([]byte(gamestate.Wrapper), &replay)
+	err = json.Unmarshal(gamestate.Wrapper, &replay)
 	if err != nil {
 		return on_wire.Wrapper.RoomID, []byte{}, err
 	}
-	if replay.MatchHash != GameID(on_wire.Wrapper.RoomID) {
+	if replay.MatchHash == UNKNOWN_MATCH_ID {
+		replay.MatchHash = GameID(on_wire.Wrapper.RoomID)
+	} else if replay.MatchHash != GameID(on_wire.Wrapper.RoomID) {
 		log.Printf("found MatchID (%s) different from its room ID (%s)",
 			replay.MatchHash, on_wire.Wrapper.RoomID)
 	}
 	bytes, err := json.Marshal(replay)
 	return on_wire.Wrapper.RoomID, bytes, err
 }
+
+// Decodes the match and all of its turns from the doubly-wrapped wire format.
+func UnwrapReplay(filedata []byte) (LegacyMatchWithReplay, error) {
+	var on_wire WireFormat
+	var gamestate inner
+	var match LegacyMatchWithReplay
+	if err := json.Unmarshal(filedata, &on_wire); err != nil {
+		return match, err
+	}
+	err := json.Unmarshal([]byte(on_wire.Wrapper.Wrapper), &gamestate)
+	if err != nil {
+		return match, err
+	}
+	err = json.Unmarshal(gamestate.Wrapper, &match)
+	if match.MatchHash == UNKNOWN_MATCH_ID {
+		match.MatchHash = GameID(on_wire.Wrapper.RoomID)
+	}
+	return match, err
+}
+
+// Decodes a replay file which is either in its wire format (as fetched) or is
+// the encoding of an already-unwrapped [LegacyMatchWithReplay].
+func DecodeReplay(filedata []byte) (LegacyMatchWithReplay, error) {
+	var on_wire WireFormat
+	if err := json.Unmarshal(filedata, &on_wire); err == nil &&
+		on_wire.Wrapper.Wrapper != "" {
+		return UnwrapReplay(filedata)
+	}
+	var match LegacyMatchWithReplay
+	err := json.Unmarshal(filedata, &match)
+	return match, err
+}
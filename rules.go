@@ -0,0 +1,625 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/rules.go
+
+package osn
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// The reasons an action may be rejected by [Apply].  These are wrapped by an
+// [IllegalActionError] so that they can be matched with errors.Is().
+var (
+	ErrGameOver           = errors.New("the match is already over")
+	ErrUnsupportedAction  = errors.New("unsupported action")
+	ErrOutOfSequence      = errors.New("action out of sequence")
+	ErrUnknownUnit        = errors.New("no such unit")
+	ErrNotOwner           = errors.New("unit belongs to another player")
+	ErrWrongClass         = errors.New("unit cannot perform this action")
+	ErrAlreadyMoved       = errors.New("unit has already moved")
+	ErrAlreadyAttacked    = errors.New("unit has already attacked")
+	ErrAlreadyTransformed = errors.New("unit has already transformed")
+	ErrUnreachable        = errors.New("destination is unreachable")
+	ErrInvalidTarget      = errors.New("no valid target")
+	ErrInvalidSpawn       = errors.New("spawn tile unavailable")
+	ErrInsufficientWits   = errors.New("not enough wits")
+)
+
+// An action which could not be applied to the game state, and why.
+type IllegalActionError struct {
+	Action OsnPlayerAction
+	Err    error
+}
+
+func (err IllegalActionError) Error() string {
+	return fmt.Sprintf("illegal %s: %s", err.Action.Name(), err.Err)
+}
+
+func (err IllegalActionError) Unwrap() error {
+	return err.Err
+}
+
+func illegal(action OsnPlayerAction, reason error, format string, args ...any) error {
+	return IllegalActionError{action,
+		fmt.Errorf("%w: %s", reason, fmt.Sprintf(format, args...))}
+}
+
+// The wit economy and base health, as seen in the sample replay in testdata
+// (see TestWitEconomyInSampleReplay).  That match has two players, so the bonus
+// has not been confirmed for the third and fourth players of a 2v2 match.
+const (
+	// Every player receives this many wits at the start of their turn, plus one
+	// for each wit space captured by their team.  Unspent wits are kept.
+	wits_per_turn = 5
+
+	// Players after the first receive extra wits on their first turn.
+	first_turn_bonus = 3
+
+	// Both bases start the match with this much health.
+	base_health BaseHealth = 5
+)
+
+// Bookkeeping within a turn that is not part of the recorded game state.
+type turnContext struct {
+	// The spawn tile chosen by the latest SelectSpawnTileAction.
+	spawn *HexCoord
+
+	// A unit held by a mobi between its EatAction and SpitAction.
+	eaten *UnitStatus
+
+	// Units which have paid for their move-and-attack this turn.  Moving and
+	// attacking (or casting a spell) with the same unit costs a single wit.
+	activated []uint
+}
+
+// Applies the action to the game state, returning the resulting state.  The
+// provided state is not modified; if the action is illegal the error will be
+// an [IllegalActionError] and the original state is returned.
+//
+// Wits are tracked in the ActionPoints of the current player's settings, the
+// same value that [PlayerRole] keeps as its Actions.
+func Apply(state OsnGameState, action OsnPlayerAction) (OsnGameState, error) {
+	if state.Outcome != 0 {
+		return state, illegal(action, ErrGameOver, "outcome %d", state.Outcome)
+	}
+	if int(state.CurrentPlayer) >= len(state.Settings) {
+		return state, fmt.Errorf("current player %d has no settings", state.CurrentPlayer)
+	}
+	legacymap, err := MapByName(state.MapName)
+	if err != nil {
+		return state, err
+	}
+
+	next := state.clone()
+	game := game_rules{&next, legacymap}
+	switch action := action.(type) {
+	case *StartTurnAction:
+		err = game.start_turn()
+	case *EndTurnAction:
+		err = game.end_turn(action)
+	case *SelectUnitAction:
+		// Selecting a unit only affects the UI.
+	case *MoveUnitAction:
+		err = game.move(action)
+	case *RangeAttackAction:
+		err = game.attack(action)
+	case *ActiveHealAction:
+		err = game.heal(action)
+	case *TransformAction:
+		err = game.transform(action)
+	case *EatAction:
+		err = game.eat(action)
+	case *SpitAction:
+		err = game.spit(action)
+	case *ScramblerSpellAction:
+		err = game.scramble(action)
+	case *SelectSpawnTileAction:
+		err = game.select_spawn(action)
+	case *SpawnUnitAction:
+		err = game.spawn(action)
+	default:
+		err = illegal(action, ErrUnsupportedAction, "%T", action)
+	}
+	if err != nil {
+		return state, err
+	}
+
+	sort_units(next.Units)
+	return next, nil
+}
+
+// A copy of the state which shares no (mutable) slices with the original.
+func (state OsnGameState) clone() OsnGameState {
+	state.CapturedTiles = slices.Clone(state.CapturedTiles)
+	state.Settings = slices.Clone(state.Settings)
+	state.Units = slices.Clone(state.Units)
+	state.UsedSpawns = slices.Clone(state.UsedSpawns)
+	state.turn.activated = slices.Clone(state.turn.activated)
+	return state
+}
+
+// Units are listed in column-major order of their position.
+func sort_units(units []UnitStatus) {
+	slices.SortStableFunc(units, func(a, b UnitStatus) int {
+		return compare_coords(a.Position(), b.Position())
+	})
+}
+
+type game_rules struct {
+	*OsnGameState
+	legacymap LegacyMap
+}
+
+func (game game_rules) player() *OsnRoleSettings {
+	return &game.Settings[game.CurrentPlayer]
+}
+
+// Units are owned by the player whose settings `id` matches the unit's owner.
+func (game game_rules) owner() uint {
+	return uint(game.player().PlayerID)
+}
+
+func (game game_rules) team_of(owner PlayerIndex) uint {
+	for _, settings := range game.Settings {
+		if PlayerIndex(settings.PlayerID) == owner {
+			return settings.Team
+		}
+	}
+	return uint(owner)
+}
+
+func (game game_rules) pay(action OsnPlayerAction, cost uint) error {
+	player := game.player()
+	if player.ActionPoints < cost {
+		return illegal(action, ErrInsufficientWits,
+			"costs %d, %d available", cost, player.ActionPoints)
+	}
+	player.ActionPoints -= cost
+	return nil
+}
+
+// The cost of a move or attack, which is shared by each unit's first of either.
+func (game game_rules) activation_cost(unit *UnitStatus) uint {
+	if slices.Contains(game.turn.activated, unit.Identifier) {
+		return 0
+	}
+	return 1
+}
+
+func (game game_rules) activate(unit *UnitStatus) {
+	if !slices.Contains(game.turn.activated, unit.Identifier) {
+		game.turn.activated = append(game.turn.activated, unit.Identifier)
+	}
+}
+
+func (game game_rules) unit_at(coord HexCoord) *UnitStatus {
+	for i := range game.Units {
+		if game.Units[i].Position() == coord {
+			return &game.Units[i]
+		}
+	}
+	return nil
+}
+
+func (game game_rules) remove_unit(unit *UnitStatus) {
+	id := unit.Identifier
+	game.Units = slices.DeleteFunc(game.Units, func(other UnitStatus) bool {
+		return other.Identifier == id
+	})
+}
+
+// Finds the current player's unit with the indicated pawn ID.
+func (game game_rules) own_unit(action OsnPlayerAction, pawnID int) (*UnitStatus, UnitStats, error) {
+	for i := range game.Units {
+		unit := &game.Units[i]
+		if unit.Identifier != uint(pawnID) {
+			continue
+		}
+		if unit.Owner != game.owner() {
+			return nil, UnitStats{}, illegal(action, ErrNotOwner,
+				"unit %d owned by %d", pawnID, unit.Owner)
+		}
+		stats, err := unit.Stats()
+		if err != nil {
+			return nil, stats, illegal(action, ErrWrongClass, "%s", err)
+		}
+		return unit, stats, nil
+	}
+	return nil, UnitStats{}, illegal(action, ErrUnknownUnit, "pawn %d", pawnID)
+}
+
+// Units ending their movement on a wit space capture it for their team.
+func (game game_rules) capture(coord HexCoord, team uint) {
+	if game.legacymap.TileAt(coord) != MAPTILE_WITS {
+		return
+	}
+	for i, tile := range game.CapturedTiles {
+		if int(tile.I) == coord.Column && int(tile.J) == coord.Row {
+			game.CapturedTiles[i].Type = TileCapturedBy(team)
+			return
+		}
+	}
+	game.CapturedTiles = append(game.CapturedTiles, TileState{
+		uint(coord.Column), uint(coord.Row), TileCapturedBy(team)})
+}
+
+func (game game_rules) start_turn() error {
+	player := game.player()
+	income := uint(wits_per_turn)
+	for _, tile := range game.CapturedTiles {
+		if tile.Type.Team() == player.Team {
+			income++
+		}
+	}
+	if game.CurrentPlayer > 0 && game.TurnCount <= len(game.Settings) {
+		income += first_turn_bonus
+	}
+	player.ActionPoints += income
+
+	game.UsedSpawns = []UsedSpawn{}
+	game.turn = turnContext{}
+	return nil
+}
+
+func (game game_rules) end_turn(action *EndTurnAction) error {
+	if game.turn.eaten != nil {
+		return illegal(action, ErrOutOfSequence,
+			"unit %d is still eaten", game.turn.eaten.Identifier)
+	}
+	game.CurrentPlayer = PlayerIndex((int(game.CurrentPlayer) + 1) % len(game.Settings))
+	game.TurnCount++
+	game.turn = turnContext{}
+
+	// Units are readied at the end of the previous turn, so the state shows
+	// what the last player did until the next player's units have acted.
+	owner := game.owner()
+	for i := range game.Units {
+		unit := &game.Units[i]
+		if unit.Owner == owner {
+			ready(unit)
+		}
+	}
+	return nil
+}
+
+func ready(unit *UnitStatus) {
+	unit.HasMoved = false
+	unit.HasAttacked = false
+	unit.HasTransformed = false
+}
+
+func (game game_rules) move(action *MoveUnitAction) error {
+	unit, stats, err := game.own_unit(action, action.PawnID)
+	if err != nil {
+		return err
+	}
+	if action.Cancelled {
+		// The move was previewed but not committed.
+		return nil
+	}
+	if unit.HasMoved {
+		return illegal(action, ErrAlreadyMoved, "unit %d", unit.Identifier)
+	}
+	dest := HexCoord{action.DestI, action.DestJ}
+	movement := NewMovementRange(game.legacymap, game.Units, *unit, int(stats.MoveRange))
+	if !movement.CanReach(dest) {
+		return illegal(action, ErrUnreachable,
+			"unit %d from %v to %v", unit.Identifier, unit.Position(), dest)
+	}
+	if err := game.pay(action, game.activation_cost(unit)); err != nil {
+		return err
+	}
+
+	game.activate(unit)
+	unit.PositionI, unit.PositionJ = uint(dest.Column), uint(dest.Row)
+	unit.HasMoved = true
+	game.capture(dest, unit.Team)
+	return nil
+}
+
+func (game game_rules) attack(action *RangeAttackAction) error {
+	unit, stats, err := game.own_unit(action, action.PawnID)
+	if err != nil {
+		return err
+	}
+	if unit.HasAttacked {
+		return illegal(action, ErrAlreadyAttacked, "unit %d", unit.Identifier)
+	}
+	if stats.Damage == 0 {
+		return illegal(action, ErrWrongClass, "%s cannot attack", unit.Class)
+	}
+	dest := HexCoord{action.DestI, action.DestJ}
+	attack, err := NewAttackRange(game.legacymap, game.Units, *unit)
+	if err != nil {
+		return illegal(action, ErrWrongClass, "%s", err)
+	}
+	if !attack.CanTarget(dest) {
+		return illegal(action, ErrInvalidTarget,
+			"unit %d from %v at %v", unit.Identifier, unit.Position(), dest)
+	}
+
+	target := game.unit_at(dest)
+	if target == nil {
+		// Attacking a base always costs a wit, even after moving.
+		if err := game.pay(action, 1); err != nil {
+			return err
+		}
+		owner, _ := game.legacymap.BaseAt(dest)
+		game.damage_base(game.team_of(owner), stats.Damage, unit.Team)
+	} else {
+		if err := game.pay(action, game.activation_cost(unit)); err != nil {
+			return err
+		}
+		if target.Health <= stats.Damage {
+			origin := unit.Position()
+			game.remove_unit(target)
+			unit = game.unit_at(origin)
+		} else {
+			target.Health -= stats.Damage
+		}
+	}
+
+	game.activate(unit)
+	unit.HasAttacked = true
+	return nil
+}
+
+// Bases belong to teams, the first team's base health is in Base0_HP.
+func (game game_rules) damage_base(team uint, damage uint, attacker uint) {
+	hp := &game.Base0_HP
+	if team == 2 {
+		hp = &game.Base1_HP
+	}
+	if uint(*hp) <= damage {
+		*hp = 0
+		game.Outcome = GameStatus(attacker)
+		// The match ends without ending the turn, but other players' units are
+		// still readied as they would be at the end of the turn.
+		for i := range game.Units {
+			if game.Units[i].Team != attacker {
+				ready(&game.Units[i])
+			}
+		}
+	} else {
+		*hp -= BaseHealth(damage)
+	}
+}
+
+// Medics heal an adjacent ally by one, up to one point above its maximum.
+// Scramblers are unaffected, though the heal is still spent.
+func (game game_rules) heal(action *ActiveHealAction) error {
+	unit, stats, err := game.own_unit(action, action.PawnID)
+	if err != nil {
+		return err
+	}
+	if unit.Class != CLASS_MEDIC {
+		return illegal(action, ErrWrongClass, "%s cannot heal", unit.Class)
+	}
+	if unit.HasAttacked {
+		return illegal(action, ErrAlreadyAttacked, "unit %d", unit.Identifier)
+	}
+	dest := HexCoord{action.DestI, action.DestJ}
+	target := game.unit_at(dest)
+	if target == nil || target == unit || target.Team != unit.Team ||
+		unit.Position().Distance(dest) > int(stats.AttackRange) {
+		return illegal(action, ErrInvalidTarget, "no ally at %v", dest)
+	}
+	target_stats, err := target.Stats()
+	if err != nil {
+		return illegal(action, ErrInvalidTarget, "%s", err)
+	}
+	if target.Health > target_stats.Health {
+		return illegal(action, ErrInvalidTarget, "unit %d already healed", target.Identifier)
+	}
+	if err := game.pay(action, 1); err != nil {
+		return err
+	}
+
+	if target.Class != CLASS_SCRAMBLER {
+		target.Health++
+	}
+	unit.HasAttacked = true
+	return nil
+}
+
+// Scallywag units switch between their two forms, each with its own health.
+func (game game_rules) transform(action *TransformAction) error {
+	unit, stats, err := game.own_unit(action, action.PawnID)
+	if err != nil {
+		return err
+	}
+	base, err := UnitStatsFor(unit.UnitRace, unit.Class)
+	if err != nil || base.Alt == nil {
+		return illegal(action, ErrWrongClass, "%s cannot transform", unit.Class)
+	}
+	if unit.HasTransformed {
+		return illegal(action, ErrAlreadyTransformed, "unit %d", unit.Identifier)
+	}
+	if err := game.pay(action, 1); err != nil {
+		return err
+	}
+
+	alt := base.Alt
+	if unit.IsAlt {
+		alt = &base
+	}
+	health := unit.AltHealth
+	if health == 0 {
+		health = alt.Health
+	}
+	unit.AltHealth = min(unit.Health, stats.Health)
+	unit.Health = health
+	unit.IsAlt = !unit.IsAlt
+	unit.HasTransformed = true
+	return nil
+}
+
+// Mobis swallow an ally within range, to be spat out beside them.
+func (game game_rules) eat(action *EatAction) error {
+	unit, stats, err := game.own_unit(action, action.PawnID)
+	if err != nil {
+		return err
+	}
+	if unit.Class != CLASS_MOBI {
+		return illegal(action, ErrWrongClass, "%s cannot eat", unit.Class)
+	}
+	if unit.HasAttacked {
+		return illegal(action, ErrAlreadyAttacked, "unit %d", unit.Identifier)
+	}
+	if game.turn.eaten != nil {
+		return illegal(action, ErrOutOfSequence,
+			"unit %d already eaten", game.turn.eaten.Identifier)
+	}
+	dest := HexCoord{action.DestI, action.DestJ}
+	target := game.unit_at(dest)
+	if target == nil || target == unit || target.Team != unit.Team ||
+		unit.Position().Distance(dest) > int(stats.AttackRange) {
+		return illegal(action, ErrInvalidTarget, "no ally at %v", dest)
+	}
+	if err := game.pay(action, 1); err != nil {
+		return err
+	}
+
+	unit.HasAttacked = true
+	eaten := *target
+	game.turn.eaten = &eaten
+	game.remove_unit(target)
+	return nil
+}
+
+func (game game_rules) spit(action *SpitAction) error {
+	unit, _, err := game.own_unit(action, action.PawnID)
+	if err != nil {
+		return err
+	}
+	if game.turn.eaten == nil {
+		return illegal(action, ErrOutOfSequence, "nothing eaten")
+	}
+	dest := HexCoord{action.DestI, action.DestJ}
+	_, isbase := game.legacymap.BaseAt(dest)
+	if unit.Position().Distance(dest) != 1 || isbase ||
+		!game.legacymap.TileAt(dest).IsPassable() || game.unit_at(dest) != nil {
+		return illegal(action, ErrUnreachable, "cannot spit to %v", dest)
+	}
+
+	spat := *game.turn.eaten
+	spat.PositionI, spat.PositionJ = uint(dest.Column), uint(dest.Row)
+	spat.HasMoved = true
+	game.Units = append(game.Units, spat)
+	game.turn.eaten = nil
+	game.capture(dest, spat.Team)
+	return nil
+}
+
+// Scramblers convert an adjacent enemy unit to their own side.
+func (game game_rules) scramble(action *ScramblerSpellAction) error {
+	unit, stats, err := game.own_unit(action, action.PawnID)
+	if err != nil {
+		return err
+	}
+	if unit.Class != CLASS_SCRAMBLER {
+		return illegal(action, ErrWrongClass, "%s cannot scramble", unit.Class)
+	}
+	if unit.HasAttacked {
+		return illegal(action, ErrAlreadyAttacked, "unit %d", unit.Identifier)
+	}
+	dest := HexCoord{action.DestI, action.DestJ}
+	target := game.unit_at(dest)
+	if target == nil || target.Team == unit.Team ||
+		unit.Position().Distance(dest) > int(stats.AttackRange) {
+		return illegal(action, ErrInvalidTarget, "no enemy at %v", dest)
+	}
+	target_stats, err := target.Stats()
+	if err != nil {
+		return illegal(action, ErrInvalidTarget, "%s", err)
+	}
+	if err := game.pay(action, game.activation_cost(unit)); err != nil {
+		return err
+	}
+
+	target.Owner, target.Team, target.Color = unit.Owner, unit.Team, unit.Color
+	target.Health = min(target.Health, target_stats.Health)
+	ready(target)
+	game.activate(unit)
+	unit.HasAttacked = true
+	return nil
+}
+
+func (game game_rules) select_spawn(action *SelectSpawnTileAction) error {
+	coord := HexCoord{action.SpawnX, action.SpawnY}
+	for _, tile := range game.legacymap.Init {
+		if tile.I == coord.Column && tile.J == coord.Row &&
+			tile.Type == MAPTILE_SPAWN && uint(tile.Owner) == game.owner() {
+			game.turn.spawn = &coord
+			return nil
+		}
+	}
+	return illegal(action, ErrInvalidSpawn, "%v is not player %d's spawn",
+		coord, game.owner())
+}
+
+func (game game_rules) spawn(action *SpawnUnitAction) error {
+	player := game.player()
+	if game.turn.spawn == nil {
+		return illegal(action, ErrOutOfSequence, "no spawn tile selected")
+	}
+	coord := *game.turn.spawn
+	for _, used := range game.UsedSpawns {
+		if used.SpawnX == coord.Column && used.SpawnY == coord.Row {
+			return illegal(action, ErrInvalidSpawn, "%v already used this turn", coord)
+		}
+	}
+	if game.unit_at(coord) != nil {
+		return illegal(action, ErrInvalidSpawn, "%v is occupied", coord)
+	}
+	if action.Color != PlayerColorEnum(player.Color) {
+		return illegal(action, ErrNotOwner, "%s is not the current player's color",
+			action.Color)
+	}
+	if !slices.Contains(UnitClassesFor(player.UnitRace), action.Role) {
+		return illegal(action, ErrWrongClass, "%s unavailable to %s",
+			action.Role, player.UnitRace)
+	}
+	stats, _ := UnitStatsFor(player.UnitRace, action.Role)
+	if err := game.pay(action, stats.Cost); err != nil {
+		return err
+	}
+
+	game.Units = append(game.Units, UnitStatus{
+		Class:       action.Role,
+		Color:       PlayerColorEnum(player.Color),
+		Health:      stats.Health,
+		Identifier:  uint(game.CurrentPawnID),
+		Owner:       game.owner(),
+		Team:        player.Team,
+		Parent:      -1,
+		SpawnedFrom: -1,
+		PositionI:   uint(coord.Column),
+		PositionJ:   uint(coord.Row),
+		UnitRace:    player.UnitRace})
+	game.CurrentPawnID++
+	game.UsedSpawns = append(game.UsedSpawns, UsedSpawn{coord.Column, coord.Row})
+	game.turn.spawn = nil
+	return nil
+}
@@ -0,0 +1,206 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/rules_test.go
+
+package osn_test
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	osn "github.com/kevindamm/wits-osn"
+)
+
+func read_sample_match(t *testing.T) osn.LegacyMatchWithReplay {
+	var match osn.LegacyMatchWithReplay
+	if err := json.Unmarshal(read_test_replay(t), &match); err != nil {
+		t.Fatal(err)
+	}
+	return match
+}
+
+// Each turn of the sample replay, applied to the state at the start of that
+// turn, should produce the state recorded at the start of the next turn.
+func TestApplySampleTurns(t *testing.T) {
+	match := read_sample_match(t)
+	for i := 0; i+1 < len(match.Replay); i++ {
+		state := match.Replay[i].State
+		for j, action := range match.Replay[i].Actions {
+			var err error
+			state, err = osn.Apply(state, action)
+			if err != nil {
+				t.Fatalf("turn %d action %d: %s", i, j, err)
+			}
+		}
+		got, expected := summarize(state), summarize(match.Replay[i+1].State)
+		if !reflect.DeepEqual(got.Units, expected.Units) {
+			for k := range max(len(got.Units), len(expected.Units)) {
+				if k >= len(got.Units) || k >= len(expected.Units) ||
+					got.Units[k] != expected.Units[k] {
+					t.Errorf("turn %d: units differ at %d", i, k)
+					t.Logf("%+v", got.Units[min(k, len(got.Units)-1)])
+					t.Logf("%+v", expected.Units[min(k, len(expected.Units)-1)])
+					break
+				}
+			}
+		}
+		got.Units, expected.Units = nil, nil
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("turn %d: state\n%+v\nexpected\n%+v", i, got, expected)
+		}
+	}
+}
+
+type state_summary struct {
+	Player, Pawn, Turn, Outcome int
+	Bases                       [2]osn.BaseHealth
+	Wits                        []uint
+	Tiles                       []osn.TileState
+	Spawns                      []osn.UsedSpawn
+	Units                       []osn.UnitStatus
+}
+
+func summarize(state osn.OsnGameState) state_summary {
+	summary := state_summary{
+		Player:  int(state.CurrentPlayer),
+		Pawn:    state.CurrentPawnID,
+		Turn:    state.TurnCount,
+		Outcome: int(state.Outcome),
+		Bases:   [2]osn.BaseHealth{state.Base0_HP, state.Base1_HP},
+		Tiles:   state.CapturedTiles,
+		Spawns:  state.UsedSpawns,
+		Units:   state.Units}
+	for _, settings := range state.Settings {
+		summary.Wits = append(summary.Wits, settings.ActionPoints)
+	}
+	return summary
+}
+
+// The wit economy and base health, checked against the sample replay: every
+// turn starts with 5 wits plus one per captured wit space, the second player's
+// first turn has 3 more, and both bases start with 5 health.  The turns are
+// applied with [osn.Apply], which TestApplySampleTurns shows agrees with the
+// recorded states.
+func TestWitEconomyInSampleReplay(t *testing.T) {
+	match := read_sample_match(t)
+	initial := match.Replay[0].State
+	if initial.Base0_HP != 5 || initial.Base1_HP != 5 {
+		t.Errorf("expected bases to start with 5 health, got %d and %d",
+			initial.Base0_HP, initial.Base1_HP)
+	}
+
+	for i, turn := range match.Replay {
+		if len(turn.Actions) == 0 {
+			continue
+		}
+		if _, ok := turn.Actions[0].(*osn.StartTurnAction); !ok {
+			t.Fatalf("turn %d starts with %s", i, turn.Actions[0].Name())
+		}
+		state := turn.State
+		next, err := osn.Apply(state, turn.Actions[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		player := state.Settings[state.CurrentPlayer]
+		expected := uint(5)
+		for _, tile := range state.CapturedTiles {
+			if tile.Type.Team() == player.Team {
+				expected++
+			}
+		}
+		if i == 1 {
+			expected += 3
+		}
+		if income := next.Settings[state.CurrentPlayer].ActionPoints -
+			player.ActionPoints; income != expected {
+			t.Errorf("turn %d: expected %d wits, got %d", i, expected, income)
+		}
+	}
+}
+
+func TestApplyIllegalActions(t *testing.T) {
+	match := read_sample_match(t)
+	start, err := osn.Apply(match.Replay[0].State, &osn.StartTurnAction{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		action osn.OsnPlayerAction
+		reason error
+	}{
+		{"unknown unit", &osn.MoveUnitAction{PawnID: 99, DestI: 9, DestJ: 3}, osn.ErrUnknownUnit},
+		{"enemy unit", &osn.MoveUnitAction{PawnID: 0, DestI: 1, DestJ: 5}, osn.ErrNotOwner},
+		{"too far", &osn.MoveUnitAction{PawnID: 6, DestI: 6, DestJ: 5}, osn.ErrUnreachable},
+		{"onto rocks", &osn.MoveUnitAction{PawnID: 4, DestI: 8, DestJ: 0}, osn.ErrUnreachable},
+		{"medic attack", &osn.RangeAttackAction{TargetedAction: osn.TargetedAction{PawnID: 7, DestI: 10, DestJ: 4}}, osn.ErrWrongClass},
+		{"no target", &osn.RangeAttackAction{TargetedAction: osn.TargetedAction{PawnID: 4, DestI: 9, DestJ: 2}}, osn.ErrInvalidTarget},
+		{"heal enemy", &osn.ActiveHealAction{TargetedAction: osn.TargetedAction{PawnID: 7, DestI: 1, DestJ: 6}}, osn.ErrInvalidTarget},
+		{"spit first", &osn.SpitAction{TargetedAction: osn.TargetedAction{PawnID: 4, DestI: 9, DestJ: 2}}, osn.ErrOutOfSequence},
+		{"spawn first", &osn.SpawnUnitAction{Role: osn.CLASS_RUNNER, Color: osn.PLAYERCOLOR_BLUE}, osn.ErrOutOfSequence},
+		{"enemy spawn", &osn.SelectSpawnTileAction{SpawnX: 2, SpawnY: 4}, osn.ErrInvalidSpawn},
+		{"unknown", osn.NewPlayerAction("DanceAction"), osn.ErrUnsupportedAction},
+	}
+	for _, tt := range tests {
+		_, err := osn.Apply(start, tt.action)
+		var illegal osn.IllegalActionError
+		if !errors.As(err, &illegal) {
+			t.Errorf("%s: expected IllegalActionError, got %v", tt.name, err)
+			continue
+		}
+		if !errors.Is(err, tt.reason) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.reason, err)
+		}
+	}
+
+	// Moving twice, and spending more wits than the player has.
+	moved, err := osn.Apply(start, &osn.MoveUnitAction{PawnID: 4, DestI: 8, DestJ: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := osn.Apply(moved, &osn.MoveUnitAction{PawnID: 4, DestI: 9, DestJ: 1}); !errors.Is(err, osn.ErrAlreadyMoved) {
+		t.Errorf("expected ErrAlreadyMoved, got %v", err)
+	}
+	spawn, err := osn.Apply(moved, &osn.SelectSpawnTileAction{SpawnX: 10, SpawnY: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := osn.Apply(spawn, &osn.SpawnUnitAction{Role: osn.CLASS_SCRAMBLER, Color: osn.PLAYERCOLOR_BLUE}); !errors.Is(err, osn.ErrInsufficientWits) {
+		t.Errorf("expected ErrInsufficientWits, got %v", err)
+	}
+
+	// The original state is never modified.
+	if !reflect.DeepEqual(start.Units, match.Replay[0].State.Units) {
+		t.Error("Apply modified the units of its input state")
+	}
+	if moved.Settings[0].ActionPoints != start.Settings[0].ActionPoints-1 {
+		t.Errorf("move cost %d wits",
+			start.Settings[0].ActionPoints-moved.Settings[0].ActionPoints)
+	}
+
+	final := match.Replay[len(match.Replay)-1].State
+	if _, err := osn.Apply(final, &osn.StartTurnAction{}); !errors.Is(err, osn.ErrGameOver) {
+		t.Errorf("expected ErrGameOver, got %v", err)
+	}
+}
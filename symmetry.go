@@ -0,0 +1,148 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/symmetry.go
+
+package osn
+
+import (
+	"cmp"
+	"slices"
+)
+
+// An isometry of the hex grid: an optional reflection (across the Q axis
+// through the origin), followed by a clockwise rotation in 60 degree steps and
+// then a translation.  The zero value is the identity.
+type HexTransform struct {
+	Reflect   bool
+	Rotate    int
+	Translate CubeCoord
+}
+
+func (transform HexTransform) IsIdentity() bool {
+	return transform == HexTransform{}
+}
+
+// The transformed position of the coordinate.
+func (transform HexTransform) Apply(coord HexCoord) HexCoord {
+	return transform.linear(coord.Cube()).Add(transform.Translate).Offset()
+}
+
+// The reflection and rotation, without the translation.
+func (transform HexTransform) linear(vec CubeCoord) CubeCoord {
+	if transform.Reflect {
+		vec = CubeCoord{vec.Q, vec.S, vec.R}
+	}
+	for range ((transform.Rotate % 6) + 6) % 6 {
+		vec = CubeCoord{-vec.R, -vec.S, -vec.Q}
+	}
+	return vec
+}
+
+// A transform which maps a map's layout onto itself.  Base and spawn tiles are
+// mapped onto the same kind of tile, though possibly another player's.
+type MapSymmetry struct {
+	HexTransform
+
+	// The owner whose base (and spawns) each owner's base is mapped onto.
+	Owners map[PlayerIndex]PlayerIndex
+}
+
+// True if every player's base is mapped onto their own base.
+func (symmetry MapSymmetry) KeepsOwners() bool {
+	for from, to := range symmetry.Owners {
+		if from != to {
+			return false
+		}
+	}
+	return true
+}
+
+// All of the symmetries of the map's layout, starting with the identity.
+// Only the tile types (and owners) are considered, not their sprites.
+func (details LegacyMapDetails) Symmetries() []MapSymmetry {
+	board := make(map[HexCoord]MapTileInit)
+	for column := range details.Width {
+		for row := range details.Height {
+			coord := HexCoord{column, row}
+			tile := MapTileInit{I: column, J: row, Type: details.TileAt(coord)}
+			if tile.Type != MAPTILE_EMPTY {
+				board[coord] = tile
+			}
+		}
+	}
+	for _, tile := range details.Init {
+		if tile.Type == MAPTILE_BASE || tile.Type == MAPTILE_SPAWN {
+			board[HexCoord{tile.I, tile.J}] = tile
+		}
+	}
+	if len(board) == 0 {
+		return []MapSymmetry{}
+	}
+
+	// The board is translated so that its least coordinate (which is the same
+	// under any translation) lines up with the least coordinate of the original.
+	least := func(coords []CubeCoord) CubeCoord {
+		return slices.MinFunc(coords, func(a, b CubeCoord) int {
+			return cmp.Or(cmp.Compare(a.Q, b.Q), cmp.Compare(a.R, b.R))
+		})
+	}
+	original := make([]CubeCoord, 0, len(board))
+	for coord := range board {
+		original = append(original, coord.Cube())
+	}
+	anchor := least(original)
+
+	symmetries := make([]MapSymmetry, 0)
+	for _, reflect := range []bool{false, true} {
+		for rotate := range 6 {
+			transform := HexTransform{Reflect: reflect, Rotate: rotate}
+			moved := make([]CubeCoord, len(original))
+			for i, cube := range original {
+				moved[i] = transform.linear(cube)
+			}
+			transform.Translate = anchor.Sub(least(moved))
+			if owners, ok := maps_onto(board, transform); ok {
+				symmetries = append(symmetries, MapSymmetry{transform, owners})
+			}
+		}
+	}
+	return symmetries
+}
+
+// Checks that the transform maps each tile of the board onto a tile of the same
+// type, with base and spawn owners consistently mapped to one another.
+func maps_onto(board map[HexCoord]MapTileInit, transform HexTransform) (map[PlayerIndex]PlayerIndex, bool) {
+	owners := make(map[PlayerIndex]PlayerIndex)
+	for coord, tile := range board {
+		image, ok := board[transform.Apply(coord)]
+		if !ok || image.Type != tile.Type {
+			return nil, false
+		}
+		if tile.Type != MAPTILE_BASE && tile.Type != MAPTILE_SPAWN {
+			continue
+		}
+		if owner, seen := owners[tile.Owner]; seen && owner != image.Owner {
+			return nil, false
+		}
+		owners[tile.Owner] = image.Owner
+	}
+	return owners, true
+}
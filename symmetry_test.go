@@ -0,0 +1,91 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/symmetry_test.go
+
+package osn_test
+
+import (
+	"testing"
+
+	osn "github.com/kevindamm/wits-osn"
+)
+
+func TestMapSymmetries(t *testing.T) {
+	tests := []struct {
+		name    string
+		count   int
+		reflect bool
+	}{
+		{"Sweet Tooth", 2, false},
+		{"Long Nine", 2, true},
+		{"Thorn Gulley", 1, false},
+		{"Machination", 2, false},
+	}
+	for _, tt := range tests {
+		legacymap, err := osn.MapByName(tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		symmetries := legacymap.Symmetries()
+		if len(symmetries) != tt.count {
+			t.Errorf("%s: %d symmetries, expected %d", tt.name, len(symmetries), tt.count)
+			continue
+		}
+		if !symmetries[0].IsIdentity() || !symmetries[0].KeepsOwners() {
+			t.Errorf("%s: expected the identity first, got %+v", tt.name, symmetries[0])
+		}
+		for _, symmetry := range symmetries[1:] {
+			if symmetry.Reflect != tt.reflect {
+				t.Errorf("%s: unexpected symmetry %+v", tt.name, symmetry)
+			}
+			if symmetry.KeepsOwners() {
+				t.Errorf("%s: expected the bases to be exchanged", tt.name)
+			}
+			for _, tile := range legacymap.Init {
+				coord := osn.HexCoord{Column: tile.I, Row: tile.J}
+				image := symmetry.Apply(coord)
+				if legacymap.TileAt(image) != legacymap.TileAt(coord) {
+					t.Errorf("%s: %s tile at %v mapped onto %s", tt.name,
+						legacymap.TileAt(coord), coord, legacymap.TileAt(image))
+				}
+			}
+		}
+	}
+}
+
+func TestHexTransform(t *testing.T) {
+	coord := osn.HexCoord{Column: 3, Row: 4}
+	center := osn.HexCoord{}
+	if image := (osn.HexTransform{}).Apply(coord); image != coord {
+		t.Errorf("identity moved %v to %v", coord, image)
+	}
+	rotated := osn.HexTransform{Rotate: 2}.Apply(coord)
+	if expected := coord.RotateAround(center, 2); rotated != expected {
+		t.Errorf("rotated to %v, expected %v", rotated, expected)
+	}
+	reflected := osn.HexTransform{Reflect: true}.Apply(coord)
+	if expected := coord.ReflectAround(center, osn.AXIS_Q); reflected != expected {
+		t.Errorf("reflected to %v, expected %v", reflected, expected)
+	}
+	if twice := (osn.HexTransform{Rotate: 3}).Apply(osn.HexTransform{Rotate: -3}.Apply(coord)); twice != coord {
+		t.Errorf("rotating back and forth moved %v to %v", coord, twice)
+	}
+}
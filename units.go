@@ -22,6 +22,8 @@
 
 package osn
 
+import "fmt"
+
 type UnitStatus struct {
 	AltHealth uint            `json:"altHealth"`
 	Class     UnitClass       `json:"class"`
@@ -45,5 +47,148 @@ type UnitStatus struct {
 	UnitRace  UnitRaceEnum `json:"race"`
 }
 
-// TODO populate enum
-type UnitClass int
+// Enumeration of unit classes, as found in `class` of units and `role` of
+// spawn actions.  The five basic classes are available to every race, each race
+// additionally has its own special unit (see [UnitSpecialEnum]).
+type UnitClass uint8
+
+const (
+	CLASS_UNKNOWN UnitClass = iota
+	CLASS_SOLDIER
+	CLASS_RUNNER
+	CLASS_HEAVY
+	CLASS_SNIPER
+	CLASS_MEDIC
+	CLASS_SCRAMBLER
+	CLASS_MOBI
+	CLASS_BOMBSHELL
+	CLASS_BRAMBLE
+	UnitClassRange
+)
+
+func (class UnitClass) IsValid() bool {
+	return class < UnitClassRange
+}
+
+var class_names = []string{
+	"UNKNOWN",
+	"Soldier",
+	"Runner",
+	"Heavy",
+	"Sniper",
+	"Medic",
+	"Scrambler",
+	"Mobi",
+	"Bombshell",
+	"Bramble",
+}
+
+func (class UnitClass) String() string {
+	if !class.IsValid() {
+		return CLASS_UNKNOWN.String()
+	}
+	return class_names[class]
+}
+
+// Returns the special this class represents, or SPECIAL_UNKNOWN if it is one
+// of the basic classes (or is not a valid class).
+func (class UnitClass) Special() UnitSpecialEnum {
+	if class < CLASS_SCRAMBLER || !class.IsValid() {
+		return SPECIAL_UNKNOWN
+	}
+	return UnitSpecialEnum(class - CLASS_SCRAMBLER + 1)
+}
+
+// The unit class for this race's special unit.
+func (special UnitSpecialEnum) Class() UnitClass {
+	if special == SPECIAL_UNKNOWN || !special.IsValid() {
+		return CLASS_UNKNOWN
+	}
+	return UnitClass(special) + CLASS_SCRAMBLER - 1
+}
+
+// The properties of a unit class, for a specific race.
+//
+// Ranges are in hex distance.  Units with an alternate form (see IsAlt in
+// [UnitStatus]) have a non-nil Alt describing the stats while transformed.
+type UnitStats struct {
+	Cost        uint // wits spent when spawning this unit
+	Health      uint // health when spawned
+	MoveRange   uint
+	AttackRange uint
+	Damage      uint // zero for units that do not attack (e.g. the medic)
+
+	Alt *UnitStats
+}
+
+// Basic units have the same stats regardless of race.
+//
+// Only the sample replay in testdata (Feedback against Adorables) has been
+// checked against this catalog, see TestUnitStatsInSampleReplay for the values
+// it confirms.  The costs of the Heavy and Medic, the Sniper's move range and
+// the Soldier's and Heavy's damage are unconfirmed, as is every stat of the
+// Scallywag and Veggienaut specials below.
+var basic_stats = map[UnitClass]UnitStats{
+	CLASS_SOLDIER: {Cost: 2, Health: 3, MoveRange: 3, AttackRange: 1, Damage: 2},
+	CLASS_RUNNER:  {Cost: 1, Health: 1, MoveRange: 5, AttackRange: 1, Damage: 1},
+	CLASS_HEAVY:   {Cost: 4, Health: 4, MoveRange: 2, AttackRange: 1, Damage: 3},
+	CLASS_SNIPER:  {Cost: 3, Health: 1, MoveRange: 2, AttackRange: 3, Damage: 3},
+	CLASS_MEDIC:   {Cost: 2, Health: 1, MoveRange: 3, AttackRange: 1, Damage: 0},
+}
+
+// Special units, indexed by the race they belong to.  The attack range of the
+// Scrambler and the Mobi are the range of their spell and their swallow.
+// The Bombshell transforms into a stationary, longer-ranged alternate form.
+var special_stats = map[UnitRaceEnum]UnitStats{
+	RACE_FEEDBACK:  {Cost: 7, Health: 2, MoveRange: 3, AttackRange: 1, Damage: 0},
+	RACE_ADORABLES: {Cost: 7, Health: 2, MoveRange: 3, AttackRange: 4, Damage: 0},
+	RACE_SCALLYWAGS: {Cost: 7, Health: 3, MoveRange: 2, AttackRange: 1, Damage: 1,
+		Alt: &UnitStats{Cost: 0, Health: 3, MoveRange: 0, AttackRange: 4, Damage: 2}},
+	RACE_VEGGIENAUTS: {Cost: 7, Health: 2, MoveRange: 3, AttackRange: 1, Damage: 1},
+}
+
+// Looks up the stats for a unit of the given race and class.  Returns an error
+// if the class is unknown or is a special unit that belongs to another race.
+func UnitStatsFor(race UnitRaceEnum, class UnitClass) (UnitStats, error) {
+	if race == RACE_UNKNOWN || !race.IsValid() {
+		return UnitStats{}, fmt.Errorf("invalid race %d", race)
+	}
+	if stats, ok := basic_stats[class]; ok {
+		return stats, nil
+	}
+	if class.Special() != UnitSpecialEnum(race) {
+		return UnitStats{}, fmt.Errorf("unit class %s unavailable to race %s",
+			class, race)
+	}
+	return special_stats[race], nil
+}
+
+// The unit classes that the indicated race can spawn, basic classes first.
+func UnitClassesFor(race UnitRaceEnum) []UnitClass {
+	if race == RACE_UNKNOWN || !race.IsValid() {
+		return []UnitClass{}
+	}
+	return []UnitClass{
+		CLASS_SOLDIER,
+		CLASS_RUNNER,
+		CLASS_HEAVY,
+		CLASS_SNIPER,
+		CLASS_MEDIC,
+		UnitSpecialEnum(race).Class(),
+	}
+}
+
+// Stats for a unit in its current form (its alternate form if IsAlt is set).
+func (unit UnitStatus) Stats() (UnitStats, error) {
+	stats, err := UnitStatsFor(unit.UnitRace, unit.Class)
+	if err != nil {
+		return stats, err
+	}
+	if unit.IsAlt {
+		if stats.Alt == nil {
+			return stats, fmt.Errorf("unit class %s has no alternate form", unit.Class)
+		}
+		return *stats.Alt, nil
+	}
+	return stats, nil
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github.com/kevindamm/wits-osn/units_test.go
+
+package osn_test
+
+import (
+	"testing"
+
+	osn "github.com/kevindamm/wits-osn"
+)
+
+func TestUnitClassSpecials(t *testing.T) {
+	for special := osn.SPECIAL_SCRAMBLER; special < osn.UnitSpecialRange; special++ {
+		class := special.Class()
+		if class.Special() != special {
+			t.Errorf("special %s -> class %s -> special %s",
+				special, class, class.Special())
+		}
+		if class.String() != special.String() {
+			t.Errorf("class name %s differs from special name %s", class, special)
+		}
+	}
+	if osn.CLASS_SNIPER.Special() != osn.SPECIAL_UNKNOWN {
+		t.Errorf("basic class should not have a special")
+	}
+}
+
+func TestUnitStatsFor(t *testing.T) {
+	for race := osn.RACE_FEEDBACK; race < osn.UnitRaceRange; race++ {
+		classes := osn.UnitClassesFor(race)
+		if len(classes) != 6 {
+			t.Errorf("race %s has %d unit classes", race, len(classes))
+		}
+		for _, class := range classes {
+			stats, err := osn.UnitStatsFor(race, class)
+			if err != nil {
+				t.Error(err)
+			}
+			if stats.Cost == 0 || stats.Health == 0 {
+				t.Errorf("incomplete stats for %s %s: %v", race, class, stats)
+			}
+		}
+	}
+
+	if _, err := osn.UnitStatsFor(osn.RACE_FEEDBACK, osn.CLASS_MOBI); err == nil {
+		t.Error("expected error for Feedback spawning a Mobi")
+	}
+	if _, err := osn.UnitStatsFor(osn.RACE_UNKNOWN, osn.CLASS_RUNNER); err == nil {
+		t.Error("expected error for unknown race")
+	}
+
+	bombshell := osn.UnitStatus{
+		Class: osn.CLASS_BOMBSHELL, UnitRace: osn.RACE_SCALLYWAGS, IsAlt: true}
+	stats, err := bombshell.Stats()
+	if err != nil || stats.MoveRange != 0 {
+		t.Errorf("expected stationary alternate form for bombshell: %v, %s",
+			stats, err)
+	}
+}
+
+// The stats which the sample replay gives evidence for, the rest of the catalog
+// is unconfirmed.  Each is the largest value seen in the replay: wits spent on
+// a spawn, health when spawned (or at the start of the match), steps taken by a
+// move, distance of an attack (or heal, swallow or spell) and health lost by a
+// target that survives the attack.  Sniper moves fall short of their range and
+// every unit attacked by a Soldier or Heavy was destroyed, so those stats are
+// not pinned.  The actions are applied with [osn.Apply], which
+// TestApplySampleTurns shows agrees with the recorded states.
+func TestUnitStatsInSampleReplay(t *testing.T) {
+	confirmed := map[osn.UnitClass]osn.UnitStats{
+		osn.CLASS_SOLDIER:   {Cost: 2, Health: 3, MoveRange: 3, AttackRange: 1},
+		osn.CLASS_RUNNER:    {Cost: 1, Health: 1, MoveRange: 5, AttackRange: 1, Damage: 1},
+		osn.CLASS_HEAVY:     {Health: 4, MoveRange: 2, AttackRange: 1},
+		osn.CLASS_SNIPER:    {Cost: 3, Health: 1, AttackRange: 3, Damage: 3},
+		osn.CLASS_MEDIC:     {Health: 1, MoveRange: 3, AttackRange: 1},
+		osn.CLASS_SCRAMBLER: {Cost: 7, Health: 2, MoveRange: 3, AttackRange: 1},
+		osn.CLASS_MOBI:      {Cost: 7, Health: 2, MoveRange: 3, AttackRange: 4},
+	}
+
+	match := read_sample_match(t)
+	observed := make(map[osn.UnitClass]osn.UnitStats)
+	observe := func(class osn.UnitClass, update func(*osn.UnitStats)) {
+		stats := observed[class]
+		update(&stats)
+		observed[class] = stats
+	}
+	// Heals, swallows and spells are limited by the unit's attack range.
+	observe_reach := func(units []osn.UnitStatus, target osn.TargetedAction) {
+		unit := find_pawn(units, target.PawnID)
+		dest := osn.HexCoord{Column: target.DestI, Row: target.DestJ}
+		observe(unit.Class, func(stats *osn.UnitStats) {
+			stats.AttackRange = max(stats.AttackRange,
+				uint(unit.Position().Distance(dest)))
+		})
+	}
+	for _, unit := range match.Replay[0].State.Units {
+		observe(unit.Class, func(stats *osn.UnitStats) {
+			stats.Health = max(stats.Health, unit.Health)
+		})
+	}
+	for _, turn := range match.Replay {
+		state := turn.State
+		legacymap, err := osn.MapByName(state.MapName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, action := range turn.Actions {
+			next, err := osn.Apply(state, action)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch action := action.(type) {
+			case *osn.SpawnUnitAction:
+				spawned := find_pawn(next.Units, int(state.CurrentPawnID))
+				spent := state.Settings[state.CurrentPlayer].ActionPoints -
+					next.Settings[state.CurrentPlayer].ActionPoints
+				observe(action.Role, func(stats *osn.UnitStats) {
+					stats.Cost = max(stats.Cost, spent)
+					stats.Health = max(stats.Health, spawned.Health)
+				})
+			case *osn.MoveUnitAction:
+				unit := find_pawn(state.Units, action.PawnID)
+				if action.Cancelled {
+					break
+				}
+				dest := osn.HexCoord{Column: action.DestI, Row: action.DestJ}
+				steps, err := osn.NewMovementRange(
+					legacymap, state.Units, *unit, 99).Cost(dest)
+				if err != nil {
+					t.Fatal(err)
+				}
+				observe(unit.Class, func(stats *osn.UnitStats) {
+					stats.MoveRange = max(stats.MoveRange, uint(steps))
+				})
+			case *osn.ActiveHealAction:
+				observe_reach(state.Units, action.TargetedAction)
+			case *osn.EatAction:
+				observe_reach(state.Units, action.TargetedAction)
+			case *osn.ScramblerSpellAction:
+				observe_reach(state.Units, action.TargetedAction)
+			case *osn.RangeAttackAction:
+				unit := find_pawn(state.Units, action.PawnID)
+				dest := osn.HexCoord{Column: action.DestI, Row: action.DestJ}
+				var damage uint
+				for _, target := range state.Units {
+					if target.Position() != dest {
+						continue
+					}
+					if survivor := find_pawn(next.Units, int(target.Identifier)); survivor != nil {
+						damage = target.Health - survivor.Health
+					}
+				}
+				observe(unit.Class, func(stats *osn.UnitStats) {
+					stats.AttackRange = max(stats.AttackRange,
+						uint(unit.Position().Distance(dest)))
+					stats.Damage = max(stats.Damage, damage)
+				})
+			}
+			state = next
+		}
+	}
+
+	for class, expected := range confirmed {
+		race := osn.RACE_FEEDBACK
+		if class.Special() != osn.SPECIAL_UNKNOWN {
+			race = osn.UnitRaceEnum(class.Special())
+		}
+		stats, err := osn.UnitStatsFor(race, class)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range []struct {
+			name                      string
+			expected, observed, stats uint
+		}{
+			{"cost", expected.Cost, observed[class].Cost, stats.Cost},
+			{"health", expected.Health, observed[class].Health, stats.Health},
+			{"move range", expected.MoveRange, observed[class].MoveRange, stats.MoveRange},
+			{"attack range", expected.AttackRange, observed[class].AttackRange, stats.AttackRange},
+			{"damage", expected.Damage, observed[class].Damage, stats.Damage},
+		} {
+			if field.expected == 0 {
+				continue
+			}
+			if field.observed != field.expected {
+				t.Errorf("%s %s observed as %d, expected %d",
+					class, field.name, field.observed, field.expected)
+			}
+			if field.stats != field.expected {
+				t.Errorf("%s %s is %d in the catalog, observed %d",
+					class, field.name, field.stats, field.expected)
+			}
+		}
+	}
+	if len(observed) != len(confirmed) {
+		t.Errorf("observed %d unit classes, expected %d", len(observed), len(confirmed))
+	}
+}
+
+func find_pawn(units []osn.UnitStatus, pawnID int) *osn.UnitStatus {
+	for i := range units {
+		if int(units[i].Identifier) == pawnID {
+			return &units[i]
+		}
+	}
+	return nil
+}
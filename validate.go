@@ -0,0 +1,245 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/validate.go
+
+package osn
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// The first point at which re-simulating a replay disagrees with the game
+// states it recorded.  Returned as an error by [ValidateReplay].
+type ReplayDivergence struct {
+	// Index of the turn (in the match's Replay) whose actions were being applied.
+	// A divergence in the final state is reported for the last turn.
+	Turn int
+
+	// Index of the action within that turn which failed to apply, or which was
+	// the last action to change the divergent field.  It is -1 when no single
+	// action can be blamed (e.g. an effect that never happened).
+	Action int
+
+	// Path to the first field that differs, using the names of the JSON
+	// encoding (e.g. "hp_base0" or "units[id=4].health").  Empty when an action
+	// could not be applied.
+	Field string
+
+	Got, Expected string
+
+	// The reason an action could not be applied, usually an [IllegalActionError].
+	Err error
+}
+
+func (div ReplayDivergence) Error() string {
+	if div.Err != nil {
+		return fmt.Sprintf("turn %d, action %d: %s", div.Turn, div.Action, div.Err)
+	}
+	return fmt.Sprintf("turn %d, action %d: %s is %s (recorded %s)",
+		div.Turn, div.Action, div.Field, div.Got, div.Expected)
+}
+
+func (div ReplayDivergence) Unwrap() error {
+	return div.Err
+}
+
+var ErrEmptyReplay = errors.New("replay has no turns")
+
+// Re-simulates every turn of the replay, starting from the state recorded with
+// its first turn, and compares the result after each turn with the state that
+// was recorded at the start of the next one (and, after the last turn, with the
+// final state of the match).  Returns nil if the replay is consistent, or a
+// [ReplayDivergence] describing the first difference found.
+func ValidateReplay(match LegacyMatchWithReplay) error {
+	if len(match.Replay) == 0 {
+		return ErrEmptyReplay
+	}
+	if div := check_initial(match.Replay[0].State); div != nil {
+		return *div
+	}
+
+	state := match.Replay[0].State
+	for i, turn := range match.Replay {
+		// Game states between each of this turn's actions, for blaming a field.
+		states := make([]OsnGameState, 0, len(turn.Actions)+1)
+		states = append(states, state)
+		for j, action := range turn.Actions {
+			next, err := Apply(state, action)
+			if err != nil {
+				return ReplayDivergence{Turn: i, Action: j, Err: err}
+			}
+			state = next
+			states = append(states, state)
+		}
+
+		expected := match.OsnGameState
+		if i+1 < len(match.Replay) {
+			expected = match.Replay[i+1].State
+		}
+		diffs := diff_states(state, expected)
+		if len(diffs) == 0 {
+			continue
+		}
+		first := diffs[0]
+		return ReplayDivergence{
+			Turn:     i,
+			Action:   blame(states, first.field),
+			Field:    first.field,
+			Got:      first.got,
+			Expected: first.expected}
+	}
+	return nil
+}
+
+// Sanity checks on the first recorded state, which the simulation trusts: it
+// belongs to a known map, no bases have been damaged and every unit is placed
+// on a tile that units can stand on.
+func check_initial(state OsnGameState) *ReplayDivergence {
+	legacymap, err := MapByName(state.MapName)
+	if err != nil {
+		return &ReplayDivergence{Turn: 0, Action: -1, Field: "mapName", Err: err}
+	}
+	for i, hp := range []BaseHealth{state.Base0_HP, state.Base1_HP} {
+		if hp != base_health {
+			return &ReplayDivergence{Turn: 0, Action: -1,
+				Field:    fmt.Sprintf("hp_base%d", i),
+				Got:      fmt.Sprint(hp),
+				Expected: fmt.Sprint(base_health)}
+		}
+	}
+	for _, unit := range state.Units {
+		position := unit.Position()
+		_, on_base := legacymap.BaseAt(position)
+		if on_base || !legacymap.TileAt(position).IsPassable() {
+			return &ReplayDivergence{Turn: 0, Action: -1,
+				Field:    fmt.Sprintf("units[id=%d].position", unit.Identifier),
+				Got:      fmt.Sprint(position),
+				Expected: "a passable tile"}
+		}
+	}
+	return nil
+}
+
+// The last action of the turn which changed the indicated field, or -1.
+func blame(states []OsnGameState, field string) int {
+	for j := len(states) - 1; j > 0; j-- {
+		for _, diff := range diff_states(states[j-1], states[j]) {
+			if diff.field == field {
+				return j - 1
+			}
+		}
+	}
+	return -1
+}
+
+type field_diff struct {
+	field         string
+	got, expected string
+}
+
+// All of the differences between two game states, in field order.  Units are
+// matched by their identifier (rather than by index) and the GameOverData is
+// ignored, it is only present in the match's final state.
+func diff_states(got, expected OsnGameState) []field_diff {
+	diffs := make([]field_diff, 0)
+
+	gotval, expectval := reflect.ValueOf(got), reflect.ValueOf(expected)
+	statetype := gotval.Type()
+	for i := range statetype.NumField() {
+		field := statetype.Field(i)
+		name := json_name(field)
+		if name == "" || name == "gameOverData" || name == "units" {
+			continue
+		}
+		diffs = diff_values(diffs, name, gotval.Field(i), expectval.Field(i))
+	}
+
+	got_units := make(map[uint]UnitStatus)
+	for _, unit := range got.Units {
+		got_units[unit.Identifier] = unit
+	}
+	for _, unit := range expected.Units {
+		path := fmt.Sprintf("units[id=%d]", unit.Identifier)
+		other, ok := got_units[unit.Identifier]
+		if !ok {
+			diffs = append(diffs, field_diff{path, "missing", "present"})
+			continue
+		}
+		delete(got_units, unit.Identifier)
+		diffs = diff_values(diffs, path,
+			reflect.ValueOf(other), reflect.ValueOf(unit))
+	}
+	for _, unit := range got.Units {
+		if _, extra := got_units[unit.Identifier]; extra {
+			diffs = append(diffs, field_diff{
+				fmt.Sprintf("units[id=%d]", unit.Identifier), "present", "missing"})
+		}
+	}
+	return diffs
+}
+
+// Appends the differences between two values of the same type to diffs.
+// Structs and slices are compared element-wise, other kinds by equality.
+func diff_values(diffs []field_diff, path string, got, expected reflect.Value) []field_diff {
+	switch got.Kind() {
+	case reflect.Struct:
+		for i := range got.NumField() {
+			name := json_name(got.Type().Field(i))
+			if name == "" {
+				continue
+			}
+			diffs = diff_values(diffs, path+"."+name, got.Field(i), expected.Field(i))
+		}
+	case reflect.Slice:
+		if got.Len() != expected.Len() {
+			return append(diffs, field_diff{path + ".length",
+				fmt.Sprint(got.Len()), fmt.Sprint(expected.Len())})
+		}
+		for i := range got.Len() {
+			diffs = diff_values(diffs, fmt.Sprintf("%s[%d]", path, i),
+				got.Index(i), expected.Index(i))
+		}
+	default:
+		if !got.Equal(expected) {
+			diffs = append(diffs, field_diff{path,
+				fmt.Sprint(got.Interface()), fmt.Sprint(expected.Interface())})
+		}
+	}
+	return diffs
+}
+
+// The name of a field in its JSON encoding, or "" if it isn't encoded.
+func json_name(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
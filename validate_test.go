@@ -0,0 +1,109 @@
+// Copyright (c) 2024 Kevin Damm
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// github:kevindamm/wits-osn/validate_test.go
+
+package osn_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	osn "github.com/kevindamm/wits-osn"
+)
+
+func TestValidateSampleReplay(t *testing.T) {
+	filedata, err := os.ReadFile(testReplayPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	match, err := osn.DecodeReplay(filedata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match.MatchHash == osn.UNKNOWN_MATCH_ID {
+		t.Error("expected the match ID from the replay's room")
+	}
+	if err := osn.ValidateReplay(match); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateCorruptReplay(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(*osn.LegacyMatchWithReplay)
+		turn    int
+		action  int
+		field   string
+		illegal bool
+	}{
+		{"healed unit", func(match *osn.LegacyMatchWithReplay) {
+			match.Replay[5].State.Units[9].Health = 3 // unit 12
+		}, 4, 6, "units[id=12].health", false},
+		{"untouched unit", func(match *osn.LegacyMatchWithReplay) {
+			match.Replay[5].State.Units[0].Health = 2 // unit 0
+		}, 4, -1, "units[id=0].health", false},
+		{"idle player's wits", func(match *osn.LegacyMatchWithReplay) {
+			match.Replay[3].State.Settings[1].ActionPoints += 1
+		}, 2, -1, "settings[1].actionPoints", false},
+		{"final state", func(match *osn.LegacyMatchWithReplay) {
+			match.OsnGameState.Outcome = 0
+		}, 14, -1, "outcome", false},
+		{"unreachable move", func(match *osn.LegacyMatchWithReplay) {
+			match.Replay[4].Actions[2] = &osn.MoveUnitAction{PawnID: 10, DestI: 0, DestJ: 0}
+		}, 4, 2, "", true},
+	}
+	for _, tt := range tests {
+		match := read_sample_match(t)
+		tt.corrupt(&match)
+
+		err := osn.ValidateReplay(match)
+		var div osn.ReplayDivergence
+		if !errors.As(err, &div) {
+			t.Errorf("%s: expected a ReplayDivergence, got %v", tt.name, err)
+			continue
+		}
+		if div.Turn != tt.turn || div.Action != tt.action || div.Field != tt.field {
+			t.Errorf("%s: divergence at turn %d action %d field %q, expected %d %d %q",
+				tt.name, div.Turn, div.Action, div.Field, tt.turn, tt.action, tt.field)
+		}
+		var illegal osn.IllegalActionError
+		if errors.As(err, &illegal) != tt.illegal {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+	}
+}
+
+func TestValidateInitialState(t *testing.T) {
+	match := read_sample_match(t)
+	match.Replay[0].State.Base1_HP = 4
+	err := osn.ValidateReplay(match)
+	var div osn.ReplayDivergence
+	if !errors.As(err, &div) || div.Turn != 0 || div.Field != "hp_base1" {
+		t.Errorf("expected a divergence in the initial base health, got %v", err)
+	}
+
+	match.Replay = nil
+	if err := osn.ValidateReplay(match); !errors.Is(err, osn.ErrEmptyReplay) {
+		t.Errorf("expected ErrEmptyReplay, got %v", err)
+	}
+}